@@ -0,0 +1,106 @@
+package main
+
+import (
+	"OpenAuth/pkg/audit"
+	"OpenAuth/pkg/configServer"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Sources of configuration changes recorded in the audit trail
+const (
+	auditSourcePush      = "/config"
+	auditSourceConfigMap = "configmap"
+	auditSourceAuthRoute = "authroute"
+)
+
+// setupAudit attaches the audit file and the Kubernetes Event target
+func (rm *RouterManager) setupAudit(opts ServerOptions) error {
+	if opts.AuditLogFile != "" {
+		file, err := os.OpenFile(opts.AuditLogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %v", err)
+		}
+		rm.auditLog = audit.NewLog(opts.AuditLogSize, file)
+	} else {
+		rm.auditLog = audit.NewLog(opts.AuditLogSize, nil)
+	}
+
+	if opts.DeploymentName != "" && opts.DeploymentNamespace != "" {
+		rm.eventRecorder = rm.k8sClient.NewEventRecorder("openauth")
+		rm.deploymentRef = rm.k8sClient.DeploymentReference(opts.DeploymentNamespace, opts.DeploymentName)
+	}
+	return nil
+}
+
+// recordConfigChange appends an audit record and emits a Kubernetes Event
+// on the OpenAuth Deployment for an applied or rejected configuration.
+func (rm *RouterManager) recordConfigChange(source, caller string, data []byte,
+	oldConfig, newConfig *configServer.Config, applyErr error) {
+
+	record := audit.Record{
+		Source:     source,
+		Caller:     caller,
+		ConfigHash: audit.Hash(data),
+		Result:     audit.ResultApplied,
+		Diff:       audit.DiffConfig(oldConfig, newConfig),
+	}
+	if applyErr != nil {
+		record.Result = audit.ResultRejected
+		record.Error = applyErr.Error()
+	}
+
+	if rm.auditLog != nil {
+		record = rm.auditLog.Append(record)
+	}
+	log.Infof("Audit: %s", record)
+
+	if rm.eventRecorder == nil || rm.deploymentRef == nil {
+		return
+	}
+	if applyErr != nil {
+		rm.eventRecorder.Event(rm.deploymentRef, corev1.EventTypeWarning, "ConfigRejected", record.String())
+	} else {
+		rm.eventRecorder.Event(rm.deploymentRef, corev1.EventTypeNormal, "ConfigApplied", record.String())
+	}
+}
+
+// handleAuditQuery serves GET /config/audit
+// query: caller, source, result (applied|rejected), since (RFC3339), limit (default 100)
+func (rm *RouterManager) handleAuditQuery(c *gin.Context) {
+	query := audit.Query{
+		Caller: c.Query("caller"),
+		Source: c.Query("source"),
+		Result: c.Query("result"),
+		Limit:  100,
+	}
+
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid since: %v", err)})
+			return
+		}
+		query.Since = t
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		query.Limit = n
+	}
+
+	if rm.auditLog == nil {
+		c.JSON(http.StatusOK, gin.H{"records": []audit.Record{}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"records": rm.auditLog.Find(query)})
+}
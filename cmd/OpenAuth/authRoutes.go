@@ -0,0 +1,72 @@
+package main
+
+import (
+	"OpenAuth/pkg/audit"
+	"OpenAuth/pkg/configServer"
+	"OpenAuth/pkg/routeController"
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// StartRouteController merges AuthRoute/AuthPolicy resources of the given
+// namespace (all namespaces when empty) into the live configuration.
+func (rm *RouterManager) StartRouteController(ctx context.Context, namespace string) error {
+	controller, err := routeController.NewController(rm.k8sClient.RestConfig(), routeController.Options{
+		Namespace: namespace,
+		Validate:  rm.ValidateRoute,
+		OnChange:  rm.setResourceRoutes,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create AuthRoute controller: %v", err)
+	}
+
+	rm.mu.Lock()
+	rm.routeController = controller
+	rm.mu.Unlock()
+
+	log.Infof("Watching AuthRoute resources (namespace: %q)", namespace)
+	return controller.Run(ctx)
+}
+
+// setResourceRoutes replaces the routes owned by AuthRoute resources and
+// returns the ones that are not served
+func (rm *RouterManager) setResourceRoutes(routes []configServer.RouteConfig) map[string]routeController.Rejection {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	oldRoutes := rm.resourceRoutes
+	rm.resourceRoutes = routes
+
+	// the JWT keys come from the base configuration, serve nothing before it
+	if rm.config == nil {
+		log.Infof("Received %d AuthRoutes, waiting for the base configuration", len(routes))
+		return nil
+	}
+
+	// policy-only changes may leave the merged routes untouched
+	if reflect.DeepEqual(oldRoutes, routes) {
+		return rm.resourceRejected
+	}
+
+	data := audit.RoutesDocument(routes)
+	oldConfig := &configServer.Config{Routes: oldRoutes}
+	newConfig := &configServer.Config{Routes: routes}
+
+	err := rm.applyConfig(rm.config, routes)
+	rm.recordConfigChange(auditSourceAuthRoute, "authroute-controller", data, oldConfig, newConfig, err)
+	if err != nil {
+		// the previous engine stays, none of the new routes is served
+		log.Errorf("Failed to apply AuthRoutes: %v", err)
+		rejected := make(map[string]routeController.Rejection, len(routes))
+		for _, route := range routes {
+			rejected[route.Method+" "+route.Path] = routeController.Rejection{
+				Reason:  routeController.ReasonInvalidSpec,
+				Message: fmt.Sprintf("configuration was not applied: %v", err),
+			}
+		}
+		return rejected
+	}
+	log.Infof("Applied %d AuthRoutes, %d not served", len(routes)-len(rm.resourceRejected), len(rm.resourceRejected))
+	return rm.resourceRejected
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"OpenAuth/pkg/configServer/filters"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleCacheFlush drops the cached remote responses of one filter
+func (rm *RouterManager) handleCacheFlush(c *gin.Context) {
+	name := c.Param("filter")
+	flushed, ok := filters.FlushCache(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("filter %q has no cache", name)})
+		return
+	}
+	log.Infof("Response cache of filter %s flushed by %s (%d entries)", name, c.GetString("username"), flushed)
+	c.JSON(http.StatusOK, gin.H{"filter": name, "flushed": flushed})
+}
+
+// handleCacheFlushAll drops the cached remote responses of every filter
+func (rm *RouterManager) handleCacheFlushAll(c *gin.Context) {
+	flushed := filters.FlushCaches()
+	log.Infof("Response caches flushed by %s (%d entries)", c.GetString("username"), flushed)
+	c.JSON(http.StatusOK, gin.H{"flushed": flushed})
+}
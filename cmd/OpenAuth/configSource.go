@@ -0,0 +1,57 @@
+package main
+
+import (
+	"OpenAuth/pkg/configServer"
+	"OpenAuth/pkg/k8sQuery"
+	"context"
+	"fmt"
+)
+
+// Secret keys read from the ConfigSource Secret
+const (
+	secretKeyJWTSecret = "secret_key"
+)
+
+// WatchConfigSource loads the configuration from a ConfigMap and Secret and
+// re-applies it through UpdateConfig whenever either object changes, so every
+// replica converges without pushes to /config.
+func (rm *RouterManager) WatchConfigSource(ctx context.Context, source k8sQuery.ConfigSource) error {
+	log.Infof("Loading configuration from ConfigMap %s/%s", source.Namespace, source.ConfigMap)
+	caller := fmt.Sprintf("configmap/%s/%s", source.Namespace, source.ConfigMap)
+	return rm.k8sClient.WatchConfig(ctx, source, func(data k8sQuery.ConfigData) {
+		oldConfig := rm.GetConfig()
+
+		newConfig, err := buildConfigFromSource(data)
+		if err != nil {
+			log.Errorf("Rejected configuration from ConfigMap %s/%s: %v", source.Namespace, source.ConfigMap, err)
+			rm.recordConfigChange(auditSourceConfigMap, caller, data.Config, oldConfig, nil, err)
+			return
+		}
+
+		if err := rm.UpdateConfig(newConfig); err != nil {
+			log.Errorf("Failed to apply configuration from ConfigMap %s/%s: %v", source.Namespace, source.ConfigMap, err)
+			rm.recordConfigChange(auditSourceConfigMap, caller, data.Config, oldConfig, newConfig, err)
+			return
+		}
+		log.Infof("Applied configuration from ConfigMap %s/%s", source.Namespace, source.ConfigMap)
+		rm.recordConfigChange(auditSourceConfigMap, caller, data.Config, oldConfig, newConfig, nil)
+	})
+}
+
+// buildConfigFromSource parses the routes YAML and overlays the JWT keys from the Secret
+func buildConfigFromSource(data k8sQuery.ConfigData) (*configServer.Config, error) {
+	newConfig, err := configServer.LoadConfig(data.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.Secret != nil {
+		secretKey, ok := data.Secret[secretKeyJWTSecret]
+		if !ok || len(secretKey) == 0 {
+			return nil, fmt.Errorf("secret has no %q key", secretKeyJWTSecret)
+		}
+		newConfig.JWTConfig.SecretKey = string(secretKey)
+	}
+
+	return newConfig, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"OpenAuth/pkg/configServer/filters"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleLockoutQuery lists the usernames and IPs with failed login attempts
+func (rm *RouterManager) handleLockoutQuery(c *gin.Context) {
+	lockouts := filters.Lockouts()
+	if kind := c.Query("kind"); kind != "" {
+		matching := lockouts[:0]
+		for _, lockout := range lockouts {
+			if lockout.Kind == kind {
+				matching = append(matching, lockout)
+			}
+		}
+		lockouts = matching
+	}
+	c.JSON(http.StatusOK, gin.H{"lockouts": lockouts})
+}
+
+// handleLockoutClear forgets the failed attempts of a username or IP,
+// DELETE /config/lockouts/username/<name> or /config/lockouts/ip/<address>
+func (rm *RouterManager) handleLockoutClear(c *gin.Context) {
+	kind := c.Param("kind")
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if kind != filters.LockoutUsername && kind != filters.LockoutIP {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("kind must be %s or %s", filters.LockoutUsername, filters.LockoutIP)})
+		return
+	}
+	if !filters.ClearLockout(kind, key) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no failed attempts for %s %q", kind, key)})
+		return
+	}
+	log.Infof("Lockout of %s %q cleared by %s", kind, key, c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"kind": kind, "key": key, "cleared": true})
+}
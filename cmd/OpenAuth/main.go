@@ -1,9 +1,12 @@
 package main
 
 import (
+	"OpenAuth/pkg/k8sQuery"
+	"flag"
+	"os"
+
 	"github.com/gin-gonic/gin"
 	"github.com/op/go-logging"
-	"os"
 )
 
 var (
@@ -27,6 +30,24 @@ func main() {
 	//var port int
 	//port = 80
 
+	opts := ServerOptions{Address: "0.0.0.0:80"}
+	opts.K8s.AddFlags(flag.CommandLine)
+	flag.StringVar(&opts.ConfigSource.ConfigMap, "config-configmap", "", "Watch this ConfigMap for routes instead of waiting for /config pushes")
+	flag.StringVar(&opts.ConfigSource.ConfigMapKey, "config-key", k8sQuery.DefaultConfigMapKey, "ConfigMap key holding the configuration YAML")
+	flag.StringVar(&opts.ConfigSource.Secret, "config-secret", "", "Secret holding the JWT secret_key, used with -config-configmap")
+	flag.StringVar(&opts.ConfigSource.Namespace, "config-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the config ConfigMap and Secret (defaults to $POD_NAMESPACE)")
+	flag.BoolVar(&opts.AuthRoutes, "watch-authroutes", false, "Merge AuthRoute/AuthPolicy resources into the configuration")
+	flag.StringVar(&opts.AuthRouteNamespace, "authroute-namespace", "", "Only watch AuthRoutes in this namespace (default: all namespaces)")
+	flag.StringVar(&opts.AuditLogFile, "audit-log", "", "Append configuration audit records to this file as JSON lines")
+	flag.IntVar(&opts.AuditLogSize, "audit-log-size", 1000, "Number of audit records kept for GET /config/audit")
+	flag.StringVar(&opts.DeploymentName, "deployment-name", "openauth", "OpenAuth Deployment receiving configuration Events")
+	flag.StringVar(&opts.DeploymentNamespace, "deployment-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the OpenAuth Deployment (defaults to $POD_NAMESPACE)")
+	flag.BoolVar(&opts.AllowInsecureTLS, "allow-insecure-tls", false, "Allow insecure_skip_verify in tls: blocks (disables certificate checks of remote servers)")
+	flag.StringVar(&opts.ProxyProtocolFrom, "proxy-protocol-from", "", "Comma separated CIDRs of load balancers sending PROXY protocol headers (disabled when empty)")
+	flag.StringVar(&opts.SchemaDir, "schema-dir", "api", "Directory the schema_file of schema filters is read from")
+	flag.StringVar(&opts.SecretNamespaces, "secret-namespaces", "", "Comma separated namespaces whose Secrets (upstream auth) and ConfigMaps (policy filters) every AuthRoute may use, besides its own namespace")
+	flag.Parse()
+
 	initLogger()
 	log.Info("OpenAuth logger started")
 
@@ -41,7 +62,7 @@ func main() {
 	}
 
 	gin.SetMode(gin.DebugMode)
-	if err := StartServer("0.0.0.0:80"); err != nil {
+	if err := StartServer(opts); err != nil {
 		panic(err)
 	}
 }
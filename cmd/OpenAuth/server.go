@@ -1,17 +1,26 @@
 package main
 
 import (
+	"OpenAuth/pkg/audit"
 	"OpenAuth/pkg/configServer"
+	"OpenAuth/pkg/configServer/filters"
 	"OpenAuth/pkg/configServer/middleware"
 	"OpenAuth/pkg/jwt"
 	"OpenAuth/pkg/k8sQuery"
+	"OpenAuth/pkg/routeController"
+	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"reflect"
+	"strings"
 	"sync"
 
 	"github.com/gin-gonic/gin"
-	"gopkg.in/yaml.v2"
+	"github.com/pires/go-proxyproto"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 type HandlerSwitcher struct {
@@ -38,13 +47,30 @@ type RouterManager struct {
 	handlerSwitcher *HandlerSwitcher
 	mu              sync.RWMutex
 	config          *configServer.Config
+	k8sClient       *k8sQuery.K8sClient
 	tokenValidator  *k8sQuery.TokenValidator
 	jwtManager      *jwt.JWTManager
+
+	// routes owned by AuthRoute resources, merged into every config, and
+	// the ones the current engine does not serve, keyed by "METHOD path"
+	resourceRoutes   []configServer.RouteConfig
+	resourceRejected map[string]routeController.Rejection
+	routeController  *routeController.Controller
+
+	// audit trail and Kubernetes Events for configuration changes
+	auditLog      *audit.Log
+	eventRecorder record.EventRecorder
+	deploymentRef *corev1.ObjectReference
 }
 
 // this creates bear gin engine and set /config endpoint
 // To utilize this, you must config the router by /config endpoint with configuration yaml.
-func NewRouterManager() (*RouterManager, error) {
+// k8sOpts selects the kubeconfig, context or in-cluster config used to reach the api-server.
+func NewRouterManager(k8sOpts k8sQuery.ClientOptions) (*RouterManager, error) {
+	k8sClient, err := k8sQuery.NewK8sClientWithOptions(k8sOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
 
 	// Create TokenValidator for Integrity
 	// TokenValidator checks the JWT token signed by k8s api server with the given ServiceAccount
@@ -55,24 +81,56 @@ func NewRouterManager() (*RouterManager, error) {
 		},
 	}
 
-	validator, err := k8sQuery.NewTokenValidator(saConfig)
+	// k8s://<service>.<namespace>:<port> remote servers of RequestFilters
+	filters.SetServiceResolver(k8sClient.NewEndpointResolver())
+	// ConfigMaps referenced by policy filters, Secrets holding upstream credentials
+	filters.SetConfigMapReader(k8sClient)
+	filters.SetSecretReader(k8sClient)
+
+	validator, err := k8sQuery.NewTokenValidator(k8sClient, saConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create token validator: %v", err)
 	}
 
+	// no proxy is trusted until a configuration sets client_ip
+	engine, err := buildEngine(nil)
+	if err != nil {
+		return nil, err
+	}
+
 	rm := &RouterManager{
-		engine:         gin.Default(),
+		engine:         engine,
+		k8sClient:      k8sClient,
 		tokenValidator: validator,
 	}
 
 	// /config endpoint
-	configGroup := rm.engine.Group("/config")
-	configGroup.Use(k8sQuery.AuthMiddleware(validator))
-	configGroup.POST("", rm.handleConfigUpdate)
+	rm.registerConfigEndpoints(rm.engine)
 
 	return rm, nil
 }
 
+// buildEngine creates a gin engine trusting only the proxies of clientIP
+func buildEngine(clientIP *configServer.ClientIPConfig) (*gin.Engine, error) {
+	engine := gin.Default()
+	if err := clientIP.Apply(engine); err != nil {
+		return nil, fmt.Errorf("client_ip: %v", err)
+	}
+	return engine, nil
+}
+
+// registerConfigEndpoints sets the authenticated /config endpoints on the engine
+func (rm *RouterManager) registerConfigEndpoints(engine *gin.Engine) {
+	configGroup := engine.Group("/config")
+	configGroup.Use(k8sQuery.AuthMiddleware(rm.tokenValidator))
+	configGroup.POST("", rm.handleConfigUpdate)
+	configGroup.GET("/audit", rm.handleAuditQuery)
+	configGroup.DELETE("/cache", rm.handleCacheFlushAll)
+	configGroup.DELETE("/cache/:filter", rm.handleCacheFlush)
+	configGroup.GET("/lockouts", rm.handleLockoutQuery)
+	configGroup.DELETE("/lockouts/:kind/*key", rm.handleLockoutClear)
+}
+
 // the function handle /config endpoint
 // this must embed on the gin engine in initiative time.
 func (rm *RouterManager) handleConfigUpdate(c *gin.Context) {
@@ -87,22 +145,28 @@ func (rm *RouterManager) handleConfigUpdate(c *gin.Context) {
 	}
 	log.Debugf("Request body successfully read. Length: %d bytes", len(yamlData))
 
+	caller := c.GetString("username")
+	oldConfig := rm.GetConfig()
+
 	// Parse YAML
-	var newConfig configServer.Config
-	if err := yaml.Unmarshal(yamlData, &newConfig); err != nil {
+	newConfig, err := configServer.LoadConfig(yamlData)
+	if err != nil {
 		log.Debugf("Failed to parse YAML: %v", err)
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Failed to parse YAML: %v", err)})
+		rm.recordConfigChange(auditSourcePush, caller, yamlData, oldConfig, nil, err)
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 	log.Debugf("YAML parsed successfully: %+v", newConfig)
 
 	// Update Router Configuration
 	log.Debugf("Attempting to update router configuration...")
-	if err := rm.UpdateConfig(&newConfig); err != nil {
+	if err := rm.UpdateConfig(newConfig); err != nil {
 		log.Debugf("Failed to update router: %v", err)
+		rm.recordConfigChange(auditSourcePush, caller, yamlData, oldConfig, newConfig, err)
 		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to update router: %v", err)})
 		return
 	}
+	rm.recordConfigChange(auditSourcePush, caller, yamlData, oldConfig, newConfig, nil)
 
 	log.Debugf("Router configuration updated successfully")
 	c.JSON(200, gin.H{"message": "Router configuration updated successfully"})
@@ -123,58 +187,115 @@ func (rm *RouterManager) UpdateConfig(newConfig *configServer.Config) error {
 	defer rm.mu.Unlock() // defines unlock after function returns
 	log.Debugf("Lock acquired")
 
-	rm.jwtManager = jwt.NewJWTManager(
+	rejected := rm.resourceRejected
+	if err := rm.applyConfig(newConfig, rm.resourceRoutes); err != nil {
+		return err
+	}
+	// the base configuration may take or free paths of AuthRoutes
+	if rm.routeController != nil && !reflect.DeepEqual(rejected, rm.resourceRejected) {
+		rm.routeController.Resync()
+	}
+	return nil
+
+	/* Critical Section ended by defer */
+}
+
+// applyConfig builds a new engine from the base configuration and the routes
+// owned by AuthRoute resources, then swaps it in. AuthRoutes that cannot be
+// served are skipped and kept in rm.resourceRejected. rm.mu must be held.
+func (rm *RouterManager) applyConfig(newConfig *configServer.Config, resourceRoutes []configServer.RouteConfig) error {
+	jwtManager := jwt.NewJWTManager(
 		newConfig.JWTConfig.SecretKey,
 		newConfig.JWTConfig.RequiredFields,
 	)
 
+	if newConfig.TLS != nil {
+		if err := newConfig.TLS.Validate(); err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+	}
+	if err := newConfig.ClientIP.Validate(); err != nil {
+		return fmt.Errorf("client_ip: %v", err)
+	}
+	// filters are built with the TLS settings of the new configuration,
+	// the previous ones are restored when it is rejected
+	generation := filters.StartConfigBuild()
+	previousTLS := filters.SetDefaultTLS(newConfig.TLS)
+	applied := false
+	defer func() {
+		if !applied {
+			filters.SetDefaultTLS(previousTLS)
+		}
+	}()
+
 	log.Debugf("Creating a new Gin engine")
-	newEngine := gin.Default()
+	newEngine, err := buildEngine(newConfig.ClientIP)
+	if err != nil {
+		return err
+	}
 	log.Debugf("New Gin engine created: %+v", newEngine)
 
+	// claim conditions validate bearer tokens with the keys of this configuration
+	newEngine.Use(filters.TokenValidatorMiddleware(jwtManager.ParseClaims))
+
 	// Set /config endpoint
 	log.Debugf("Setting up /config endpoint with authentication middleware")
-	configGroup := newEngine.Group("/config")
-	configGroup.Use(k8sQuery.AuthMiddleware(rm.tokenValidator))
-	configGroup.POST("", rm.handleConfigUpdate)
+	rm.registerConfigEndpoints(newEngine)
 	log.Debugf("/config endpoint configured successfully")
 
 	// set the router with the given configuration via /config endpoint
 	log.Debugf("Configuring additional routes")
+	registered := make(map[string]bool)
 	for _, route := range newConfig.Routes {
 		log.Debugf("Processing route: Method=%s, Path=%s", route.Method, route.Path)
 
-		handlers := make([]gin.HandlerFunc, 0)
-
-		// Add RequestFilters
-		for _, rf := range route.RequestFilters {
-			log.Debugf("Adding RequestFilter middleware: %+v", rf)
-			handlers = append(handlers, middleware.CreateRequestFilterMiddleware(&rf))
+		handlers, err := rm.buildRouteHandlers(route)
+		if err != nil {
+			return fmt.Errorf("route %s %s: %v", route.Method, route.Path, err)
 		}
+		if err := handleRoute(newEngine, route, handlers); err != nil {
+			return fmt.Errorf("route %s %s: %v", route.Method, route.Path, err)
+		}
+		registered[route.Method+" "+route.Path] = true
+	}
 
-		// Add ConditionFilter
-		if route.ConditionFilter != nil {
-			log.Debugf("Adding ConditionFilter middleware: %+v", route.ConditionFilter)
-			handlers = append(handlers, middleware.CreateConditionFilterMiddleware(route.ConditionFilter))
+	// routes from AuthRoute resources were validated by the controller,
+	// a route of the base configuration wins over a resource with the same path
+	rejected := make(map[string]routeController.Rejection)
+	for _, route := range resourceRoutes {
+		key := route.Method + " " + route.Path
+		if registered[key] {
+			log.Warningf("Skipping AuthRoute %s: path is owned by the base configuration", key)
+			rejected[key] = routeController.Rejection{
+				Reason:  routeController.ReasonConflict,
+				Message: fmt.Sprintf("%s is served by the base configuration", key),
+			}
+			continue
 		}
 
-		// set final handler
-		log.Debugf("Final Handler Type: %s", route.HandlerType)
-		finalHandler := rm.getHandlerByType(route.HandlerType)
-		if finalHandler == nil {
-			panic("wrong configuration")
+		handlers, err := rm.buildRouteHandlers(route)
+		if err == nil {
+			err = handleRoute(newEngine, route, handlers)
 		}
-		handlers = append(handlers, finalHandler)
-		log.Debugf("Final middleware added: %s", finalHandler)
-		newEngine.Handle(route.Method, route.Path, handlers...)
+		if err != nil {
+			log.Warningf("Skipping AuthRoute %s: %v", key, err)
+			rejected[key] = routeController.Rejection{Reason: routeController.ReasonInvalidSpec, Message: err.Error()}
+			continue
+		}
+		registered[key] = true
 	}
 
 	log.Debugf("All routes configured successfully")
 
 	// Set new engine and configuration
 	log.Debugf("Updating RouterManager with new configuration and engine")
+	rm.jwtManager = jwtManager
 	rm.engine = newEngine
 	rm.config = newConfig
+	rm.resourceRejected = rejected
+	applied = true
+	// caches and rate limiters of filters the new configuration no longer has
+	filters.PruneShared(generation)
 	log.Debugf("RouterManager updated successfully: Engine=%+v\n\n Config=%+v\n", rm.engine, rm.config)
 
 	if rm.handlerSwitcher != nil {
@@ -182,8 +303,57 @@ func (rm *RouterManager) UpdateConfig(newConfig *configServer.Config) error {
 	}
 
 	return nil
+}
 
-	/* Critical Section ended by defer */
+// buildRouteHandlers creates the filter middlewares and the final handler of a route
+func (rm *RouterManager) buildRouteHandlers(route configServer.RouteConfig) ([]gin.HandlerFunc, error) {
+	if err := route.Validate(); err != nil {
+		return nil, err
+	}
+
+	// build every filter now, so configuration errors reject the update
+	routeFilters, err := route.BuildFilters()
+	if err != nil {
+		return nil, err
+	}
+
+	handlers := make([]gin.HandlerFunc, 0, len(routeFilters)+1)
+	for _, filter := range routeFilters {
+		log.Debugf("Adding %T middleware: %+v", filter, filter)
+		handlers = append(handlers, middleware.CreateFilterMiddleware(filter))
+	}
+
+	// set final handler
+	log.Debugf("Final Handler Type: %s", route.HandlerType)
+	finalHandler := rm.getHandlerByType(route.HandlerType)
+	if finalHandler == nil {
+		return nil, fmt.Errorf("unknown handler_type %q", route.HandlerType)
+	}
+	handlers = append(handlers, finalHandler)
+	log.Debugf("Final middleware added: %s", route.HandlerType)
+
+	return handlers, nil
+}
+
+// ValidateRoute checks that a route can be served, used by the AuthRoute
+// controller. The lock keeps applyConfig from switching the default TLS
+// settings and the build generation meanwhile.
+func (rm *RouterManager) ValidateRoute(route configServer.RouteConfig) error {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	_, err := rm.buildRouteHandlers(route)
+	return err
+}
+
+// handleRoute registers the route, gin panics on conflicting paths
+func handleRoute(engine *gin.Engine, route configServer.RouteConfig, handlers []gin.HandlerFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	engine.Handle(route.Method, route.Path, handlers...)
+	return nil
 }
 
 func (rm *RouterManager) GetEngine() *gin.Engine {
@@ -192,20 +362,114 @@ func (rm *RouterManager) GetEngine() *gin.Engine {
 	return rm.engine
 }
 
+// GetConfig returns the current base configuration, nil before the first one
+func (rm *RouterManager) GetConfig() *configServer.Config {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.config
+}
+
+// ServerOptions configures StartServer
+type ServerOptions struct {
+	Address string
+	K8s     k8sQuery.ClientOptions
+
+	// ConfigSource, when set, loads routes from a ConfigMap and JWT keys
+	// from a Secret instead of waiting for a push to /config
+	ConfigSource k8sQuery.ConfigSource
+
+	// AuthRoutes enables the AuthRoute/AuthPolicy controller,
+	// AuthRouteNamespace limits it to one namespace
+	AuthRoutes         bool
+	AuthRouteNamespace string
+
+	// audit trail of configuration changes, Events are emitted on the
+	// OpenAuth Deployment when its name and namespace are known
+	AuditLogFile        string
+	AuditLogSize        int
+	DeploymentName      string
+	DeploymentNamespace string
+
+	// AllowInsecureTLS permits insecure_skip_verify in tls: blocks
+	AllowInsecureTLS bool
+	// SchemaDir holds the JSON Schemas referenced by schema filters
+	SchemaDir string
+	// SecretNamespaces lists the comma separated namespaces whose Secrets
+	// and ConfigMaps AuthRoutes of other namespaces may reference
+	SecretNamespaces string
+
+	// ProxyProtocolFrom lists the comma separated CIDRs of the load balancers
+	// sending PROXY protocol headers, empty disables PROXY protocol
+	ProxyProtocolFrom string
+}
+
 // StartServer create initiative server with engine and address
-func StartServer(address string) error {
-	routerManager, err := NewRouterManager()
+func StartServer(opts ServerOptions) error {
+	filters.SetAllowInsecureTLS(opts.AllowInsecureTLS)
+	if opts.SchemaDir != "" {
+		filters.SetSchemaDir(opts.SchemaDir)
+	}
+	if opts.SecretNamespaces != "" {
+		filters.SetSecretNamespaces(strings.Split(opts.SecretNamespaces, ","))
+	}
+
+	routerManager, err := NewRouterManager(opts.K8s)
 	if err != nil {
 		return fmt.Errorf("failed to create router manager: %v", err)
 	}
 
+	if err := routerManager.setupAudit(opts); err != nil {
+		return err
+	}
+
 	handlerSwitcher := &HandlerSwitcher{handler: routerManager.GetEngine()}
 	routerManager.handlerSwitcher = handlerSwitcher
 
+	if opts.ConfigSource.Enabled() {
+		if err := routerManager.WatchConfigSource(context.Background(), opts.ConfigSource); err != nil {
+			return fmt.Errorf("failed to watch config source: %v", err)
+		}
+	}
+
+	if opts.AuthRoutes {
+		if err := routerManager.StartRouteController(context.Background(), opts.AuthRouteNamespace); err != nil {
+			return fmt.Errorf("failed to start AuthRoute controller: %v", err)
+		}
+	}
+
 	routerManager.server = &http.Server{
-		Addr:    address,
+		Addr:    opts.Address,
 		Handler: handlerSwitcher,
 	}
 
-	return routerManager.server.ListenAndServe()
+	listener, err := listen(opts)
+	if err != nil {
+		return err
+	}
+	return routerManager.server.Serve(listener)
+}
+
+// listen opens the server address. With PROXY protocol the peer address of
+// connections from the load balancers is the client address of their header,
+// headers from other peers are ignored.
+func listen(opts ServerOptions) (net.Listener, error) {
+	listener, err := net.Listen("tcp", opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ProxyProtocolFrom == "" {
+		return listener, nil
+	}
+
+	var from []string
+	for _, cidr := range strings.Split(opts.ProxyProtocolFrom, ",") {
+		from = append(from, strings.TrimSpace(cidr))
+	}
+	policy, err := proxyproto.LaxWhiteListPolicy(from)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("proxy-protocol-from: %v", err)
+	}
+	log.Infof("Accepting PROXY protocol headers from %s", strings.Join(from, ", "))
+	return &proxyproto.Listener{Listener: listener, Policy: policy}, nil
 }
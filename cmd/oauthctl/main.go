@@ -8,13 +8,15 @@ import (
 	"io/ioutil"
 
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 )
 
-// SendConfigFile sends config file to the target IP
-func SendConfigFile(targetIP string, configFile string, token string) error {
-	log.Printf("Starting to send config file '%s' to IP: %s", configFile, targetIP)
+// SendConfigFile sends config file to the target address (host:port)
+func SendConfigFile(targetAddr string, configFile string, token string) error {
+	log.Printf("Starting to send config file '%s' to: %s", configFile, targetAddr)
 
 	content, err := ioutil.ReadFile(configFile)
 	if err != nil {
@@ -23,7 +25,7 @@ func SendConfigFile(targetIP string, configFile string, token string) error {
 	}
 	log.Printf("Successfully read config file, size: %d bytes", len(content))
 
-	url := fmt.Sprintf("http://%s:8080/config", targetIP)
+	url := fmt.Sprintf("http://%s/config", targetAddr)
 	log.Printf("Attempting POST request to URL: %s", url)
 
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(content))
@@ -74,7 +76,7 @@ func SendConfigFile(targetIP string, configFile string, token string) error {
 		log.Printf("Server response body: %s", string(body))
 	}
 
-	log.Printf("Successfully sent config to %s", targetIP)
+	log.Printf("Successfully sent config to %s", targetAddr)
 	return nil
 }
 
@@ -83,14 +85,19 @@ func main() {
 
 	namespace := flag.String("namespace", "default", "Kubernetes namespace")
 	configFile := flag.String("config", "", "Config file to send")
-	usePodIP := flag.Bool("use-pod-ip", false, "Use pod IPs instead of service IP")
+	usePodIP := flag.Bool("use-pod-ip", false, "Send to every ready endpoint instead of the service IP")
+	serviceName := flag.String("service", "openauth", "OpenAuth service name")
+	servicePort := flag.String("port", "8080", "OpenAuth service port, by number or name")
 	saName := flag.String("serviceaccount", "oauth-configurator", "ServiceAccount name")
 
+	var k8sOpts k8s.ClientOptions
+	k8sOpts.AddFlags(flag.CommandLine)
+
 	log.Printf("Starting config sender application")
 	flag.Parse()
 
-	log.Printf("Parsed flags: namespace=%s, configFile=%s, usePodIP=%v",
-		*namespace, *configFile, *usePodIP)
+	log.Printf("Parsed flags: namespace=%s, configFile=%s, usePodIP=%v, kubeconfig=%s, context=%s",
+		*namespace, *configFile, *usePodIP, k8sOpts.Kubeconfig, k8sOpts.Context)
 
 	if *configFile == "" {
 		log.Fatal("config file is required")
@@ -98,7 +105,7 @@ func main() {
 
 	// Initialize Kubernetes client
 	log.Printf("Initializing Kubernetes client")
-	client, err := k8s.NewK8sClient()
+	client, err := k8s.NewK8sClientWithOptions(k8sOpts)
 	if err != nil {
 		log.Fatalf("Failed to create Kubernetes client: %v", err)
 	}
@@ -112,40 +119,39 @@ func main() {
 	}
 	log.Printf("Successfully retrieved ServiceAccount token")
 
-	// Find deployment
-	log.Printf("Looking for deployment 'openauth' in namespace '%s'", *namespace)
-	deployment, err := client.FindDeployment(*namespace, "openauth")
-	if err != nil {
-		log.Fatalf("Failed to find deployment: %v", err)
-	}
-	log.Printf("Found deployment: %s", deployment.Name)
-
-	// Get target IPs
+	// Get target addresses
 	if *usePodIP {
-		log.Printf("Retrieving Pod IPs for deployment")
-		podIPs, err := client.GetPodIPs(deployment)
+		log.Printf("Retrieving ready endpoints of service '%s' port '%s'", *serviceName, *servicePort)
+		endpoints, err := client.GetReadyEndpoints(*namespace, *serviceName, *servicePort)
 		if err != nil {
-			log.Fatalf("Failed to get pod IPs: %v", err)
+			log.Fatalf("Failed to get endpoints: %v", err)
 		}
-		log.Printf("Found %d pod IPs: %v", len(podIPs), podIPs)
+		if len(endpoints) == 0 {
+			log.Fatalf("No ready endpoints for service %s/%s", *namespace, *serviceName)
+		}
+		log.Printf("Found %d ready endpoints: %v", len(endpoints), endpoints)
 
 		// Send config to each pod
-		for i, ip := range podIPs {
-			log.Printf("Processing pod %d/%d with IP: %s", i+1, len(podIPs), ip)
-			if err := SendConfigFile(ip, *configFile, token); err != nil {
-				log.Printf("Failed to send config to %s: %v", ip, err)
+		for i, endpoint := range endpoints {
+			log.Printf("Processing endpoint %d/%d: %s (pod %s)", i+1, len(endpoints), endpoint.Address(), endpoint.PodName)
+			if err := SendConfigFile(endpoint.Address(), *configFile, token); err != nil {
+				log.Printf("Failed to send config to %s: %v", endpoint.Address(), err)
 			}
 		}
 	} else {
-		log.Printf("Retrieving Service IP for deployment")
-
-		serviceIP, err := client.GetServiceIP(deployment)
+		log.Printf("Retrieving service '%s'", *serviceName)
+		service, err := client.GetService(*namespace, *serviceName)
+		if err != nil {
+			log.Fatalf("Failed to get service: %v", err)
+		}
+		port, err := k8s.FindServicePort(service, *servicePort)
 		if err != nil {
-			log.Fatalf("Failed to get service IP: %v", err)
+			log.Fatalf("Failed to find service port: %v", err)
 		}
-		log.Printf("Found service IP: %s", serviceIP)
+		log.Printf("Found service IP: %s, port: %d", service.Spec.ClusterIP, port.Port)
 
-		if err := SendConfigFile(serviceIP, *configFile, token); err != nil {
+		targetAddr := net.JoinHostPort(service.Spec.ClusterIP, strconv.Itoa(int(port.Port)))
+		if err := SendConfigFile(targetAddr, *configFile, token); err != nil {
 			log.Fatalf("Failed to send config: %v", err)
 		}
 	}
@@ -0,0 +1,147 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+/*
+Package audit keeps a trail of every configuration change applied to or
+rejected by OpenAuth.
+
+Records are kept in a bounded in-memory ring for the /config/audit API and,
+when a writer is attached, appended to it as JSON lines.
+*/
+
+// Results of a configuration change
+const (
+	ResultApplied  = "applied"
+	ResultRejected = "rejected"
+)
+
+// Record describes one configuration change
+type Record struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Source     string    `json:"source"` // "/config", "configmap", "authroute"
+	Caller     string    `json:"caller"`
+	ConfigHash string    `json:"config_hash"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+	Diff       Diff      `json:"diff"`
+}
+
+// Query filters records, zero values match everything
+type Query struct {
+	Caller string
+	Source string
+	Result string
+	Since  time.Time
+	Limit  int
+}
+
+// Log is a bounded, concurrency-safe audit trail
+type Log struct {
+	mu       sync.RWMutex
+	records  []Record
+	next     int
+	full     bool
+	writer   io.Writer
+	encodeMu sync.Mutex
+}
+
+// NewLog creates a log keeping the last size records in memory.
+// Every record is also written to w as a JSON line when w is not nil.
+func NewLog(size int, w io.Writer) *Log {
+	if size <= 0 {
+		size = 1000
+	}
+	return &Log{
+		records: make([]Record, size),
+		writer:  w,
+	}
+}
+
+// Hash returns the hash identifying a configuration document
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Append stores the record, setting its timestamp when empty
+func (l *Log) Append(record Record) Record {
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now().UTC()
+	}
+
+	l.mu.Lock()
+	l.records[l.next] = record
+	l.next = (l.next + 1) % len(l.records)
+	if l.next == 0 {
+		l.full = true
+	}
+	l.mu.Unlock()
+
+	if l.writer != nil {
+		l.encodeMu.Lock()
+		defer l.encodeMu.Unlock()
+		if err := json.NewEncoder(l.writer).Encode(record); err != nil {
+			log.Errorf("failed to write audit record: %v", err)
+		}
+	}
+	return record
+}
+
+// Find returns matching records, newest first
+func (l *Log) Find(q Query) []Record {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.records)
+	}
+
+	result := make([]Record, 0)
+	for i := 0; i < count; i++ {
+		// walk backwards from the newest record
+		idx := (l.next - 1 - i + len(l.records)) % len(l.records)
+		record := l.records[idx]
+
+		if q.Caller != "" && record.Caller != q.Caller {
+			continue
+		}
+		if q.Source != "" && record.Source != q.Source {
+			continue
+		}
+		if q.Result != "" && record.Result != q.Result {
+			continue
+		}
+		if !q.Since.IsZero() && record.Timestamp.Before(q.Since) {
+			continue
+		}
+
+		result = append(result, record)
+		if q.Limit > 0 && len(result) >= q.Limit {
+			break
+		}
+	}
+	return result
+}
+
+// String summarizes the record for logs and Kubernetes Events
+func (r Record) String() string {
+	hash := r.ConfigHash
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+	msg := fmt.Sprintf("config %s from %s %s by %s: %s", hash, r.Source, r.Result, r.Caller, r.Diff)
+	if r.Error != "" {
+		msg += ": " + r.Error
+	}
+	return msg
+}
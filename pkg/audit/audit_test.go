@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"OpenAuth/pkg/configServer"
+	"OpenAuth/pkg/configServer/filters"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestLogFind(t *testing.T) {
+	var out bytes.Buffer
+	auditLog := NewLog(3, &out)
+
+	base := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	for i, caller := range []string{"a", "b", "a", "b"} {
+		result := ResultApplied
+		if i == 2 {
+			result = ResultRejected
+		}
+		auditLog.Append(Record{
+			Timestamp: base.Add(time.Duration(i) * time.Minute),
+			Caller:    caller,
+			Result:    result,
+		})
+	}
+
+	all := auditLog.Find(Query{})
+	if len(all) != 3 {
+		t.Fatalf("Expected ring of 3 records, got %d", len(all))
+	}
+	if !all[0].Timestamp.Equal(base.Add(3 * time.Minute)) {
+		t.Errorf("Expected newest record first, got %v", all[0].Timestamp)
+	}
+
+	if got := auditLog.Find(Query{Caller: "a"}); len(got) != 1 || got[0].Result != ResultRejected {
+		t.Errorf("unexpected records for caller a: %+v", got)
+	}
+	if got := auditLog.Find(Query{Since: base.Add(2 * time.Minute)}); len(got) != 2 {
+		t.Errorf("Expected 2 records since +2m, got %d", len(got))
+	}
+	if got := auditLog.Find(Query{Limit: 1}); len(got) != 1 {
+		t.Errorf("Expected limit 1, got %d", len(got))
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 JSON lines, got %d", len(lines))
+	}
+	var record Record
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil || record.Caller != "a" {
+		t.Errorf("invalid JSON line %q: %v", lines[0], err)
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	old := &configServer.Config{
+		Routes: []configServer.RouteConfig{
+			{Path: "/signin", Method: "POST", HandlerType: "login"},
+			{Path: "/signup", Method: "POST", HandlerType: "signup"},
+		},
+		JWTConfig: configServer.JWTConfig{SecretKey: "a"},
+	}
+	new := &configServer.Config{
+		Routes: []configServer.RouteConfig{
+			{Path: "/signin", Method: "POST", HandlerType: "verify"},
+			{Path: "/verify", Method: "POST", HandlerType: "verify"},
+		},
+		JWTConfig: configServer.JWTConfig{SecretKey: "b"},
+	}
+
+	diff := DiffConfig(old, new)
+	if len(diff.Added) != 1 || diff.Added[0] != "POST /verify" {
+		t.Errorf("unexpected added routes: %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != "POST /signup" {
+		t.Errorf("unexpected removed routes: %v", diff.Removed)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0] != "POST /signin" {
+		t.Errorf("unexpected changed routes: %v", diff.Changed)
+	}
+	if !diff.JWTChanged {
+		t.Error("expected jwt change")
+	}
+	if diff.String() != "+1 ~1 -1 routes, jwt changed" {
+		t.Errorf("unexpected summary: %s", diff)
+	}
+
+	if first := DiffConfig(nil, old); len(first.Added) != 2 {
+		t.Errorf("Expected every route added for first config, got %v", first.Added)
+	}
+}
+
+func TestRoutesDocument(t *testing.T) {
+	var policy filters.Spec
+	if err := yaml.Unmarshal([]byte(`{type: cel, filter_name: tenant, expression: "true"}`), &policy); err != nil {
+		t.Fatalf("Failed to unmarshal spec: %v", err)
+	}
+	route := configServer.RouteConfig{Path: "/signin", Method: "POST", HandlerType: "login"}
+	withPolicy := route
+	withPolicy.PolicyFilters = []filters.Spec{policy}
+
+	before := RoutesDocument([]configServer.RouteConfig{route})
+	after := RoutesDocument([]configServer.RouteConfig{withPolicy})
+	if Hash(before) == Hash(after) {
+		t.Errorf("an AuthPolicy change recorded the same hash")
+	}
+	if !strings.Contains(string(after), "filter_name: tenant") {
+		t.Errorf("policy filter missing from the audited routes:\n%s", after)
+	}
+}
@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"OpenAuth/pkg/configServer"
+	"OpenAuth/pkg/configServer/filters"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/op/go-logging"
+	"gopkg.in/yaml.v2"
+)
+
+var log = logging.MustGetLogger("audit")
+
+// Diff summarizes what a configuration change does to the routes
+type Diff struct {
+	Added      []string `json:"added,omitempty"`
+	Removed    []string `json:"removed,omitempty"`
+	Changed    []string `json:"changed,omitempty"`
+	JWTChanged bool     `json:"jwt_changed,omitempty"`
+}
+
+// DiffConfig compares two configurations route by route, keyed by "METHOD path".
+// old may be nil for the first configuration.
+func DiffConfig(old, new *configServer.Config) Diff {
+	var diff Diff
+	if new == nil {
+		return diff
+	}
+	if old == nil {
+		old = &configServer.Config{}
+	}
+
+	oldRoutes := routesByKey(old.Routes)
+	newRoutes := routesByKey(new.Routes)
+
+	for key, route := range newRoutes {
+		oldRoute, exists := oldRoutes[key]
+		switch {
+		case !exists:
+			diff.Added = append(diff.Added, key)
+		case !reflect.DeepEqual(oldRoute, route):
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+	for key := range oldRoutes {
+		if _, exists := newRoutes[key]; !exists {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+
+	diff.JWTChanged = !reflect.DeepEqual(old.JWTConfig, new.JWTConfig)
+	return diff
+}
+
+func routesByKey(routes []configServer.RouteConfig) map[string]configServer.RouteConfig {
+	result := make(map[string]configServer.RouteConfig, len(routes))
+	for _, route := range routes {
+		result[route.Method+" "+route.Path] = route
+	}
+	return result
+}
+
+// String returns a short summary such as "+1 ~0 -2 routes, jwt changed"
+func (d Diff) String() string {
+	summary := fmt.Sprintf("+%d ~%d -%d routes", len(d.Added), len(d.Changed), len(d.Removed))
+	if d.JWTChanged {
+		summary += ", jwt changed"
+	}
+	return summary
+}
+
+// auditedRoute is a route as enforced, the filters merged from AuthPolicies
+// included
+type auditedRoute struct {
+	Namespace   string         `yaml:"namespace,omitempty"`
+	Method      string         `yaml:"method"`
+	Path        string         `yaml:"path"`
+	HandlerType string         `yaml:"handler_type"`
+	Filters     []filters.Spec `yaml:"filters,omitempty"`
+}
+
+// RoutesDocument returns the document of AuthRoutes hashed into the audit
+// trail. Unlike the YAML of the routes it covers their AuthPolicies, so a
+// policy change records a different hash.
+func RoutesDocument(routes []configServer.RouteConfig) []byte {
+	audited := make([]auditedRoute, 0, len(routes))
+	for _, route := range routes {
+		specs, err := route.FilterSpecs()
+		if err != nil {
+			log.Warningf("Auditing the filters list of %s %s only: %v", route.Method, route.Path, err)
+			specs = append(append([]filters.Spec{}, route.PolicyFilters...), route.Filters...)
+		}
+		audited = append(audited, auditedRoute{
+			Namespace:   route.Namespace,
+			Method:      route.Method,
+			Path:        route.Path,
+			HandlerType: route.HandlerType,
+			Filters:     specs,
+		})
+	}
+	data, err := yaml.Marshal(audited)
+	if err != nil {
+		log.Warningf("Failed to marshal routes for the audit trail: %v", err)
+	}
+	return data
+}
@@ -0,0 +1,48 @@
+package configServer
+
+import (
+	"OpenAuth/pkg/configServer/filters"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientIPConfig decides whom OpenAuth believes about the client address.
+// Without it the peer address of the connection is the client IP, which is
+// the address of the load balancer unless it speaks PROXY protocol
+// (-proxy-protocol-from).
+type ClientIPConfig struct {
+	// TrustedProxies lists the CIDRs or addresses of the proxies in front of
+	// OpenAuth, the forwarded headers are ignored on any other peer
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+	// Headers carrying the client address, X-Forwarded-For and X-Real-IP by default
+	Headers []string `yaml:"headers,omitempty"`
+}
+
+// Validate checks the proxy addresses
+func (c *ClientIPConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if _, err := filters.ParseCIDRs(c.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted_proxies: %v", err)
+	}
+	for _, header := range c.Headers {
+		if header == "" {
+			return fmt.Errorf("headers: empty header name")
+		}
+	}
+	return nil
+}
+
+// Apply sets the trusted proxies of the engine. A nil configuration trusts
+// no proxy, unlike gin.Default which trusts every peer.
+func (c *ClientIPConfig) Apply(engine *gin.Engine) error {
+	if c == nil {
+		return engine.SetTrustedProxies(nil)
+	}
+	if len(c.Headers) > 0 {
+		engine.RemoteIPHeaders = c.Headers
+	}
+	return engine.SetTrustedProxies(c.TrustedProxies)
+}
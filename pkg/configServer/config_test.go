@@ -1,9 +1,14 @@
 package configServer
 
 import (
+	"OpenAuth/pkg/configServer/filters"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/gin-gonic/gin"
 	"gopkg.in/yaml.v2"
 )
 
@@ -91,3 +96,105 @@ jwt_config:
 		t.Errorf("Expected 1 RequestFilter, got %d", len(firstRoute.RequestFilters))
 	}
 }
+
+func TestRouteBuildFilters(t *testing.T) {
+	yamlData := `
+routes:
+  - path: "/signin"
+    method: "POST"
+    request_filters:
+      - filter_name: "legacy"
+        remote_server: "http://10.106.248.129/auth/login"
+    filters:
+      - type: condition
+        filter_name: "tenant"
+        conditions:
+          - field: "header"
+            key: "X-Tenant"
+            operator: "equals"
+            value: "team-a"
+    handler_type: "login"
+`
+	config, err := LoadConfig([]byte(yamlData))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	specs, err := config.Routes[0].FilterSpecs()
+	if err != nil {
+		t.Fatalf("FilterSpecs() error = %v", err)
+	}
+	if len(specs) != 2 || specs[0].Name != "legacy" || specs[1].Name != "tenant" {
+		t.Fatalf("unexpected filter order: %+v", specs)
+	}
+
+	built, err := config.Routes[0].BuildFilters()
+	if err != nil || len(built) != 2 {
+		t.Fatalf("BuildFilters() = %v, %v", built, err)
+	}
+
+	// brute_force runs after the legacy filters and would miss their failures
+	route := config.Routes[0]
+	route.Filters = append(route.Filters, filters.Spec{Type: "brute_force"})
+	if err := route.Validate(); err == nil || !strings.Contains(err.Error(), "brute_force") {
+		t.Errorf("Validate() error = %v, want brute_force refused with request_filters", err)
+	}
+
+	// schema must validate the body before the remote call of request_filters
+	route = config.Routes[0]
+	route.Filters = append(route.Filters, filters.Spec{Type: "schema"})
+	if err := route.Validate(); err == nil || !strings.Contains(err.Error(), "schema") {
+		t.Errorf("Validate() error = %v, want schema refused with request_filters", err)
+	}
+	route.RequestFilters = nil
+	route.PolicyFilters = []filters.Spec{{Type: "request"}}
+	if err := route.Validate(); err == nil || !strings.Contains(err.Error(), "schema") {
+		t.Errorf("Validate() error = %v, want schema refused after AuthPolicy calls", err)
+	}
+}
+
+func TestClientIPConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config, err := LoadConfig([]byte(`
+client_ip:
+  trusted_proxies: ["10.0.0.0/8"]
+  headers: ["X-Real-IP"]
+`))
+	if err != nil || config.ClientIP.Validate() != nil {
+		t.Fatalf("LoadConfig() = %+v, %v", config, err)
+	}
+
+	tests := []struct {
+		name       string
+		clientIP   *ClientIPConfig
+		remoteAddr string
+		want       string
+	}{
+		{"trusted proxy", config.ClientIP, "10.1.1.1:4000", "203.0.113.5"},
+		{"untrusted peer", config.ClientIP, "198.51.100.1:4000", "198.51.100.1"},
+		{"no configuration", nil, "10.1.1.1:4000", "10.1.1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := gin.New()
+			if err := tt.clientIP.Apply(engine); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			var got string
+			engine.GET("/", func(c *gin.Context) { got = c.ClientIP() })
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Real-IP", "203.0.113.5")
+			req.Header.Set("X-Forwarded-For", "192.0.2.1")
+			engine.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("ClientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if err := (&ClientIPConfig{TrustedProxies: []string{"10.0.0.0/40"}}).Validate(); err == nil {
+		t.Errorf("Validate() accepted an invalid CIDR")
+	}
+}
@@ -0,0 +1,494 @@
+package filters
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	Register("brute_force", func(spec *Spec) (Filter, error) {
+		bf := &BruteForceFilter{}
+		if err := spec.Decode(bf); err != nil {
+			return nil, err
+		}
+		return bf, nil
+	})
+}
+
+// BruteForceFilter guards login routes. It goes first in the filter list and
+// counts the requests answered with a failure status by the later filters
+// or the handler, per username and per client IP. Past delay_after failures
+// requests are slowed down progressively, past lockout_after they are
+// rejected with 429 until the lockout ends. A successful login clears the
+// failures of its username. Routes with request_filters or condition_filter
+// are refused, those run before the filters list.
+type BruteForceFilter struct {
+	FilterName string `yaml:"filter_name"`
+	// Username selects the username, e.g. {field: body, key: username}
+	Username    *KeyField         `yaml:"username,omitempty"`
+	PerUsername *BruteForceLimits `yaml:"per_username,omitempty"`
+	PerIP       *BruteForceLimits `yaml:"per_ip,omitempty"`
+	// Delay of the first delayed attempt, doubled for each further failure
+	// up to MaxDelay. 1s and 30s by default.
+	Delay    time.Duration `yaml:"delay,omitempty"`
+	MaxDelay time.Duration `yaml:"max_delay,omitempty"`
+	// Window after the last failure before the failures are forgotten, 15m by default
+	Window time.Duration `yaml:"window,omitempty"`
+	// FailureStatus lists the statuses counted as failed attempts, 401 and 403 by default
+	FailureStatus []int  `yaml:"failure_status,omitempty"`
+	Message       string `yaml:"message,omitempty"`
+}
+
+// BruteForceLimits sets when failures are delayed and locked out, zero disables a step
+type BruteForceLimits struct {
+	DelayAfter   int           `yaml:"delay_after,omitempty"`
+	LockoutAfter int           `yaml:"lockout_after,omitempty"`
+	Lockout      time.Duration `yaml:"lockout,omitempty"`
+}
+
+const bruteForceKey = "openauth.bruteForce"
+
+// Validate checks the limits
+func (bf *BruteForceFilter) Validate() error {
+	if bf.PerUsername == nil && bf.PerIP == nil {
+		return fmt.Errorf("per_username or per_ip is required")
+	}
+	if bf.PerUsername != nil {
+		if bf.Username == nil {
+			return fmt.Errorf("per_username requires username")
+		}
+		if err := validateField(bf.Username.Field, bf.Username.Key); err != nil {
+			return fmt.Errorf("username: %v", err)
+		}
+	}
+	for name, limits := range map[string]*BruteForceLimits{"per_username": bf.PerUsername, "per_ip": bf.PerIP} {
+		if limits == nil {
+			continue
+		}
+		if limits.DelayAfter < 0 || limits.LockoutAfter < 0 || limits.Lockout < 0 {
+			return fmt.Errorf("%s: limits must not be negative", name)
+		}
+		if limits.LockoutAfter > 0 && limits.Lockout == 0 {
+			return fmt.Errorf("%s: lockout_after requires a lockout duration", name)
+		}
+	}
+	if bf.Delay < 0 || bf.MaxDelay < 0 || bf.Window < 0 {
+		return fmt.Errorf("delay, max_delay and window must not be negative")
+	}
+	return nil
+}
+
+// attempt is what Process found out about a request, After completes it
+type attempt struct {
+	username string
+	ip       string
+}
+
+// Process rejects locked out usernames and IPs and delays suspicious attempts.
+// The attempt is counted as in flight until After settles it, so concurrent
+// attempts cannot fail more often than lockout_after allows.
+func (bf *BruteForceFilter) Process(c *gin.Context) (bool, error) {
+	log.Infof("start brute force filter: %s", bf.FilterName)
+
+	a := &attempt{}
+	if bf.PerIP != nil {
+		a.ip = c.ClientIP()
+	}
+	if bf.PerUsername != nil {
+		value, found, err := lookupField(c, Condition{Field: bf.Username.Field, Key: bf.Username.Key})
+		if err == nil && found && value != nil {
+			a.username = fmt.Sprint(value)
+		}
+	}
+
+	checks := bf.checks(a)
+	failures, retryAfter, ok := loginAttempts.reserve(bf.FilterName, checks, bf.window())
+	if !ok {
+		message := bf.Message
+		if message == "" {
+			message = "too many failed attempts, try again later"
+		}
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		return false, &FilterError{
+			Status:  http.StatusTooManyRequests,
+			Message: message,
+			Headers: http.Header{"Retry-After": []string{strconv.Itoa(seconds)}},
+		}
+	}
+
+	var delay time.Duration
+	for i, check := range checks {
+		if d := bf.delay(check.limits, failures[i]); d > delay {
+			delay = d
+		}
+	}
+	if delay > 0 {
+		log.Warningf("Brute force %s: delaying attempt by %s", bf.FilterName, delay)
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-c.Request.Context().Done():
+			loginAttempts.settle(checks, bf.window(), attemptReleased)
+			return false, c.Request.Context().Err()
+		}
+	}
+
+	c.Set(bruteForceKey+"."+bf.FilterName, a)
+	return true, nil
+}
+
+// After settles the attempt with the answer of the rest of the chain
+func (bf *BruteForceFilter) After(c *gin.Context) {
+	value, ok := c.Get(bruteForceKey + "." + bf.FilterName)
+	if !ok {
+		return
+	}
+	a := value.(*attempt)
+	status := c.Writer.Status()
+
+	failureStatus := bf.FailureStatus
+	if len(failureStatus) == 0 {
+		failureStatus = []int{http.StatusUnauthorized, http.StatusForbidden}
+	}
+	outcome := attemptReleased
+	switch {
+	case containsStatus(failureStatus, status):
+		outcome = attemptFailed
+	case status >= 200 && status < 300:
+		outcome = attemptSucceeded
+	}
+	loginAttempts.settle(bf.checks(a), bf.window(), outcome)
+}
+
+// release settles the attempt without counting it, the request was rejected
+// by a filter running next to this one in a parallel group
+func (bf *BruteForceFilter) release(c *gin.Context) {
+	if value, ok := c.Get(bruteForceKey + "." + bf.FilterName); ok {
+		loginAttempts.settle(bf.checks(value.(*attempt)), bf.window(), attemptReleased)
+	}
+}
+
+type bruteForceCheck struct {
+	kind   string
+	key    string
+	limits *BruteForceLimits
+}
+
+func (bf *BruteForceFilter) checks(a *attempt) []bruteForceCheck {
+	var checks []bruteForceCheck
+	if bf.PerUsername != nil && a.username != "" {
+		checks = append(checks, bruteForceCheck{LockoutUsername, a.username, bf.PerUsername})
+	}
+	if bf.PerIP != nil && a.ip != "" {
+		checks = append(checks, bruteForceCheck{LockoutIP, a.ip, bf.PerIP})
+	}
+	return checks
+}
+
+// delay doubles for every failure past delay_after, up to max_delay
+func (bf *BruteForceFilter) delay(limits *BruteForceLimits, failures int) time.Duration {
+	if limits.DelayAfter == 0 || failures < limits.DelayAfter {
+		return 0
+	}
+	base, max := bf.Delay, bf.MaxDelay
+	if base == 0 {
+		base = time.Second
+	}
+	if max == 0 {
+		max = 30 * time.Second
+	}
+	exp := failures - limits.DelayAfter
+	if exp > 30 {
+		return max
+	}
+	if d := base << exp; d < max {
+		return d
+	}
+	return max
+}
+
+func (bf *BruteForceFilter) window() time.Duration {
+	if bf.Window == 0 {
+		return 15 * time.Minute
+	}
+	return bf.Window
+}
+
+// kinds of tracked login attempts
+const (
+	LockoutUsername = "username"
+	LockoutIP       = "ip"
+)
+
+// Lockout is the failed login state of a username or IP
+type Lockout struct {
+	Kind        string     `json:"kind"`
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures"`
+	LastFailure time.Time  `json:"last_failure"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// attemptStore keeps the failed logins of every brute_force filter in
+// memory. States without failures, lockout or attempts in flight are
+// dropped every attemptSweepInterval. When the store is full the least
+// recently used state without lockout or attempts in flight is evicted; if
+// there is none, new keys are refused rather than let through untracked.
+type attemptStore struct {
+	mu      sync.Mutex
+	states  map[string]*attemptState
+	lru     *list.List // front is the most recently used
+	maxKeys int
+	swept   time.Time
+	now     func() time.Time
+}
+
+type attemptState struct {
+	kind        string
+	key         string
+	failures    int
+	lastFailure time.Time
+	window      time.Duration
+	lockedUntil time.Time
+	// inFlight counts the attempts Process let pass that are not settled yet
+	inFlight int
+	reserved time.Time
+
+	elem *list.Element
+}
+
+const (
+	attemptSweepInterval = time.Minute
+	// attempts not settled within attemptTimeout, e.g. of a request that
+	// panicked, no longer count as in flight
+	attemptTimeout = 5 * time.Minute
+	// attemptEvictScan bounds the states checked for eviction on one insert
+	attemptEvictScan = 64
+)
+
+// outcomes of an attempt passed to settle
+const (
+	attemptFailed = iota
+	attemptSucceeded
+	attemptReleased
+)
+
+var loginAttempts = newAttemptStore(100000)
+
+func newAttemptStore(maxKeys int) *attemptStore {
+	return &attemptStore{states: make(map[string]*attemptState), lru: list.New(), maxKeys: maxKeys, now: time.Now}
+}
+
+// refresh forgets failures outside the window and attempts that were never settled
+func (st *attemptState) refresh(now time.Time) {
+	if st.inFlight > 0 && now.Sub(st.reserved) > attemptTimeout {
+		st.inFlight = 0
+	}
+	if st.failures > 0 && now.Sub(st.lastFailure) > st.window && !st.lockedUntil.After(now) {
+		st.failures = 0
+	}
+}
+
+func (st *attemptState) idle(now time.Time) bool {
+	return st.failures == 0 && st.inFlight == 0 && !st.lockedUntil.After(now)
+}
+
+// reserve counts the attempt as in flight for every check. It refuses when a
+// check is locked out, or when as many attempts are in flight as may still
+// fail before the lockout. It returns the failures of each check, or how
+// long to wait before retrying.
+func (s *attemptStore) reserve(filterName string, checks []bruteForceCheck, window time.Duration) ([]int, time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now, attemptSweepInterval)
+
+	states := make([]*attemptState, len(checks))
+	for i, check := range checks {
+		state, ok := s.states[check.kind+":"+check.key]
+		if !ok {
+			continue
+		}
+		state.refresh(now)
+		s.lru.MoveToFront(state.elem)
+		if state.lockedUntil.After(now) {
+			log.Warningf("Brute force %s: %s %q is locked out until %s", filterName, check.kind, check.key, state.lockedUntil)
+			return nil, state.lockedUntil.Sub(now), false
+		}
+		// after a lockout every failure locks again, one attempt at a time
+		if limit := check.limits.LockoutAfter; limit > 0 && state.inFlight >= max(limit-state.failures, 1) {
+			log.Warningf("Brute force %s: %s %q has %d attempts in flight", filterName, check.kind, check.key, state.inFlight)
+			return nil, time.Second, false
+		}
+		states[i] = state
+	}
+
+	failures := make([]int, len(checks))
+	for i, check := range checks {
+		state := states[i]
+		if state == nil {
+			if state = s.add(check.kind, check.key, now); state == nil {
+				log.Warningf("Brute force %s: store is full of active keys, refusing %s %q", filterName, check.kind, check.key)
+				for _, reserved := range states[:i] {
+					reserved.inFlight--
+				}
+				return nil, time.Second, false
+			}
+			states[i] = state
+		}
+		state.window = window
+		state.inFlight++
+		state.reserved = now
+		failures[i] = state.failures
+	}
+	return failures, 0, true
+}
+
+// settle ends an attempt reserved by reserve. A failure starts the lockout
+// at lockout_after failures, a success clears the failures of the username.
+func (s *attemptStore) settle(checks []bruteForceCheck, window time.Duration, outcome int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for _, check := range checks {
+		state, ok := s.states[check.kind+":"+check.key]
+		if !ok {
+			// cleared by an administrator or never tracked
+			if outcome != attemptFailed {
+				continue
+			}
+			if state = s.add(check.kind, check.key, now); state == nil {
+				continue
+			}
+		}
+		state.refresh(now)
+		s.lru.MoveToFront(state.elem)
+		if state.inFlight > 0 {
+			state.inFlight--
+		}
+
+		switch {
+		case outcome == attemptFailed:
+			state.failures++
+			state.lastFailure = now
+			state.window = window
+			log.Warningf("Brute force: failed attempt %d for %s %q", state.failures, check.kind, check.key)
+			if limits := check.limits; limits.LockoutAfter > 0 && state.failures >= limits.LockoutAfter {
+				state.lockedUntil = now.Add(limits.Lockout)
+				log.Warningf("Locked out %s %q until %s after %d failures", check.kind, check.key, state.lockedUntil, state.failures)
+			}
+		case outcome == attemptSucceeded && check.kind == LockoutUsername:
+			state.failures, state.lockedUntil = 0, time.Time{}
+		}
+
+		if state.idle(now) {
+			s.remove(state)
+		}
+	}
+}
+
+// add starts tracking a key, evicting a state when the store is full. It
+// returns nil when every state checked for eviction is active. s.mu must be held.
+func (s *attemptStore) add(kind, key string, now time.Time) *attemptState {
+	if len(s.states) >= s.maxKeys && !s.evict(now) {
+		return nil
+	}
+	state := &attemptState{kind: kind, key: key}
+	state.elem = s.lru.PushFront(state)
+	s.states[kind+":"+key] = state
+	return state
+}
+
+// evict drops the least recently used state without lockout or attempts in
+// flight. Active states it passes are moved to the front, so the next
+// eviction checks others. s.mu must be held.
+func (s *attemptStore) evict(now time.Time) bool {
+	for i := 0; i < attemptEvictScan && s.lru.Len() > 0; i++ {
+		state := s.lru.Back().Value.(*attemptState)
+		state.refresh(now)
+		if state.inFlight == 0 && !state.lockedUntil.After(now) {
+			if state.failures > 0 {
+				log.Warningf("Brute force store is full (%d keys), dropping %d failures of %s %q", s.maxKeys, state.failures, state.kind, state.key)
+			}
+			s.remove(state)
+			return true
+		}
+		s.lru.MoveToFront(state.elem)
+	}
+	return false
+}
+
+func (s *attemptStore) remove(state *attemptState) {
+	s.lru.Remove(state.elem)
+	delete(s.states, state.kind+":"+state.key)
+}
+
+// sweep drops idle states unless the last sweep is less than interval ago.
+// s.mu must be held.
+func (s *attemptStore) sweep(now time.Time, interval time.Duration) {
+	if now.Sub(s.swept) < interval {
+		return
+	}
+	s.swept = now
+	for _, state := range s.states {
+		state.refresh(now)
+		if state.idle(now) {
+			s.remove(state)
+		}
+	}
+}
+
+func (s *attemptStore) clear(kind, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[kind+":"+key]
+	if ok {
+		s.remove(state)
+	}
+	return ok
+}
+
+// Lockouts lists the usernames and IPs with failed attempts, locked out first
+func Lockouts() []Lockout {
+	loginAttempts.mu.Lock()
+	defer loginAttempts.mu.Unlock()
+
+	now := loginAttempts.now()
+	lockouts := make([]Lockout, 0, len(loginAttempts.states))
+	for _, state := range loginAttempts.states {
+		state.refresh(now)
+		if state.failures == 0 && !state.lockedUntil.After(now) {
+			continue
+		}
+		lockout := Lockout{Kind: state.kind, Key: state.key, Failures: state.failures, LastFailure: state.lastFailure}
+		if state.lockedUntil.After(now) {
+			lockedUntil := state.lockedUntil
+			lockout.LockedUntil = &lockedUntil
+		}
+		lockouts = append(lockouts, lockout)
+	}
+	sort.Slice(lockouts, func(i, j int) bool {
+		if (lockouts[i].LockedUntil == nil) != (lockouts[j].LockedUntil == nil) {
+			return lockouts[i].LockedUntil != nil
+		}
+		return lockouts[i].LastFailure.After(lockouts[j].LastFailure)
+	})
+	return lockouts
+}
+
+// ClearLockout forgets the failed attempts of a username or IP and reports
+// whether there were any
+func ClearLockout(kind, key string) bool {
+	return loginAttempts.clear(kind, key)
+}
@@ -0,0 +1,176 @@
+package filters
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBruteForceDelay(t *testing.T) {
+	bf := &BruteForceFilter{Delay: time.Second, MaxDelay: 5 * time.Second}
+	limits := &BruteForceLimits{DelayAfter: 3}
+
+	for failures, want := range map[int]time.Duration{
+		0: 0, 2: 0, 3: time.Second, 4: 2 * time.Second, 5: 4 * time.Second, 6: 5 * time.Second, 100: 5 * time.Second,
+	} {
+		if got := bf.delay(limits, failures); got != want {
+			t.Errorf("delay(%d) = %v, want %v", failures, got, want)
+		}
+	}
+}
+
+func TestBruteForceLockout(t *testing.T) {
+	now := time.Now()
+	loginAttempts.now = func() time.Time { return now }
+	defer func() { loginAttempts.now = time.Now }()
+
+	bf := &BruteForceFilter{
+		FilterName:  "signin-guard",
+		Username:    &KeyField{Field: "body", Key: "username"},
+		PerUsername: &BruteForceLimits{LockoutAfter: 2, Lockout: time.Minute},
+	}
+	if err := bf.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	attempt := func(status int) error {
+		c := newFilterContext(`{"username": "lockout-test"}`)
+		if ok, err := bf.Process(c); !ok {
+			return err
+		}
+		c.Status(status)
+		c.Writer.WriteHeaderNow()
+		bf.After(c)
+		return nil
+	}
+
+	attempt(http.StatusUnauthorized)
+	attempt(http.StatusForbidden)
+
+	var fe *FilterError
+	if err := attempt(http.StatusOK); !errors.As(err, &fe) || fe.Status != http.StatusTooManyRequests || fe.Headers.Get("Retry-After") != "60" {
+		t.Fatalf("attempt after lockout = %v, want 429 with Retry-After 60", err)
+	}
+
+	found := false
+	for _, lockout := range Lockouts() {
+		if lockout.Kind == LockoutUsername && lockout.Key == "lockout-test" {
+			found = lockout.Failures == 2 && lockout.LockedUntil != nil
+		}
+	}
+	if !found {
+		t.Errorf("Lockouts() = %+v, want lockout-test locked after 2 failures", Lockouts())
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := attempt(http.StatusOK); err != nil {
+		t.Errorf("attempt after the lockout ended = %v", err)
+	}
+	// the successful login cleared the failures
+	if ClearLockout(LockoutUsername, "lockout-test") {
+		t.Errorf("failures were kept after a successful login")
+	}
+
+	attempt(http.StatusUnauthorized)
+	if !ClearLockout(LockoutUsername, "lockout-test") {
+		t.Errorf("ClearLockout() found no failures")
+	}
+}
+
+func TestBruteForceInFlight(t *testing.T) {
+	bf := &BruteForceFilter{
+		FilterName:  "in-flight-guard",
+		Username:    &KeyField{Field: "body", Key: "username"},
+		PerUsername: &BruteForceLimits{LockoutAfter: 2, Lockout: time.Minute},
+	}
+	if err := bf.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	defer ClearLockout(LockoutUsername, "in-flight-test")
+
+	// two attempts may still fail before the lockout, a third waits for them
+	first, second, third := newFilterContext(`{"username": "in-flight-test"}`), newFilterContext(`{"username": "in-flight-test"}`), newFilterContext(`{"username": "in-flight-test"}`)
+	for _, c := range []*gin.Context{first, second} {
+		if ok, err := bf.Process(c); !ok {
+			t.Fatalf("Process() error = %v", err)
+		}
+	}
+	var fe *FilterError
+	if ok, err := bf.Process(third); ok || !errors.As(err, &fe) || fe.Status != http.StatusTooManyRequests {
+		t.Fatalf("Process() with 2 attempts in flight = %v, %v, want 429", ok, err)
+	}
+
+	// a released attempt is not counted
+	bf.release(first)
+	if ok, err := bf.Process(third); !ok {
+		t.Fatalf("Process() after release error = %v", err)
+	}
+	for _, c := range []*gin.Context{second, third} {
+		c.Status(http.StatusUnauthorized)
+		c.Writer.WriteHeaderNow()
+		bf.After(c)
+	}
+	if ok, err := bf.Process(newFilterContext(`{"username": "in-flight-test"}`)); ok || !errors.As(err, &fe) || fe.Headers.Get("Retry-After") != "60" {
+		t.Fatalf("Process() after 2 failures = %v, %v, want locked out", ok, err)
+	}
+}
+
+func TestAttemptStoreFull(t *testing.T) {
+	now := time.Now()
+	store := newAttemptStore(2)
+	store.now = func() time.Time { return now }
+	limits := &BruteForceLimits{LockoutAfter: 2, Lockout: time.Hour}
+	checks := func(key string) []bruteForceCheck {
+		return []bruteForceCheck{{kind: LockoutUsername, key: key, limits: limits}}
+	}
+	fail := func(key string) {
+		store.reserve("full", checks(key), time.Hour)
+		store.settle(checks(key), time.Hour, attemptFailed)
+	}
+
+	fail("a")
+	fail("a")
+	fail("b")
+
+	// b is the oldest state without lockout, it makes room for c
+	if _, _, ok := store.reserve("full", checks("c"), time.Hour); !ok {
+		t.Fatalf("reserve() of c was refused")
+	}
+	if _, ok := store.states[LockoutUsername+":b"]; ok {
+		t.Errorf("b was not evicted")
+	}
+
+	// a is locked out and c in flight: d is refused, not let through untracked
+	if _, _, ok := store.reserve("full", checks("d"), time.Hour); ok {
+		t.Errorf("reserve() of d succeeded in a store full of active keys")
+	}
+	if _, _, ok := store.reserve("full", checks("a"), time.Hour); ok {
+		t.Errorf("lockout of a was evicted")
+	}
+
+	// the settled attempt of c frees its state
+	store.settle(checks("c"), time.Hour, attemptReleased)
+	if _, _, ok := store.reserve("full", checks("d"), time.Hour); !ok {
+		t.Errorf("reserve() of d was refused after c was settled")
+	}
+	if store.lru.Len() != len(store.states) {
+		t.Errorf("lru has %d states, map %d", store.lru.Len(), len(store.states))
+	}
+}
+
+func TestBruteForceValidate(t *testing.T) {
+	tests := []BruteForceFilter{
+		{},
+		{PerUsername: &BruteForceLimits{DelayAfter: 1}},
+		{PerIP: &BruteForceLimits{LockoutAfter: 3}},
+		{Username: &KeyField{Field: "body"}, PerUsername: &BruteForceLimits{DelayAfter: 1}},
+	}
+	for _, bf := range tests {
+		if err := bf.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded, want error", bf)
+		}
+	}
+}
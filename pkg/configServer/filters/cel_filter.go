@@ -0,0 +1,133 @@
+package filters
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+)
+
+func init() {
+	Register("cel", func(spec *Spec) (Filter, error) {
+		f := &CELFilter{}
+		if err := spec.Decode(f); err != nil {
+			return nil, err
+		}
+		return f, nil
+	})
+}
+
+// CELFilter passes when a Common Expression Language program returns true.
+// The program sees these variables:
+//
+//	headers    map(string, string)  lower-case header names, values joined with ","
+//	query      map(string, string)  first value of each query parameter
+//	params     map(string, string)  path parameters of the route
+//	body       dyn                  JSON request body, null when empty
+//	client_ip, method, host, path  string
+//	filters    map(string, dyn)     data collected by earlier filters, by filter name
+//	claims     map(string, dyn)     claims of the validated bearer token, empty without one
+//
+// e.g. `claims.role == "admin" || headers["x-tenant"] in ["a", "b"]`
+type CELFilter struct {
+	FilterName string `yaml:"filter_name"`
+	Expression string `yaml:"expression"`
+	// Message is returned to the client when the expression is false
+	Message string `yaml:"message,omitempty"`
+
+	program cel.Program
+}
+
+var celEnv = func() *cel.Env {
+	env, err := cel.NewEnv(
+		cel.Variable("headers", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("query", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("params", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("body", cel.DynType),
+		cel.Variable("client_ip", cel.StringType),
+		cel.Variable("method", cel.StringType),
+		cel.Variable("host", cel.StringType),
+		cel.Variable("path", cel.StringType),
+		cel.Variable("filters", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("claims", cel.MapType(cel.StringType, cel.DynType)),
+	)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create CEL environment: %v", err))
+	}
+	return env
+}()
+
+// Validate parses and type-checks the expression, it must return a bool
+func (f *CELFilter) Validate() error {
+	if strings.TrimSpace(f.Expression) == "" {
+		return fmt.Errorf("expression is required")
+	}
+
+	ast, issues := celEnv.Compile(f.Expression)
+	if issues != nil && issues.Err() != nil {
+		return fmt.Errorf("invalid expression: %v", issues.Err())
+	}
+	if !ast.OutputType().IsExactType(types.BoolType) {
+		return fmt.Errorf("expression must return bool, got %s", ast.OutputType())
+	}
+
+	program, err := celEnv.Program(ast)
+	if err != nil {
+		return fmt.Errorf("invalid expression: %v", err)
+	}
+	f.program = program
+	return nil
+}
+
+func (f *CELFilter) Process(c *gin.Context) (bool, error) {
+	log.Infof("start cel filter: %s", f.FilterName)
+	if f.program == nil {
+		if err := f.Validate(); err != nil {
+			return false, err
+		}
+	}
+
+	out, _, err := f.program.Eval(celActivation(c))
+	if err != nil {
+		return false, fmt.Errorf("expression error: %v", err)
+	}
+	if out != types.True {
+		if f.Message != "" {
+			return false, fmt.Errorf("%s", f.Message)
+		}
+		return false, fmt.Errorf("expression not met: %s", f.Expression)
+	}
+	return true, nil
+}
+
+// celActivation binds the request to the program variables. The body and
+// the token are only read when the expression uses them.
+func celActivation(c *gin.Context) map[string]interface{} {
+	return map[string]interface{}{
+		"headers":   requestHeaders(c),
+		"query":     requestQuery(c),
+		"params":    requestParams(c),
+		"client_ip": c.ClientIP(),
+		"method":    c.Request.Method,
+		"host":      c.Request.Host,
+		"path":      c.Request.URL.Path,
+		"filters":   func() interface{} { return Collected(c) },
+		"body": func() interface{} {
+			body, err := JSONBody(c)
+			if err != nil {
+				return types.NewErr("%v", err)
+			}
+			return body
+		},
+		"claims": func() interface{} {
+			claims, err := Claims(c)
+			if err != nil {
+				log.Debugf("no claims for cel filter: %v", err)
+				return map[string]interface{}{}
+			}
+			return claims
+		},
+	}
+}
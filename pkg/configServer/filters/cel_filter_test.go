@@ -0,0 +1,70 @@
+package filters
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCELFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		pass       bool
+	}{
+		{"header and query", `headers["x-tenant"] == "team-a" && query.debug == "1"`, true},
+		{"body", `body.user.age >= 18 && body.user.name.startsWith("a")`, true},
+		{"params and connection", `params.id == "42" && client_ip == "192.0.2.10" && method == "POST" && path == "/users/42"`, true},
+		{"claims", `claims.role == "admin"`, true},
+		{"collected data", `filters["user-lookup"].mfa_enabled == true`, true},
+		{"false", `"ops" in claims.groups`, false},
+		{"missing key is an error", `headers["x-missing"] == "a"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &CELFilter{Expression: tt.expression}
+			if err := f.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/users/42?debug=1", strings.NewReader(`{"user": {"name": "alice", "age": 30}}`))
+			req.RemoteAddr = "192.0.2.10:5000"
+			req.Header.Set("X-Tenant", "team-a")
+			req.Header.Set("Authorization", "Bearer t")
+			c := newTestContext(req)
+			c.Params = gin.Params{{Key: "id", Value: "42"}}
+			c.Set(tokenValidatorKey, TokenValidator(func(string) (map[string]interface{}, error) {
+				return map[string]interface{}{"role": "admin", "groups": []interface{}{"dev"}}, nil
+			}))
+			SetCollected(c, "user-lookup", map[string]interface{}{"mfa_enabled": true})
+
+			if ok, err := f.Process(c); ok != tt.pass {
+				t.Errorf("Process() = %v, %v, want %v", ok, err, tt.pass)
+			}
+		})
+	}
+}
+
+func TestCELFilterValidate(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       string
+	}{
+		{``, "expression is required"},
+		{`heders["x"] == "a"`, "undeclared reference"},
+		{`method == 1`, "no matching overload"},
+		{`method + "x"`, "must return bool"},
+		{`method ==`, "invalid expression"},
+	}
+
+	for _, tt := range tests {
+		f := &CELFilter{Expression: tt.expression}
+		if err := f.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: Validate() error = %v, want %q", tt.expression, err, tt.want)
+		}
+	}
+}
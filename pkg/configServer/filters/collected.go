@@ -0,0 +1,45 @@
+package filters
+
+import (
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const collectedKey = "openauth.collected"
+
+// collectedData holds what the filters of a request reported, keyed by filter name
+type collectedData struct {
+	mu     sync.RWMutex
+	values map[string]interface{}
+}
+
+func getCollectedData(c *gin.Context) *collectedData {
+	if data, ok := c.Get(collectedKey); ok {
+		return data.(*collectedData)
+	}
+	data := &collectedData{values: make(map[string]interface{})}
+	c.Set(collectedKey, data)
+	return data
+}
+
+// SetCollected stores the output of a filter for the filters that run after it
+func SetCollected(c *gin.Context, filterName string, value interface{}) {
+	data := getCollectedData(c)
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	data.values[filterName] = value
+}
+
+// Collected returns a copy of the outputs stored by earlier filters of the request
+func Collected(c *gin.Context) map[string]interface{} {
+	data := getCollectedData(c)
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	values := make(map[string]interface{}, len(data.values))
+	for k, v := range data.values {
+		values[k] = v
+	}
+	return values
+}
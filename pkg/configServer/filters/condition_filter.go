@@ -2,56 +2,264 @@ package filters
 
 import (
 	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+func init() {
+	Register("condition", func(spec *Spec) (Filter, error) {
+		cf := &ConditionFilter{}
+		if err := spec.Decode(cf); err != nil {
+			return nil, err
+		}
+		return cf, nil
+	})
+}
+
+// ConditionFilter passes when every condition holds
 type ConditionFilter struct {
 	FilterName string      `yaml:"filter_name"`
 	Conditions []Condition `yaml:"conditions"`
 }
 
+// Condition is either a comparison (field, key, operator, value) or exactly
+// one of the all/any/not groups, which may be nested.
+//
+// field selects the source of the compared value:
+//
+//	header, query, param, cookie  key is the name
+//	body                          key is a dotted JSON path, e.g. "user.roles.0"
+//	claim                         key is a claim of the validated bearer token
+//	filter                        key is "<filter_name>.<path>" in data collected by earlier filters
+//	client_ip, method, host       no key
 type Condition struct {
-	Field    string      `yaml:"field"`
-	Operator string      `yaml:"operator"`
-	Value    interface{} `yaml:"value"`
+	Field    string      `yaml:"field,omitempty"`
+	Key      string      `yaml:"key,omitempty"`
+	Operator string      `yaml:"operator,omitempty"`
+	Value    interface{} `yaml:"value,omitempty"`
+
+	All []Condition `yaml:"all,omitempty"`
+	Any []Condition `yaml:"any,omitempty"`
+	Not *Condition  `yaml:"not,omitempty"`
+
+	match matcher
+}
+
+// Validate checks fields and operators when the configuration is loaded
+func (cf *ConditionFilter) Validate() error {
+	for i := range cf.Conditions {
+		if err := cf.Conditions[i].validate(fmt.Sprintf("conditions[%d]", i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cond *Condition) validate(path string) error {
+	groups := 0
+	if cond.All != nil {
+		groups++
+	}
+	if cond.Any != nil {
+		groups++
+	}
+	if cond.Not != nil {
+		groups++
+	}
+	isComparison := cond.Field != "" || cond.Key != "" || cond.Operator != "" || cond.Value != nil
+
+	switch {
+	case groups > 1 || (groups == 1 && isComparison):
+		return fmt.Errorf("%s: a condition must be one of a comparison, all, any or not", path)
+	case cond.All != nil:
+		return validateGroup(path+".all", cond.All)
+	case cond.Any != nil:
+		return validateGroup(path+".any", cond.Any)
+	case cond.Not != nil:
+		return cond.Not.validate(path + ".not")
+	}
+
+	if err := validateField(cond.Field, cond.Key); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	match, err := compileMatcher(cond.Operator, cond.Value)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	cond.match = match
+	return nil
+}
+
+// validateField checks a field and key as read by lookupField
+func validateField(field, key string) error {
+	switch field {
+	case "header", "query", "param", "cookie", "body", "claim", "filter":
+		if key == "" {
+			return fmt.Errorf("%s requires a key", field)
+		}
+	case "client_ip", "method", "host":
+		if key != "" {
+			return fmt.Errorf("%s does not take a key", field)
+		}
+	default:
+		return fmt.Errorf("unknown field %q", field)
+	}
+	return nil
+}
+
+func validateGroup(path string, conditions []Condition) error {
+	if len(conditions) == 0 {
+		return fmt.Errorf("%s: group is empty", path)
+	}
+	for i := range conditions {
+		if err := conditions[i].validate(fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (cf *ConditionFilter) Process(c *gin.Context) (bool, error) {
 	log.Infof("start condition filter: %s", cf.FilterName)
-	for _, condition := range cf.Conditions {
-		if !evaluateCondition(c, condition) {
-			return false, fmt.Errorf("condition not met: %v", condition)
+	for i := range cf.Conditions {
+		if err := cf.Conditions[i].evaluate(c, fmt.Sprintf("conditions[%d]", i)); err != nil {
+			return false, err
 		}
 	}
 	return true, nil
 }
 
-func evaluateCondition(c *gin.Context, condition Condition) bool {
-	var fieldValue string
+// evaluate returns nil when the condition holds, otherwise an error naming
+// the branch that failed. Groups stop at the first branch that decides them.
+func (cond *Condition) evaluate(c *gin.Context, path string) error {
+	switch {
+	case cond.All != nil:
+		for i := range cond.All {
+			if err := cond.All[i].evaluate(c, fmt.Sprintf("%s.all[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
 
-	switch condition.Field {
-	case "header":
-		fieldValue = c.GetHeader(condition.Value.(string))
-	case "param":
-		fieldValue = c.Param(condition.Value.(string))
-	case "query":
-		fieldValue = c.Query(condition.Value.(string))
-	default:
-		return false
+	case cond.Any != nil:
+		failed := make([]string, 0, len(cond.Any))
+		for i := range cond.Any {
+			err := cond.Any[i].evaluate(c, fmt.Sprintf("%s.any[%d]", path, i))
+			if err == nil {
+				return nil
+			}
+			failed = append(failed, err.Error())
+		}
+		return fmt.Errorf("%s.any: no branch matched (%s)", path, strings.Join(failed, "; "))
+
+	case cond.Not != nil:
+		if err := cond.Not.evaluate(c, path+".not"); err != nil {
+			return nil
+		}
+		return fmt.Errorf("%s.not: condition matched: %s", path, cond.Not)
+	}
+
+	ok, err := evaluateCondition(c, *cond)
+	if err != nil {
+		return fmt.Errorf("%s: %s: %v", path, cond, err)
+	}
+	if !ok {
+		return fmt.Errorf("%s: condition not met: %s", path, cond)
+	}
+	return nil
+}
+
+func (cond *Condition) String() string {
+	switch {
+	case cond.All != nil:
+		return fmt.Sprintf("all(%d)", len(cond.All))
+	case cond.Any != nil:
+		return fmt.Sprintf("any(%d)", len(cond.Any))
+	case cond.Not != nil:
+		return "not(" + cond.Not.String() + ")"
+	}
+	if cond.Key != "" {
+		return fmt.Sprintf("%s %s %s %v", cond.Field, cond.Key, cond.Operator, cond.Value)
+	}
+	return fmt.Sprintf("%s %s %v", cond.Field, cond.Operator, cond.Value)
+}
+
+func evaluateCondition(c *gin.Context, condition Condition) (bool, error) {
+	// conditions that were not validated are compiled on use
+	if condition.match == nil && condition.Operator != "exists" && condition.Operator != "absent" {
+		match, err := compileMatcher(condition.Operator, condition.Value)
+		if err != nil {
+			return false, err
+		}
+		condition.match = match
+	}
+
+	fieldValue, found, err := lookupField(c, condition)
+	if err != nil {
+		return false, err
 	}
 
 	switch condition.Operator {
-	case "equals":
-		return fieldValue == condition.Value.(string)
-	case "contains":
-		return strings.Contains(fieldValue, condition.Value.(string))
-	case "prefix":
-		return strings.HasPrefix(fieldValue, condition.Value.(string))
-	case "suffix":
-		return strings.HasSuffix(fieldValue, condition.Value.(string))
+	case "exists":
+		return found, nil
+	case "absent":
+		return !found, nil
+	}
+	if !found {
+		return false, nil
+	}
+	return condition.match(fieldValue), nil
+}
+
+// lookupField reads the value selected by field and key from the request
+func lookupField(c *gin.Context, condition Condition) (interface{}, bool, error) {
+	switch condition.Field {
+	case "header":
+		values, ok := c.Request.Header[http.CanonicalHeaderKey(condition.Key)]
+		if !ok {
+			return nil, false, nil
+		}
+		return strings.Join(values, ","), true, nil
+	case "query":
+		value, ok := c.GetQuery(condition.Key)
+		return value, ok, nil
+	case "param":
+		for _, p := range c.Params {
+			if p.Key == condition.Key {
+				return p.Value, true, nil
+			}
+		}
+		return nil, false, nil
+	case "cookie":
+		value, err := c.Cookie(condition.Key)
+		return value, err == nil, nil
+	case "body":
+		body, err := JSONBody(c)
+		if err != nil {
+			return nil, false, err
+		}
+		value, ok := LookupPath(body, condition.Key)
+		return value, ok, nil
+	case "claim":
+		claims, err := Claims(c)
+		if err != nil {
+			return nil, false, err
+		}
+		value, ok := LookupPath(claims, condition.Key)
+		return value, ok, nil
+	case "filter":
+		value, ok := LookupPath(Collected(c), condition.Key)
+		return value, ok, nil
+	case "client_ip":
+		return c.ClientIP(), true, nil
+	case "method":
+		return c.Request.Method, true, nil
+	case "host":
+		return c.Request.Host, true, nil
 	default:
-		return false
+		return nil, false, fmt.Errorf("unknown field %q", condition.Field)
 	}
 }
@@ -0,0 +1,173 @@
+package filters
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+)
+
+func newTestContext(req *http.Request) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+	return c
+}
+
+func TestConditionGroups(t *testing.T) {
+	// X-Tenant is team-a AND (debug is not true OR X-Role is admin)
+	data := `
+filter_name: nested
+conditions:
+  - field: header
+    key: X-Tenant
+    operator: equals
+    value: team-a
+  - any:
+      - not:
+          field: query
+          key: debug
+          operator: equals
+          value: "true"
+      - field: header
+        key: X-Role
+        operator: equals
+        value: admin
+`
+	var cf ConditionFilter
+	if err := yaml.UnmarshalStrict([]byte(data), &cf); err != nil {
+		t.Fatalf("Failed to unmarshal filter: %v", err)
+	}
+	if err := cf.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		wantErr string
+	}{
+		{"no debug", "/", map[string]string{"X-Tenant": "team-a"}, ""},
+		{"debug as admin", "/?debug=true", map[string]string{"X-Tenant": "team-a", "X-Role": "admin"}, ""},
+		{"wrong tenant", "/", map[string]string{"X-Tenant": "X-Tenant"}, "conditions[0]: condition not met"},
+		{"debug without admin", "/?debug=true", map[string]string{"X-Tenant": "team-a"}, "conditions[1].any: no branch matched (conditions[1].any[0].not: condition matched"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			ok, err := cf.Process(newTestContext(req))
+			if tt.wantErr == "" {
+				if !ok || err != nil {
+					t.Errorf("Process() = %v, %v, want pass", ok, err)
+				}
+				return
+			}
+			if ok || err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Process() = %v, %v, want error containing %q", ok, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConditionGroupValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cond Condition
+		want string
+	}{
+		{"empty group", Condition{Any: []Condition{}}, "conditions[0].any: group is empty"},
+		{"mixed", Condition{Field: "header", Not: &Condition{}}, "must be one of"},
+		{"nested error", Condition{All: []Condition{{Field: "form", Key: "a", Operator: "equals", Value: "a"}}}, "conditions[0].all[0]: unknown field"},
+		{"missing key", Condition{Field: "header", Operator: "equals", Value: "a"}, "header requires a key"},
+		{"unexpected key", Condition{Field: "method", Key: "a", Operator: "equals", Value: "POST"}, "method does not take a key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf := ConditionFilter{Conditions: []Condition{tt.cond}}
+			if err := cf.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionSources(t *testing.T) {
+	claims := map[string]interface{}{"sub": "alice", "roles": []interface{}{"user", "admin"}}
+	validator := TokenValidator(func(token string) (map[string]interface{}, error) {
+		if token != "good" {
+			return nil, fmt.Errorf("invalid token")
+		}
+		return claims, nil
+	})
+
+	tests := []struct {
+		name    string
+		cond    Condition
+		pass    bool
+		wantErr string
+	}{
+		{"header", Condition{Field: "header", Key: "X-Tenant", Operator: "equals", Value: "team-a"}, true, ""},
+		{"header value is not the key", Condition{Field: "header", Key: "X-Tenant", Operator: "equals", Value: "X-Tenant"}, false, ""},
+		{"cookie", Condition{Field: "cookie", Key: "session", Operator: "prefix", Value: "abc"}, true, ""},
+		{"body path", Condition{Field: "body", Key: "user.emails.1", Operator: "suffix", Value: "@example.com"}, true, ""},
+		{"body number", Condition{Field: "body", Key: "user.age", Operator: "equals", Value: "42"}, true, ""},
+		{"missing body path", Condition{Field: "body", Key: "user.phone", Operator: "equals", Value: ""}, false, ""},
+		{"claim", Condition{Field: "claim", Key: "sub", Operator: "equals", Value: "alice"}, true, ""},
+		{"claim list", Condition{Field: "claim", Key: "roles", Operator: "equals", Value: "admin"}, true, ""},
+		{"client ip", Condition{Field: "client_ip", Operator: "prefix", Value: "192.0.2."}, true, ""},
+		{"method", Condition{Field: "method", Operator: "equals", Value: "POST"}, true, ""},
+		{"host", Condition{Field: "host", Operator: "equals", Value: "auth.example.com"}, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"user": {"age": 42, "emails": ["a@example.org", "a@example.com"]}}`
+			req := httptest.NewRequest(http.MethodPost, "http://auth.example.com/signin", strings.NewReader(body))
+			req.RemoteAddr = "192.0.2.10:5000"
+			req.Header.Set("X-Tenant", "team-a")
+			req.Header.Set("Authorization", "Bearer good")
+			req.AddCookie(&http.Cookie{Name: "session", Value: "abc123"})
+
+			c := newTestContext(req)
+			c.Set(tokenValidatorKey, validator)
+
+			cf := ConditionFilter{Conditions: []Condition{tt.cond}}
+			if err := cf.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			ok, err := cf.Process(c)
+			if ok != tt.pass {
+				t.Errorf("Process() = %v, %v, want %v", ok, err, tt.pass)
+			}
+
+			// the body stays readable for the next filters
+			if rest, _ := io.ReadAll(c.Request.Body); tt.cond.Field == "body" && string(rest) != body {
+				t.Errorf("body was consumed: %q", rest)
+			}
+		})
+	}
+}
+
+func TestConditionClaimWithoutToken(t *testing.T) {
+	c := newTestContext(httptest.NewRequest(http.MethodGet, "/", nil))
+	c.Set(tokenValidatorKey, TokenValidator(func(string) (map[string]interface{}, error) {
+		return map[string]interface{}{}, nil
+	}))
+
+	cf := ConditionFilter{Conditions: []Condition{{Field: "claim", Key: "sub", Operator: "equals", Value: "alice"}}}
+	ok, err := cf.Process(c)
+	if ok || err == nil || !strings.Contains(err.Error(), "no bearer token") {
+		t.Errorf("Process() = %v, %v, want missing token error", ok, err)
+	}
+}
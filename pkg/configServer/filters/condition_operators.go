@@ -0,0 +1,224 @@
+package filters
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// matcher tests a value read from the request, the value is a string for
+// request fields and any decoded JSON value for body paths and claims
+type matcher func(value interface{}) bool
+
+// compileMatcher checks the value of an operator and prepares its test, so
+// regular expressions, lists and CIDRs are parsed once when the configuration
+// is applied instead of on every request. exists and absent are decided by
+// the lookup and have no matcher.
+func compileMatcher(operator string, expected interface{}) (matcher, error) {
+	switch operator {
+	case "exists", "absent":
+		if expected != nil {
+			return nil, fmt.Errorf("%s does not take a value", operator)
+		}
+		return nil, nil
+
+	case "equals", "contains", "prefix", "suffix":
+		s, ok := expected.(string)
+		if !ok {
+			return nil, fmt.Errorf("value must be a string")
+		}
+		compare := map[string]func(string, string) bool{
+			"equals":   func(v, e string) bool { return v == e },
+			"contains": strings.Contains,
+			"prefix":   strings.HasPrefix,
+			"suffix":   strings.HasSuffix,
+		}[operator]
+		return anyElement(func(v string) bool { return compare(v, s) }), nil
+
+	case "matches":
+		s, ok := expected.(string)
+		if !ok {
+			return nil, fmt.Errorf("value must be a regular expression string")
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %v", err)
+		}
+		return anyElement(re.MatchString), nil
+
+	case "in", "not_in":
+		list, ok := expected.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("value must be a non-empty list")
+		}
+		set := make(map[string]bool, len(list))
+		for _, item := range list {
+			switch item.(type) {
+			case map[interface{}]interface{}, []interface{}, nil:
+				return nil, fmt.Errorf("list items must be scalars")
+			}
+			set[fmt.Sprint(item)] = true
+		}
+		in := anyElement(func(v string) bool { return set[v] })
+		if operator == "in" {
+			return in, nil
+		}
+		return func(value interface{}) bool { return !in(value) }, nil
+
+	case "gt", "lt":
+		limit, ok := toNumber(expected)
+		if !ok {
+			return nil, fmt.Errorf("value must be a number")
+		}
+		if operator == "gt" {
+			return anyNumber(func(n float64) bool { return n > limit }), nil
+		}
+		return anyNumber(func(n float64) bool { return n < limit }), nil
+
+	case "between":
+		bounds, ok := expected.([]interface{})
+		if !ok || len(bounds) != 2 {
+			return nil, fmt.Errorf("value must be a list of two numbers [min, max]")
+		}
+		min, minOK := toNumber(bounds[0])
+		max, maxOK := toNumber(bounds[1])
+		if !minOK || !maxOK || min > max {
+			return nil, fmt.Errorf("value must be a list of two numbers [min, max]")
+		}
+		return anyNumber(func(n float64) bool { return n >= min && n <= max }), nil
+
+	case "cidr":
+		var ranges []string
+		switch v := expected.(type) {
+		case string:
+			ranges = []string{v}
+		case []interface{}:
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("value must be a CIDR or a list of CIDRs")
+				}
+				ranges = append(ranges, s)
+			}
+		}
+		if len(ranges) == 0 {
+			return nil, fmt.Errorf("value must be a CIDR or a list of CIDRs")
+		}
+		prefixes := make([]netip.Prefix, 0, len(ranges))
+		for _, r := range ranges {
+			prefix, err := netip.ParsePrefix(r)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %v", r, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+		}
+		return anyElement(func(v string) bool {
+			addr, err := netip.ParseAddr(strings.TrimSpace(v))
+			if err != nil {
+				return false
+			}
+			addr = addr.Unmap()
+			for _, prefix := range prefixes {
+				if prefix.Contains(addr) {
+					return true
+				}
+			}
+			return false
+		}), nil
+
+	case "before", "after":
+		s, ok := expected.(string)
+		if !ok {
+			return nil, fmt.Errorf("value must be an RFC 3339 timestamp or \"now\"")
+		}
+		var fixed time.Time
+		if s != "now" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, fmt.Errorf("value must be an RFC 3339 timestamp or \"now\": %v", err)
+			}
+			fixed = t
+		}
+		reference := func() time.Time {
+			if s == "now" {
+				return time.Now()
+			}
+			return fixed
+		}
+		if operator == "before" {
+			return anyTime(func(t time.Time) bool { return t.Before(reference()) }), nil
+		}
+		return anyTime(func(t time.Time) bool { return t.After(reference()) }), nil
+
+	default:
+		return nil, fmt.Errorf("unknown operator %q", operator)
+	}
+}
+
+// anyElement applies a string test to a value, list values such as a roles
+// claim match when any element matches
+func anyElement(test func(string) bool) matcher {
+	return func(value interface{}) bool {
+		if list, ok := value.([]interface{}); ok {
+			for _, item := range list {
+				if test(fmt.Sprint(item)) {
+					return true
+				}
+			}
+			return false
+		}
+		return test(fmt.Sprint(value))
+	}
+}
+
+func anyNumber(test func(float64) bool) matcher {
+	return func(value interface{}) bool {
+		if list, ok := value.([]interface{}); ok {
+			for _, item := range list {
+				if n, ok := toNumber(item); ok && test(n) {
+					return true
+				}
+			}
+			return false
+		}
+		n, ok := toNumber(value)
+		return ok && test(n)
+	}
+}
+
+func anyTime(test func(time.Time) bool) matcher {
+	return anyElement(func(v string) bool {
+		t, ok := toTime(v)
+		return ok && test(t)
+	})
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// toTime reads RFC 3339 timestamps and unix seconds, as used by exp/iat claims
+func toTime(value string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(int64(n), 0), true
+	}
+	return time.Time{}, false
+}
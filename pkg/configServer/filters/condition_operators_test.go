@@ -0,0 +1,94 @@
+package filters
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestConditionOperators(t *testing.T) {
+	claims := map[string]interface{}{
+		"exp":    float64(time.Now().Add(time.Hour).Unix()),
+		"groups": []interface{}{"dev", "ops"},
+	}
+
+	tests := []struct {
+		name string
+		cond string
+		pass bool
+	}{
+		{"matches", `{field: header, key: X-Request-Id, operator: matches, value: "^[a-f0-9]{8}$"}`, true},
+		{"matches fails", `{field: header, key: X-Request-Id, operator: matches, value: "^[0-9]+$"}`, false},
+		{"in", `{field: method, operator: in, value: [GET, POST]}`, true},
+		{"in list claim", `{field: claim, key: groups, operator: in, value: [ops, admin]}`, true},
+		{"not_in", `{field: claim, key: groups, operator: not_in, value: [admin]}`, true},
+		{"not_in fails", `{field: claim, key: groups, operator: not_in, value: [dev]}`, false},
+		{"exists", `{field: query, key: debug, operator: exists}`, true},
+		{"absent", `{field: cookie, key: session, operator: absent}`, true},
+		{"absent fails", `{field: query, key: debug, operator: absent}`, false},
+		{"gt", `{field: body, key: amount, operator: gt, value: 100}`, true},
+		{"lt", `{field: body, key: amount, operator: lt, value: 100.5}`, false},
+		{"between", `{field: query, key: page, operator: between, value: [1, 10]}`, true},
+		{"cidr", `{field: client_ip, operator: cidr, value: [10.0.0.0/8, 192.0.2.0/24]}`, true},
+		{"cidr fails", `{field: client_ip, operator: cidr, value: 10.0.0.0/8}`, false},
+		{"after", `{field: claim, key: exp, operator: after, value: now}`, true},
+		{"before", `{field: header, key: X-Issued, operator: before, value: "2025-01-01T00:00:00Z"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cond Condition
+			if err := yaml.UnmarshalStrict([]byte(tt.cond), &cond); err != nil {
+				t.Fatalf("Failed to unmarshal condition: %v", err)
+			}
+			cf := ConditionFilter{Conditions: []Condition{cond}}
+			if err := cf.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/?debug=1&page=3", strings.NewReader(`{"amount": 250}`))
+			req.RemoteAddr = "192.0.2.10:5000"
+			req.Header.Set("X-Request-Id", "deadbeef")
+			req.Header.Set("X-Issued", "2024-06-01T12:00:00Z")
+			req.Header.Set("Authorization", "Bearer t")
+			c := newTestContext(req)
+			c.Set(tokenValidatorKey, TokenValidator(func(string) (map[string]interface{}, error) { return claims, nil }))
+
+			if ok, err := cf.Process(c); ok != tt.pass {
+				t.Errorf("Process() = %v, %v, want %v", ok, err, tt.pass)
+			}
+		})
+	}
+}
+
+func TestConditionOperatorValidation(t *testing.T) {
+	tests := []struct {
+		cond string
+		want string
+	}{
+		{`{field: header, key: a, operator: matches, value: "(unclosed"}`, "invalid regular expression"},
+		{`{field: header, key: a, operator: matches, value: "(?=lookahead)"}`, "invalid regular expression"},
+		{`{field: method, operator: in, value: GET}`, "non-empty list"},
+		{`{field: query, key: a, operator: exists, value: x}`, "does not take a value"},
+		{`{field: body, key: a, operator: gt, value: many}`, "must be a number"},
+		{`{field: body, key: a, operator: between, value: [10, 1]}`, "[min, max]"},
+		{`{field: client_ip, operator: cidr, value: 10.0.0.0/33}`, "invalid CIDR"},
+		{`{field: claim, key: exp, operator: after, value: tomorrow}`, "RFC 3339"},
+		{`{field: method, operator: like, value: GET}`, "unknown operator"},
+	}
+
+	for _, tt := range tests {
+		var cond Condition
+		if err := yaml.UnmarshalStrict([]byte(tt.cond), &cond); err != nil {
+			t.Fatalf("Failed to unmarshal condition %s: %v", tt.cond, err)
+		}
+		cf := ConditionFilter{Conditions: []Condition{cond}}
+		if err := cf.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Validate() error = %v, want %q", tt.cond, err, tt.want)
+		}
+	}
+}
@@ -1,8 +1,215 @@
 package filters
 
-import "github.com/gin-gonic/gin"
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+)
 
 // Filter는 모든 필터가 구현해야 하는 인터페이스입니다.
+// Process returns true when the request may continue to the next filter.
 type Filter interface {
-	Process(c *gin.Context) bool
+	Process(c *gin.Context) (bool, error)
+}
+
+// Validator is implemented by filters that check their configuration
+// when it is loaded, before any request reaches them.
+type Validator interface {
+	Validate() error
+}
+
+// ChainObserver is implemented by filters that follow the outcome of the
+// rest of the chain. After runs once the later filters and the handler
+// have answered a request the filter let pass.
+type ChainObserver interface {
+	After(c *gin.Context)
+}
+
+// Factory builds a filter from its YAML block
+type Factory func(spec *Spec) (Filter, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a filter type available to the `filters:` list of a route.
+// It is meant to be called from init and panics on duplicate types.
+func Register(filterType string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := registry[filterType]; exists {
+		panic(fmt.Sprintf("filter type %q registered twice", filterType))
+	}
+	registry[filterType] = factory
+}
+
+// Types returns the registered filter types
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	types := make([]string, 0, len(registry))
+	for t := range registry {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Build creates and validates the filter described by spec. A filter with
+// `when:` conditions only runs when they all hold.
+func Build(spec *Spec) (Filter, error) {
+	registryMu.RLock()
+	factory, ok := registry[spec.Type]
+	registryMu.RUnlock()
+
+	if spec.Type == "" {
+		return nil, fmt.Errorf("filter %q has no type", spec.Name)
+	}
+	if !ok {
+		return nil, fmt.Errorf("filter %q has unknown type %q (registered: %v)", spec.Name, spec.Type, Types())
+	}
+
+	filter, err := factory(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%s filter %q: %v", spec.Type, spec.Name, err)
+	}
+	if v, ok := filter.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, fmt.Errorf("%s filter %q: %v", spec.Type, spec.Name, err)
+		}
+	}
+
+	when, err := spec.when()
+	if err != nil {
+		return nil, fmt.Errorf("%s filter %q: when: %v", spec.Type, spec.Name, err)
+	}
+	if when != nil {
+		return &conditionalFilter{when: when, filter: filter}, nil
+	}
+	return filter, nil
+}
+
+// conditionalFilter skips its filter unless the when conditions hold
+type conditionalFilter struct {
+	when   *ConditionFilter
+	filter Filter
+}
+
+func (f *conditionalFilter) Process(c *gin.Context) (bool, error) {
+	if ok, err := f.when.Process(c); !ok {
+		log.Debugf("skipping %T: %v", f.filter, err)
+		return true, nil
+	}
+	return f.filter.Process(c)
+}
+
+// After forwards to an observing filter, which ignores requests it did not process
+func (f *conditionalFilter) After(c *gin.Context) {
+	if observer, ok := f.filter.(ChainObserver); ok {
+		observer.After(c)
+	}
+}
+
+// Spec is one entry of a route's `filters:` list. `type` selects the
+// factory, the remaining keys are the filter's own configuration.
+type Spec struct {
+	Type string
+	Name string
+	// Namespace of the AuthRoute the filter comes from, the filter may only
+	// read Secrets of it. Empty for the routes of the configuration.
+	Namespace string
+	// Route is the method and path of the route of the filter, e.g.
+	// "POST /signin". State such as rate limit counters is kept per route.
+	Route string
+	raw   map[string]interface{}
+}
+
+// NewSpec creates a spec from a filter configuration struct
+func NewSpec(filterType string, config interface{}) (Spec, error) {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return Spec{}, err
+	}
+
+	spec := Spec{Type: filterType}
+	if err := yaml.Unmarshal(data, &spec.raw); err != nil {
+		return Spec{}, err
+	}
+	if spec.raw == nil {
+		spec.raw = make(map[string]interface{})
+	}
+	spec.raw["type"] = filterType
+	spec.Name, _ = spec.raw["filter_name"].(string)
+	return spec, nil
+}
+
+func (s *Spec) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw map[string]interface{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		return fmt.Errorf("empty filter")
+	}
+
+	filterType, ok := raw["type"].(string)
+	if !ok {
+		return fmt.Errorf("filter requires a string type")
+	}
+
+	s.Type = filterType
+	s.Name, _ = raw["filter_name"].(string)
+	s.raw = raw
+	return nil
+}
+
+func (s Spec) MarshalYAML() (interface{}, error) {
+	return s.raw, nil
+}
+
+// Decode unmarshals the filter's configuration, without `type` and `when`,
+// into out. Unknown keys are rejected so typos fail when the config is applied.
+func (s *Spec) Decode(out interface{}) error {
+	config := make(map[string]interface{}, len(s.raw))
+	for k, v := range s.raw {
+		if k != "type" && k != "when" {
+			config[k] = v
+		}
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return yaml.UnmarshalStrict(data, out)
+}
+
+// when returns the validated `when:` conditions of the filter, nil without them
+func (s *Spec) when() (*ConditionFilter, error) {
+	raw, ok := s.raw["when"]
+	if !ok {
+		return nil, nil
+	}
+
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	when := &ConditionFilter{FilterName: s.Name + ".when"}
+	if err := yaml.UnmarshalStrict(data, &when.Conditions); err != nil {
+		return nil, err
+	}
+	if len(when.Conditions) == 0 {
+		return nil, fmt.Errorf("no conditions")
+	}
+	if err := when.Validate(); err != nil {
+		return nil, err
+	}
+	return when, nil
 }
@@ -0,0 +1,96 @@
+package filters
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestBuildFromSpec(t *testing.T) {
+	var specs []Spec
+	data := `
+- type: condition
+  filter_name: tenant
+  conditions:
+    - field: header
+      key: X-Tenant
+      operator: equals
+      value: team-a
+- type: request
+  filter_name: user-lookup
+  remote_server: http://user-service/lookup
+  fields_to_send: ["username"]
+`
+	if err := yaml.Unmarshal([]byte(data), &specs); err != nil {
+		t.Fatalf("Failed to unmarshal specs: %v", err)
+	}
+	if len(specs) != 2 || specs[0].Type != "condition" || specs[1].Name != "user-lookup" {
+		t.Fatalf("unexpected specs: %+v", specs)
+	}
+
+	cf, err := Build(&specs[0])
+	if err != nil {
+		t.Fatalf("Build(condition) error = %v", err)
+	}
+	if got, ok := cf.(*ConditionFilter); !ok || len(got.Conditions) != 1 {
+		t.Errorf("unexpected condition filter: %+v", cf)
+	}
+
+	rf, err := Build(&specs[1])
+	if err != nil {
+		t.Fatalf("Build(request) error = %v", err)
+	}
+	if got, ok := rf.(*RequestFilter); !ok || got.RemoteServer != "http://user-service/lookup" {
+		t.Errorf("unexpected request filter: %+v", rf)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"unknown type", "type: teleport\nfilter_name: x", "unknown type"},
+		{"unknown key", "type: condition\nfilter_name: x\nconditons: []", "conditons"},
+		{"invalid config", "type: condition\nfilter_name: x\nconditions: [{field: form, key: a, operator: equals, value: a}]", "unknown field"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var spec Spec
+			if err := yaml.Unmarshal([]byte(tt.data), &spec); err != nil {
+				t.Fatalf("Failed to unmarshal spec: %v", err)
+			}
+			_, err := Build(&spec)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Build() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	var spec Spec
+	if err := yaml.Unmarshal([]byte("filter_name: x"), &spec); err == nil {
+		t.Error("expected error for a filter without type")
+	}
+}
+
+func TestNewSpecRoundTrip(t *testing.T) {
+	spec, err := NewSpec("request", RequestFilter{FilterName: "legacy", RemoteServer: "http://a/b"})
+	if err != nil {
+		t.Fatalf("NewSpec() error = %v", err)
+	}
+	if spec.Name != "legacy" {
+		t.Errorf("Expected name legacy, got %q", spec.Name)
+	}
+
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var decoded Spec
+	if err := yaml.Unmarshal(data, &decoded); err != nil || decoded.Type != "request" {
+		t.Fatalf("round trip failed: %v %+v", err, decoded)
+	}
+}
@@ -0,0 +1,274 @@
+package filters
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+func init() {
+	Register("geo", func(spec *Spec) (Filter, error) {
+		gf := &GeoFilter{}
+		if err := spec.Decode(gf); err != nil {
+			return nil, err
+		}
+		return gf, nil
+	})
+}
+
+// GeoFilter looks up the client IP in MaxMind format databases (GeoIP2 or
+// GeoLite2 Country, City and ASN) and allows or denies it by country and
+// autonomous system. The result is stored as collected data of filter_name,
+// e.g. {"ip": "...", "country": "DE", "continent": "EU", "asn": 16509,
+// "as_org": "AMAZON-02", "flagged": true}, for conditions, templates and cel.
+type GeoFilter struct {
+	FilterName string `yaml:"filter_name"`
+	// Databases are the .mmdb files, a country or city database and an ASN
+	// database are looked up together. Files replaced on disk are reloaded.
+	Databases []string `yaml:"databases"`
+
+	// ISO 3166-1 alpha-2 country codes and AS numbers. A deny match always
+	// rejects, a non-empty allow list rejects everything outside it.
+	AllowCountries []string `yaml:"allow_countries,omitempty"`
+	DenyCountries  []string `yaml:"deny_countries,omitempty"`
+	AllowASNs      []uint   `yaml:"allow_asns,omitempty"`
+	DenyASNs       []uint   `yaml:"deny_asns,omitempty"`
+	// AllowUnknown passes addresses without a country or AS, such as private
+	// ranges, through the allow lists
+	AllowUnknown bool `yaml:"allow_unknown,omitempty"`
+	// FlagCountries and FlagASNs do not reject, they set flagged in the
+	// collected data, e.g. for hosting providers
+	FlagCountries []string `yaml:"flag_countries,omitempty"`
+	FlagASNs      []uint   `yaml:"flag_asns,omitempty"`
+	Message       string   `yaml:"message,omitempty"`
+
+	databases []*geoDatabase
+}
+
+// geoRecord holds the fields of the country, city and ASN databases
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// Validate opens the databases and checks the rules
+func (gf *GeoFilter) Validate() error {
+	if len(gf.Databases) == 0 {
+		return fmt.Errorf("databases is required")
+	}
+	for name, codes := range map[string][]string{
+		"allow_countries": gf.AllowCountries,
+		"deny_countries":  gf.DenyCountries,
+		"flag_countries":  gf.FlagCountries,
+	} {
+		for _, code := range codes {
+			if len(code) != 2 || strings.ToUpper(code) != code {
+				return fmt.Errorf("%s: %q is not an ISO 3166-1 alpha-2 code such as DE", name, code)
+			}
+		}
+	}
+
+	databases := make([]*geoDatabase, 0, len(gf.Databases))
+	for _, path := range gf.Databases {
+		db, err := openGeoDatabase(path)
+		if err != nil {
+			return err
+		}
+		databases = append(databases, db)
+	}
+	gf.databases = databases
+	return nil
+}
+
+// Process looks up the client IP and applies the deny and allow lists
+func (gf *GeoFilter) Process(c *gin.Context) (bool, error) {
+	log.Infof("start geo filter: %s", gf.FilterName)
+	if gf.databases == nil {
+		if err := gf.Validate(); err != nil {
+			return false, err
+		}
+	}
+
+	clientIP := c.ClientIP()
+	record := gf.lookup(net.ParseIP(clientIP))
+	country, asn := record.Country.ISOCode, record.ASN
+	flagged := containsString(gf.FlagCountries, country) || containsUint(gf.FlagASNs, asn)
+
+	if gf.FilterName != "" {
+		// numbers as decoded from JSON responses
+		SetCollected(c, gf.FilterName, map[string]interface{}{
+			"ip":        clientIP,
+			"country":   country,
+			"continent": record.Continent.Code,
+			"asn":       float64(asn),
+			"as_org":    record.ASOrg,
+			"flagged":   flagged,
+		})
+	}
+
+	message := gf.Message
+	if message == "" {
+		message = "requests from your location are not allowed"
+	}
+	rejected := &FilterError{Status: http.StatusForbidden, Message: message}
+
+	switch {
+	case country != "" && containsString(gf.DenyCountries, country):
+		log.Warningf("Geo filter %s: %s from denied country %s", gf.FilterName, clientIP, country)
+		return false, rejected
+	case asn != 0 && containsUint(gf.DenyASNs, asn):
+		log.Warningf("Geo filter %s: %s from denied AS%d", gf.FilterName, clientIP, asn)
+		return false, rejected
+	case len(gf.AllowCountries) > 0 && !containsString(gf.AllowCountries, country) && !(country == "" && gf.AllowUnknown):
+		log.Warningf("Geo filter %s: %s from country %q is not allowed", gf.FilterName, clientIP, country)
+		return false, rejected
+	case len(gf.AllowASNs) > 0 && !containsUint(gf.AllowASNs, asn) && !(asn == 0 && gf.AllowUnknown):
+		log.Warningf("Geo filter %s: %s from AS%d is not allowed", gf.FilterName, clientIP, asn)
+		return false, rejected
+	}
+	if flagged {
+		log.Warningf("Geo filter %s: flagged %s from %s AS%d", gf.FilterName, clientIP, country, asn)
+	}
+	return true, nil
+}
+
+// lookup merges the records of every database, a failed lookup leaves the
+// fields of that database empty
+func (gf *GeoFilter) lookup(ip net.IP) geoRecord {
+	var record geoRecord
+	if ip == nil {
+		return record
+	}
+	for _, db := range gf.databases {
+		reader, err := db.get()
+		if err != nil {
+			log.Errorf("Geo database %s: %v", db.path, err)
+			continue
+		}
+		var found geoRecord
+		if _, _, err := reader.LookupNetwork(ip, &found); err != nil {
+			log.Warningf("Geo lookup of %s in %s: %v", ip, db.path, err)
+			continue
+		}
+		if found.Country.ISOCode != "" {
+			record.Country, record.Continent = found.Country, found.Continent
+		}
+		if found.ASN != 0 {
+			record.ASN, record.ASOrg = found.ASN, found.ASOrg
+		}
+	}
+	return record
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUint(values []uint, value uint) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// geoDatabase is a .mmdb file shared by the filters using it. The file is
+// checked for changes at most once per geoReloadInterval, so updates of
+// geoipupdate or a remounted volume are picked up without a restart.
+type geoDatabase struct {
+	path string
+
+	mu      sync.Mutex
+	reader  *maxminddb.Reader
+	modTime time.Time
+	checked time.Time
+}
+
+var geoReloadInterval = time.Minute
+
+var geoDatabases = struct {
+	sync.Mutex
+	databases map[string]*geoDatabase
+}{databases: make(map[string]*geoDatabase)}
+
+// openGeoDatabase returns the shared database of path, loading it first
+func openGeoDatabase(path string) (*geoDatabase, error) {
+	geoDatabases.Lock()
+	db, ok := geoDatabases.databases[path]
+	if !ok {
+		db = &geoDatabase{path: path}
+		geoDatabases.databases[path] = db
+	}
+	geoDatabases.Unlock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.reload(); err != nil {
+		return nil, fmt.Errorf("geo database %s: %v", path, err)
+	}
+	return db, nil
+}
+
+// get returns the reader, reloading the file when it changed
+func (db *geoDatabase) get() (*maxminddb.Reader, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if time.Since(db.checked) >= geoReloadInterval {
+		if err := db.reload(); err != nil {
+			// keep serving the last good version
+			log.Errorf("Failed to reload geo database %s: %v", db.path, err)
+		}
+	}
+	if db.reader == nil {
+		return nil, fmt.Errorf("not loaded")
+	}
+	return db.reader, nil
+}
+
+// reload reads the file if it changed since the last load. db.mu must be held.
+func (db *geoDatabase) reload() error {
+	db.checked = time.Now()
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return err
+	}
+	if db.reader != nil && info.ModTime().Equal(db.modTime) {
+		return nil
+	}
+
+	// read into memory rather than mmap, a replaced file must not be
+	// unmapped while requests still use the previous reader
+	data, err := os.ReadFile(db.path)
+	if err != nil {
+		return err
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return err
+	}
+	if db.reader != nil {
+		log.Infof("Reloaded geo database %s (%s)", db.path, reader.Metadata.DatabaseType)
+	}
+	db.reader, db.modTime = reader, info.ModTime()
+	return nil
+}
@@ -0,0 +1,279 @@
+package filters
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// writeMMDB writes an IPv4 MaxMind DB with 24 bit records, networks maps
+// CIDRs to their data
+func writeMMDB(t *testing.T, path string, networks map[string]map[string]interface{}) {
+	t.Helper()
+
+	type record struct {
+		node int // index of the child node, -1 for none
+		data int // offset in the data section, -1 for none
+	}
+	nodes := [][2]record{{{-1, -1}, {-1, -1}}}
+	var data bytes.Buffer
+
+	cidrs := make([]string, 0, len(networks))
+	for cidr := range networks {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("invalid network %s: %v", cidr, err)
+		}
+		ones, _ := network.Mask.Size()
+		ip := network.IP.To4()
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := (ip[i/8] >> (7 - i%8)) & 1
+			if i == ones-1 {
+				nodes[node][bit] = record{node: -1, data: data.Len()}
+				break
+			}
+			if nodes[node][bit].node < 0 {
+				nodes = append(nodes, [2]record{{-1, -1}, {-1, -1}})
+				nodes[node][bit] = record{node: len(nodes) - 1, data: -1}
+			}
+			node = nodes[node][bit].node
+		}
+		encodeMMDB(&data, networks[cidr])
+	}
+
+	var out bytes.Buffer
+	nodeCount := len(nodes)
+	for _, n := range nodes {
+		for _, r := range n {
+			value := nodeCount // empty
+			switch {
+			case r.node >= 0:
+				value = r.node
+			case r.data >= 0:
+				value = nodeCount + 16 + r.data
+			}
+			out.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.WriteString("\xab\xcd\xefMaxMind.com")
+	encodeMMDB(&out, map[string]interface{}{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint32(24),
+		"ip_version":                  uint32(4),
+		"database_type":               "OpenAuth-Test",
+		"binary_format_major_version": uint32(2),
+	})
+
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+// encodeMMDB encodes strings, uint32 and maps in the MaxMind DB data format
+func encodeMMDB(buf *bytes.Buffer, value interface{}) {
+	control := func(typ, size int) {
+		if size < 29 {
+			buf.WriteByte(byte(typ<<5 | size))
+			return
+		}
+		buf.WriteByte(byte(typ<<5 | 29))
+		buf.WriteByte(byte(size - 29))
+	}
+
+	switch v := value.(type) {
+	case string:
+		control(2, len(v))
+		buf.WriteString(v)
+	case uint32:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, v)
+		b = bytes.TrimLeft(b, "\x00")
+		control(6, len(b))
+		buf.Write(b)
+	case map[string]interface{}:
+		control(7, len(v))
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			encodeMMDB(buf, key)
+			encodeMMDB(buf, v[key])
+		}
+	default:
+		panic("unsupported type")
+	}
+}
+
+func geoCountry(code, continent string) map[string]interface{} {
+	return map[string]interface{}{
+		"country":   map[string]interface{}{"iso_code": code},
+		"continent": map[string]interface{}{"code": continent},
+	}
+}
+
+func geoASN(number uint32, org string) map[string]interface{} {
+	return map[string]interface{}{
+		"autonomous_system_number":       number,
+		"autonomous_system_organization": org,
+	}
+}
+
+func newGeoTestDatabases(t *testing.T) []string {
+	dir := t.TempDir()
+	countries := filepath.Join(dir, "country.mmdb")
+	asns := filepath.Join(dir, "asn.mmdb")
+	writeMMDB(t, countries, map[string]map[string]interface{}{
+		"203.0.113.0/24":  geoCountry("RU", "EU"),
+		"198.51.100.0/24": geoCountry("DE", "EU"),
+		"192.0.2.0/24":    geoCountry("US", "NA"),
+	})
+	writeMMDB(t, asns, map[string]map[string]interface{}{
+		"198.51.100.0/25": geoASN(16509, "AMAZON-02"),
+		"192.0.2.0/24":    geoASN(64500, "EXAMPLE"),
+	})
+	return []string{countries, asns}
+}
+
+func geoRequest(remoteAddr string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/signin", nil)
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func TestGeoFilter(t *testing.T) {
+	gf := &GeoFilter{
+		FilterName:    "geo",
+		Databases:     newGeoTestDatabases(t),
+		DenyCountries: []string{"RU"},
+		DenyASNs:      []uint{64500},
+		FlagASNs:      []uint{16509},
+	}
+	if err := gf.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		want       bool
+		collected  map[string]interface{}
+	}{
+		{"203.0.113.5:4000", false, nil},
+		{"192.0.2.1:4000", false, nil},
+		{"198.51.100.10:4000", true, map[string]interface{}{
+			"ip": "198.51.100.10", "country": "DE", "continent": "EU",
+			"asn": float64(16509), "as_org": "AMAZON-02", "flagged": true,
+		}},
+		{"198.51.100.200:4000", true, map[string]interface{}{
+			"ip": "198.51.100.200", "country": "DE", "continent": "EU",
+			"asn": float64(0), "as_org": "", "flagged": false,
+		}},
+		{"10.0.0.1:4000", true, nil},
+		{"[2001:db8::1]:4000", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			c := newTestContext(geoRequest(tt.remoteAddr))
+			ok, err := gf.Process(c)
+			if ok != tt.want {
+				t.Fatalf("Process() = %v, %v, want %v", ok, err, tt.want)
+			}
+			var fe *FilterError
+			if !ok && (!errors.As(err, &fe) || fe.Status != http.StatusForbidden) {
+				t.Errorf("Process() error = %v, want 403", err)
+			}
+			if tt.collected != nil && !reflect.DeepEqual(Collected(c)["geo"], tt.collected) {
+				t.Errorf("collected = %v, want %v", Collected(c)["geo"], tt.collected)
+			}
+		})
+	}
+}
+
+func TestGeoFilterAllowLists(t *testing.T) {
+	databases := newGeoTestDatabases(t)
+
+	tests := []struct {
+		name       string
+		filter     GeoFilter
+		remoteAddr string
+		want       bool
+	}{
+		{"allowed country", GeoFilter{AllowCountries: []string{"DE"}}, "198.51.100.10:4000", true},
+		{"other country", GeoFilter{AllowCountries: []string{"DE"}}, "192.0.2.1:4000", false},
+		{"unknown country", GeoFilter{AllowCountries: []string{"DE"}}, "10.0.0.1:4000", false},
+		{"allow unknown", GeoFilter{AllowCountries: []string{"DE"}, AllowUnknown: true}, "10.0.0.1:4000", true},
+		{"allowed AS", GeoFilter{AllowASNs: []uint{64500}}, "192.0.2.1:4000", true},
+		{"other AS", GeoFilter{AllowASNs: []uint{64500}}, "198.51.100.10:4000", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gf := tt.filter
+			gf.Databases = databases
+			if err := gf.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if ok, err := gf.Process(newTestContext(geoRequest(tt.remoteAddr))); ok != tt.want {
+				t.Errorf("Process() = %v, %v, want %v", ok, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeoFilterReload(t *testing.T) {
+	databases := newGeoTestDatabases(t)
+	defer func(interval time.Duration) { geoReloadInterval = interval }(geoReloadInterval)
+	geoReloadInterval = 0
+
+	gf := &GeoFilter{Databases: databases[:1], DenyCountries: []string{"FR"}}
+	if err := gf.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if ok, _ := gf.Process(newTestContext(geoRequest("198.51.100.10:4000"))); !ok {
+		t.Fatalf("Process() rejected DE before the update")
+	}
+
+	writeMMDB(t, databases[0], map[string]map[string]interface{}{"198.51.100.0/24": geoCountry("FR", "EU")})
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(databases[0], later, later)
+	if ok, _ := gf.Process(newTestContext(geoRequest("198.51.100.10:4000"))); ok {
+		t.Errorf("Process() passed after the database moved the network to FR")
+	}
+
+	// a broken update keeps the last good version
+	os.WriteFile(databases[0], []byte("not a database"), 0644)
+	os.Chtimes(databases[0], later.Add(time.Minute), later.Add(time.Minute))
+	if ok, _ := gf.Process(newTestContext(geoRequest("198.51.100.10:4000"))); ok {
+		t.Errorf("Process() lost the database after a broken update")
+	}
+}
+
+func TestGeoFilterValidate(t *testing.T) {
+	databases := newGeoTestDatabases(t)
+	for _, gf := range []GeoFilter{
+		{},
+		{Databases: []string{filepath.Join(t.TempDir(), "missing.mmdb")}},
+		{Databases: databases, DenyCountries: []string{"de"}},
+		{Databases: databases, FlagCountries: []string{"DEU"}},
+	} {
+		if err := gf.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded, want error", gf)
+		}
+	}
+}
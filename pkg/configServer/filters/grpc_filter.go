@@ -0,0 +1,515 @@
+package filters
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func init() {
+	Register("grpc", func(spec *Spec) (Filter, error) {
+		gf := &GRPCFilter{}
+		if err := spec.Decode(gf); err != nil {
+			return nil, err
+		}
+		return gf, nil
+	})
+}
+
+// GRPCFilter calls a unary gRPC method and passes when it returns OK. The
+// request message is built from the templated request mapping, the response
+// message is checked by the response rules as JSON with the field names of
+// the proto file. gRPC status codes are mapped to HTTP statuses for
+// success_status and errors (NOT_FOUND is 404, UNAUTHENTICATED 401, ...).
+type GRPCFilter struct {
+	FilterName string `yaml:"filter_name"`
+	// Target is host:port, k8s://<service>.<namespace>:<port> or
+	// k8s+https://<service>.<namespace>:<port>
+	Target string `yaml:"target"`
+	// Method is the fully qualified method, package.Service/Method
+	Method string `yaml:"method"`
+	// Request maps the fields of the request message, string values may
+	// contain ${path} placeholders
+	Request map[string]interface{} `yaml:"request,omitempty"`
+	// Metadata is sent with the call, values may contain placeholders
+	Metadata map[string]string `yaml:"metadata,omitempty"`
+	// DescriptorSet is a file written by protoc --include_imports
+	// --descriptor_set_out. Without it the server reflection service is used.
+	DescriptorSet string `yaml:"descriptor_set,omitempty"`
+	// Response decides which responses pass
+	Response *ResponseRules `yaml:"response,omitempty"`
+
+	// Timeout of one call, 10s by default
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// FailureMode is fail_closed (default) or fail_open, see RequestFilter
+	FailureMode string `yaml:"failure_mode,omitempty"`
+	// TLS enables TLS, k8s+https:// targets use the global tls: block by default
+	TLS *TLSConfig `yaml:"tls,omitempty"`
+
+	fullMethod string // /package.Service/Method
+	service    string
+	request    interface{} // request with *Template leaves
+	metadata   map[string]*Template
+	creds      credentials.TransportCredentials
+	credsKey   string
+
+	mu     sync.Mutex
+	method protoreflect.MethodDescriptor
+}
+
+// Validate checks the target and method and loads the descriptor set
+func (gf *GRPCFilter) Validate() error {
+	if gf.Target == "" {
+		return fmt.Errorf("target is required")
+	}
+	service, method, ok := strings.Cut(strings.TrimPrefix(gf.Method, "/"), "/")
+	if !ok || !strings.Contains(service, ".") || method == "" || strings.Contains(method, "/") {
+		return fmt.Errorf("method must look like package.Service/Method, got %q", gf.Method)
+	}
+	gf.fullMethod, gf.service = "/"+service+"/"+method, service
+
+	useTLS := gf.TLS != nil
+	serverName := ""
+	if isServiceRef(gf.Target) {
+		ref, err := ParseServiceRef(gf.Target)
+		if err != nil {
+			return err
+		}
+		if ref.TLS {
+			useTLS, serverName = true, ref.ServerName()
+		}
+	} else if strings.Contains(gf.Target, "://") {
+		return fmt.Errorf("target must be host:port, %s:// or %s://, got %q", ServiceScheme, ServiceSchemeTLS, gf.Target)
+	}
+
+	gf.creds, gf.credsKey = insecure.NewCredentials(), "insecure"
+	if useTLS {
+		settings := gf.TLS
+		if settings == nil {
+			settings = getDefaultTLS()
+		}
+		tlsConfig := TLSConfig{}
+		if settings != nil {
+			tlsConfig = *settings
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = serverName
+		}
+		config, key, err := tlsConfig.build()
+		if err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+		gf.creds, gf.credsKey = credentials.NewTLS(config), key
+	}
+
+	if gf.Request != nil {
+		doc, err := toJSONObject(gf.Request)
+		if err != nil {
+			return fmt.Errorf("request: %v", err)
+		}
+		if gf.request, err = compileBody(doc); err != nil {
+			return fmt.Errorf("request: %v", err)
+		}
+	}
+	gf.metadata = make(map[string]*Template, len(gf.Metadata))
+	for name, value := range gf.Metadata {
+		t, err := ParseTemplate(value)
+		if err != nil {
+			return fmt.Errorf("metadata %s: %v", name, err)
+		}
+		gf.metadata[strings.ToLower(name)] = t
+	}
+
+	if gf.Response != nil {
+		if err := gf.Response.Validate(); err != nil {
+			return fmt.Errorf("response: %v", err)
+		}
+	}
+	if gf.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	switch gf.FailureMode {
+	case "", "fail_closed", "fail_open":
+	default:
+		return fmt.Errorf("failure_mode must be fail_closed or fail_open, got %q", gf.FailureMode)
+	}
+
+	// methods of the server reflection are looked up on the first call
+	gf.method = nil
+	if gf.DescriptorSet != "" {
+		data, err := os.ReadFile(gf.DescriptorSet)
+		if err != nil {
+			return fmt.Errorf("error reading descriptor_set: %v", err)
+		}
+		set := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(data, set); err != nil {
+			return fmt.Errorf("invalid descriptor_set: %v", err)
+		}
+		if gf.method, err = findMethod(set, gf.service, method); err != nil {
+			return fmt.Errorf("descriptor_set: %v", err)
+		}
+	}
+	return nil
+}
+
+// Process calls the method and stores the response message under the filter name
+func (gf *GRPCFilter) Process(c *gin.Context) (bool, error) {
+	log.Infof("start grpc filter: %s", gf.FilterName)
+
+	if gf.fullMethod == "" {
+		if err := gf.Validate(); err != nil {
+			return false, err
+		}
+	}
+
+	input := RequestInput(c)
+	var request interface{} = map[string]interface{}{}
+	if gf.request != nil {
+		var err error
+		if request, err = renderBody(gf.request, input); err != nil {
+			return false, fmt.Errorf("error rendering request: %v", err)
+		}
+	}
+	md := metadata.MD{}
+	for name, t := range gf.metadata {
+		value, err := t.Render(input, nil)
+		if err != nil {
+			return false, fmt.Errorf("error rendering metadata %s: %v", name, err)
+		}
+		md.Set(name, value)
+	}
+
+	conn, err := gf.conn()
+	if err != nil {
+		return gf.unavailable(callErrorConnection, err)
+	}
+
+	timeout := gf.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	method, err := gf.methodDescriptor(ctx, conn)
+	if err != nil {
+		return gf.unavailable(grpcErrorKind(err), err)
+	}
+
+	in := dynamicpb.NewMessage(method.Input())
+	data, err := json.Marshal(request)
+	if err != nil {
+		return false, fmt.Errorf("error encoding request: %v", err)
+	}
+	if err := protojson.Unmarshal(data, in); err != nil {
+		return false, fmt.Errorf("request does not match %s: %v", method.Input().FullName(), err)
+	}
+
+	out := dynamicpb.NewMessage(method.Output())
+	var trailer metadata.MD
+	log.Debugf("gRPC call: %s %s", gf.Target, gf.fullMethod)
+	err = conn.Invoke(metadata.NewOutgoingContext(ctx, md), gf.fullMethod, in, out, grpc.Trailer(&trailer))
+
+	rules := gf.rules()
+	if err != nil {
+		st := status.Convert(err)
+		statusCode := httpStatusFromCode(st.Code())
+		var body []byte
+		if st.Code() == codes.Unavailable || st.Code() == codes.DeadlineExceeded {
+			kind := grpcErrorKind(err)
+			if gf.FailureMode == "fail_open" || rules.mapsError(kind) {
+				return gf.unavailable(kind, err)
+			}
+			// the message of a failed connection names the dialed address
+			log.Errorf("gRPC call to %s failed (%s): %s", gf.Target, st.Code(), st.Message())
+		} else {
+			log.Warningf("gRPC call not allowed (%s): %s", st.Code(), st.Message())
+			body, _ = json.Marshal(map[string]string{"message": st.Message()})
+		}
+		if rules.isSuccess(statusCode) {
+			// a listed error status passes without a response message
+			return true, nil
+		}
+		return false, rules.failure(&http.Response{StatusCode: statusCode, Header: metadataHeader(trailer)}, body)
+	}
+	if !rules.isSuccess(http.StatusOK) {
+		return false, rules.failure(&http.Response{StatusCode: http.StatusOK, Header: metadataHeader(trailer)}, nil)
+	}
+
+	data, err = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(out)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode response: %v", err)
+	}
+	var result interface{}
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&result); err != nil {
+		return false, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if err := rules.check(result); err != nil {
+		log.Warningf("Response rejected: %v", err)
+		return false, err
+	}
+
+	if gf.FilterName != "" {
+		SetCollected(c, gf.FilterName, result)
+	}
+	return true, nil
+}
+
+// unavailable rejects the request, or lets it pass with fail_open
+func (gf *GRPCFilter) unavailable(kind string, err error) (bool, error) {
+	if gf.FailureMode == "fail_open" {
+		log.Warningf("gRPC server unavailable, passing request (fail_open): %v", err)
+		return true, nil
+	}
+	log.Errorf("Error calling %s on %s (%s): %v", gf.fullMethod, gf.Target, kind, err)
+	return false, gf.rules().unavailable(kind, err)
+}
+
+func (gf *GRPCFilter) rules() *ResponseRules {
+	if gf.Response == nil {
+		return &ResponseRules{}
+	}
+	return gf.Response
+}
+
+// grpcErrorKind tells deadlines from other failed calls
+func grpcErrorKind(err error) string {
+	if status.Code(err) == codes.DeadlineExceeded {
+		return callErrorTimeout
+	}
+	return callErrorKind(err)
+}
+
+// conn returns the shared connection to the target, calls to k8s://
+// targets are balanced over the ready endpoints
+func (gf *GRPCFilter) conn() (*grpc.ClientConn, error) {
+	return grpcConns.get(gf.Target, gf.credsKey, gf.creds)
+}
+
+// methodDescriptor returns the method of the descriptor set, or asks the
+// server reflection service once it is needed
+func (gf *GRPCFilter) methodDescriptor(ctx context.Context, conn *grpc.ClientConn) (protoreflect.MethodDescriptor, error) {
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+
+	if gf.method != nil {
+		return gf.method, nil
+	}
+	set, err := reflectFiles(ctx, conn, gf.service)
+	if err != nil {
+		return nil, fmt.Errorf("server reflection: %v", err)
+	}
+	name := gf.fullMethod[strings.LastIndex(gf.fullMethod, "/")+1:]
+	method, err := findMethod(set, gf.service, name)
+	if err != nil {
+		return nil, fmt.Errorf("server reflection: %v", err)
+	}
+	gf.method = method
+	return method, nil
+}
+
+// findMethod looks up service/method in a set of file descriptors
+func findMethod(set *descriptorpb.FileDescriptorSet, service, method string) (protoreflect.MethodDescriptor, error) {
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service %s not found", service)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("method %s not found in %s", method, service)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("method %s/%s is streaming, only unary methods are supported", service, method)
+	}
+	return md, nil
+}
+
+// reflectFiles fetches the file defining symbol and its dependencies from
+// the server reflection service
+func reflectFiles(ctx context.Context, conn *grpc.ClientConn, symbol string) (*descriptorpb.FileDescriptorSet, error) {
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]bool)
+	pending := []*reflectionpb.ServerReflectionRequest{{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+	}}
+	for len(pending) > 0 {
+		req := pending[0]
+		pending = pending[1:]
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if e := resp.GetErrorResponse(); e != nil {
+			return nil, fmt.Errorf("%s", e.GetErrorMessage())
+		}
+
+		for _, raw := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			file := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(raw, file); err != nil {
+				return nil, err
+			}
+			if seen[file.GetName()] {
+				continue
+			}
+			seen[file.GetName()] = true
+			set.File = append(set.File, file)
+			for _, dep := range file.GetDependency() {
+				if seen[dep] {
+					continue
+				}
+				// well-known types are linked into OpenAuth
+				if known, err := protoregistry.GlobalFiles.FindFileByPath(dep); err == nil {
+					seen[dep] = true
+					set.File = append(set.File, protodesc.ToFileDescriptorProto(known))
+					continue
+				}
+				pending = append(pending, &reflectionpb.ServerReflectionRequest{
+					MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+				})
+			}
+		}
+	}
+	return set, nil
+}
+
+// grpcConnPool shares client connections between filters calling the same
+// target with the same credentials. Connections not used for
+// grpcIdleTimeout, e.g. of targets removed from the configuration, are closed.
+type grpcConnPool struct {
+	mu    sync.Mutex
+	conns map[string]*pooledConn
+	swept time.Time
+	now   func() time.Time
+}
+
+type pooledConn struct {
+	conn *grpc.ClientConn
+	used time.Time
+}
+
+var grpcIdleTimeout = 10 * time.Minute
+
+var grpcConns = &grpcConnPool{conns: make(map[string]*pooledConn), now: time.Now}
+
+func (p *grpcConnPool) get(target, credsKey string, creds credentials.TransportCredentials) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	if now.Sub(p.swept) >= grpcIdleTimeout {
+		p.closeIdle(now)
+	}
+
+	key := credsKey + "|" + target
+	if pc, ok := p.conns[key]; ok {
+		pc.used = now
+		return pc.conn, nil
+	}
+
+	options := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if isServiceRef(target) {
+		scheme, _, _ := strings.Cut(target, "://")
+		options = append(options,
+			grpc.WithResolvers(serviceResolverBuilder{scheme: scheme}),
+			grpc.WithDefaultServiceConfig(roundRobinConfig))
+	}
+	conn, err := grpc.NewClient(target, options...)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[key] = &pooledConn{conn: conn, used: now}
+	return conn, nil
+}
+
+// closeIdle closes the connections unused for grpcIdleTimeout. p.mu must be held.
+func (p *grpcConnPool) closeIdle(now time.Time) {
+	p.swept = now
+	for key, pc := range p.conns {
+		if now.Sub(pc.used) >= grpcIdleTimeout {
+			log.Infof("Closing idle gRPC connection %s", pc.conn.Target())
+			pc.conn.Close()
+			delete(p.conns, key)
+		}
+	}
+}
+
+// httpStatusFromCode maps gRPC status codes to HTTP statuses for the response rules
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// metadataHeader exposes trailer metadata to forward_headers of the error mappings
+func metadataHeader(md metadata.MD) http.Header {
+	header := http.Header{}
+	for name, values := range md {
+		for _, value := range values {
+			header.Add(name, value)
+		}
+	}
+	return header
+}
@@ -0,0 +1,258 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"gopkg.in/yaml.v2"
+)
+
+// newHealthServer serves the gRPC health service, with server reflection when reflect is set
+func newHealthServer(t *testing.T, reflect bool) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	checker := health.NewServer()
+	checker.SetServingStatus("auth", healthpb.HealthCheckResponse_SERVING)
+	checker.SetServingStatus("billing", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(server, checker)
+	if reflect {
+		reflection.Register(server)
+	}
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+func newGRPCFilter(t *testing.T, data string) *GRPCFilter {
+	var gf GRPCFilter
+	if err := yaml.UnmarshalStrict([]byte(data), &gf); err != nil {
+		t.Fatalf("Failed to unmarshal filter: %v", err)
+	}
+	if err := gf.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	return &gf
+}
+
+func TestGRPCFilterReflection(t *testing.T) {
+	target := newHealthServer(t, true)
+	gf := newGRPCFilter(t, `
+filter_name: health
+target: "`+target+`"
+method: grpc.health.v1.Health/Check
+request:
+  service: "${body.service}"
+metadata:
+  x-tenant: "${headers.x-tenant}"
+response:
+  assert:
+    - path: status
+      operator: equals
+      value: SERVING
+      message: service is not serving
+  errors:
+    - status: [404]
+      respond_with: 401
+      message: unknown service
+`)
+
+	tests := []struct {
+		service    string
+		wantOK     bool
+		wantStatus int
+	}{
+		{service: "auth", wantOK: true},
+		{service: "billing", wantStatus: http.StatusForbidden},
+		{service: "missing", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.service, func(t *testing.T) {
+			c := newFilterContext(`{"service": "` + tt.service + `"}`)
+			ok, err := gf.Process(c)
+			if ok != tt.wantOK {
+				t.Fatalf("Process() = %v, %v, want %v", ok, err, tt.wantOK)
+			}
+			if tt.wantOK {
+				if got := Collected(c)["health"]; got.(map[string]interface{})["status"] != "SERVING" {
+					t.Errorf("collected = %v", got)
+				}
+				return
+			}
+			var fe *FilterError
+			if tt.wantStatus == http.StatusForbidden {
+				if err == nil || !strings.Contains(err.Error(), "not serving") {
+					t.Errorf("Process() error = %v, want failed assertion", err)
+				}
+			} else if !errors.As(err, &fe) || fe.Status != tt.wantStatus {
+				t.Errorf("Process() error = %v, want status %d", err, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestGRPCFilterDescriptorSet(t *testing.T) {
+	target := newHealthServer(t, false)
+
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(healthpb.File_grpc_health_v1_health_proto),
+	}}
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "health.pb")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	gf := newGRPCFilter(t, "filter_name: health\ntarget: "+target+"\nmethod: /grpc.health.v1.Health/Check\ndescriptor_set: "+path+"\nrequest:\n  service: auth\n")
+	if ok, err := gf.Process(newFilterContext(`{}`)); !ok || err != nil {
+		t.Errorf("Process() = %v, %v, want pass", ok, err)
+	}
+
+	// without reflection or a descriptor set the method cannot be found
+	noDescriptors := newGRPCFilter(t, "target: "+target+"\nmethod: grpc.health.v1.Health/Check\n")
+	if ok, err := noDescriptors.Process(newFilterContext(`{}`)); ok || err == nil || !strings.Contains(fmt.Sprint(errors.Unwrap(err)), "server reflection") {
+		t.Errorf("Process() = %v, %v, want reflection error", ok, err)
+	}
+
+	streaming := GRPCFilter{Target: target, Method: "grpc.health.v1.Health/Watch", DescriptorSet: path}
+	if err := streaming.Validate(); err == nil || !strings.Contains(err.Error(), "streaming") {
+		t.Errorf("Validate() error = %v, want streaming error", err)
+	}
+}
+
+func TestGRPCFilterValidate(t *testing.T) {
+	tests := []struct {
+		data    string
+		wantErr string
+	}{
+		{`{method: "grpc.health.v1.Health/Check"}`, "target is required"},
+		{`{target: "localhost:9090", method: "Check"}`, "package.Service/Method"},
+		{`{target: "http://localhost:9090", method: "a.B/C"}`, "target must be"},
+		{`{target: "k8s://auth:9090", method: "a.B/C"}`, "k8s://<service>.<namespace>"},
+		{`{target: "localhost:9090", method: "a.B/C", failure_mode: open}`, "failure_mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			var gf GRPCFilter
+			if err := yaml.UnmarshalStrict([]byte(tt.data), &gf); err != nil {
+				t.Fatal(err)
+			}
+			if err := gf.Validate(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// listResolver lists the endpoints of "<service>.<namespace>:<port>"
+type listResolver map[string][]string
+
+func (r listResolver) Resolve(namespace, service, port string) (string, error) {
+	return "", fmt.Errorf("Resolve must not be used when endpoints can be listed")
+}
+
+func (r listResolver) Addresses(namespace, service, port string) ([]string, error) {
+	addresses, ok := r[service+"."+namespace+":"+port]
+	if !ok {
+		return nil, fmt.Errorf("unknown service")
+	}
+	return addresses, nil
+}
+
+func TestGRPCFilterServiceTarget(t *testing.T) {
+	var calls [2]atomic.Int32
+	addresses := make([]string, 0, 2)
+	for i := range calls {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		counter := &calls[i]
+		server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			counter.Add(1)
+			return handler(ctx, req)
+		}))
+		checker := health.NewServer()
+		checker.SetServingStatus("auth", healthpb.HealthCheckResponse_SERVING)
+		healthpb.RegisterHealthServer(server, checker)
+		reflection.Register(server)
+		go server.Serve(listener)
+		t.Cleanup(server.Stop)
+		addresses = append(addresses, listener.Addr().String())
+	}
+	SetServiceResolver(listResolver{"health.auth:9090": addresses})
+	defer SetServiceResolver(nil)
+
+	gf := newGRPCFilter(t, "target: k8s://health.auth:9090\nmethod: grpc.health.v1.Health/Check\nrequest:\n  service: auth\n")
+	t.Cleanup(func() {
+		grpcConns.mu.Lock()
+		defer grpcConns.mu.Unlock()
+		if pc, ok := grpcConns.conns[gf.credsKey+"|"+gf.Target]; ok {
+			pc.conn.Close()
+			delete(grpcConns.conns, gf.credsKey+"|"+gf.Target)
+		}
+	})
+	for i := 0; i < 50 && (calls[0].Load() == 0 || calls[1].Load() == 0); i++ {
+		if ok, err := gf.Process(newFilterContext(`{}`)); !ok || err != nil {
+			t.Fatalf("Process() = %v, %v", ok, err)
+		}
+	}
+	if calls[0].Load() == 0 || calls[1].Load() == 0 {
+		t.Errorf("calls were not balanced over the endpoints: %d and %d", calls[0].Load(), calls[1].Load())
+	}
+
+	// one connection per target, not per endpoint
+	grpcConns.mu.Lock()
+	_, pooled := grpcConns.conns[gf.credsKey+"|k8s://health.auth:9090"]
+	grpcConns.mu.Unlock()
+	if !pooled {
+		t.Errorf("connection is not pooled by its target")
+	}
+}
+
+func TestGRPCConnPoolCloseIdle(t *testing.T) {
+	now := time.Now()
+	pool := &grpcConnPool{conns: make(map[string]*pooledConn), now: func() time.Time { return now }}
+	creds := insecure.NewCredentials()
+
+	idle, err := pool.get("127.0.0.1:1", "insecure", creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, _ := pool.get("127.0.0.1:2", "insecure", creds)
+
+	now = now.Add(grpcIdleTimeout / 2)
+	pool.get("127.0.0.1:2", "insecure", creds)
+	now = now.Add(grpcIdleTimeout / 2)
+	pool.get("127.0.0.1:3", "insecure", creds)
+
+	if idle.GetState() != connectivity.Shutdown {
+		t.Errorf("idle connection was not closed")
+	}
+	if used.GetState() == connectivity.Shutdown || len(pool.conns) != 2 {
+		t.Errorf("used connection was closed, pool has %d connections", len(pool.conns))
+	}
+}
@@ -0,0 +1,101 @@
+package filters
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// grpcResolveInterval is how often the endpoints of a k8s:// gRPC target are read again
+var grpcResolveInterval = 10 * time.Second
+
+// roundRobinConfig spreads the calls of a connection over every endpoint
+const roundRobinConfig = `{"loadBalancingConfig": [{"round_robin": {}}]}`
+
+// serviceResolverBuilder resolves k8s:// and k8s+https:// gRPC targets to
+// every ready endpoint of the service. The connection is kept per target,
+// the round_robin balancer follows the endpoints as pods come and go.
+type serviceResolverBuilder struct {
+	scheme string
+}
+
+func (b serviceResolverBuilder) Scheme() string {
+	return b.scheme
+}
+
+func (b serviceResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	ref, err := ParseServiceRef(target.URL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &k8sResolver{ref: ref, cc: cc, now: make(chan struct{}, 1), cancel: cancel}
+	go r.watch(ctx)
+	return r, nil
+}
+
+// OverrideAuthority uses <service>.<namespace>:<port> as :authority
+func (b serviceResolverBuilder) OverrideAuthority(target resolver.Target) string {
+	return target.URL.Host
+}
+
+type k8sResolver struct {
+	ref    *ServiceRef
+	cc     resolver.ClientConn
+	now    chan struct{}
+	cancel context.CancelFunc
+
+	addresses string // last reported addresses, joined
+}
+
+func (r *k8sResolver) watch(ctx context.Context) {
+	ticker := time.NewTicker(grpcResolveInterval)
+	defer ticker.Stop()
+	for {
+		r.update()
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.now:
+		case <-ticker.C:
+		}
+	}
+}
+
+// update reports the endpoints when they changed
+func (r *k8sResolver) update() {
+	addresses, err := r.ref.Addresses()
+	if err != nil {
+		log.Warningf("gRPC target %s://%s.%s: %v", ServiceScheme, r.ref.Service, r.ref.Namespace, err)
+		r.cc.ReportError(err)
+		r.addresses = ""
+		return
+	}
+	sort.Strings(addresses)
+	if joined := strings.Join(addresses, ","); joined != r.addresses {
+		state := resolver.State{Addresses: make([]resolver.Address, 0, len(addresses))}
+		for _, address := range addresses {
+			state.Addresses = append(state.Addresses, resolver.Address{Addr: address})
+		}
+		if err := r.cc.UpdateState(state); err != nil {
+			log.Warningf("gRPC target %s://%s.%s: %v", ServiceScheme, r.ref.Service, r.ref.Namespace, err)
+		}
+		r.addresses = joined
+	}
+}
+
+// ResolveNow reads the endpoints again, e.g. after a connection failed
+func (r *k8sResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.now <- struct{}{}:
+	default:
+	}
+}
+
+func (r *k8sResolver) Close() {
+	r.cancel()
+}
@@ -0,0 +1,124 @@
+package filters
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	Register("ip", func(spec *Spec) (Filter, error) {
+		ipf := &IPFilter{}
+		if err := spec.Decode(ipf); err != nil {
+			return nil, err
+		}
+		return ipf, nil
+	})
+}
+
+// IPFilter allows or denies the client IP by address ranges. A deny match
+// always rejects, a non-empty allow list rejects every address outside it.
+// The client IP honours X-Forwarded-For only from the trusted proxies of the
+// configuration (client_ip.trusted_proxies).
+type IPFilter struct {
+	FilterName string `yaml:"filter_name"`
+	// Allow and Deny hold CIDRs such as 10.0.0.0/8 or single addresses
+	Allow   []string `yaml:"allow,omitempty"`
+	Deny    []string `yaml:"deny,omitempty"`
+	Message string   `yaml:"message,omitempty"`
+
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// Validate parses the address ranges
+func (ipf *IPFilter) Validate() error {
+	if len(ipf.Allow) == 0 && len(ipf.Deny) == 0 {
+		return fmt.Errorf("allow or deny is required")
+	}
+	allow, err := ParseCIDRs(ipf.Allow)
+	if err != nil {
+		return fmt.Errorf("allow: %v", err)
+	}
+	deny, err := ParseCIDRs(ipf.Deny)
+	if err != nil {
+		return fmt.Errorf("deny: %v", err)
+	}
+	ipf.allow, ipf.deny = allow, deny
+	return nil
+}
+
+// Process checks the client IP against the deny and allow lists
+func (ipf *IPFilter) Process(c *gin.Context) (bool, error) {
+	log.Infof("start ip filter: %s", ipf.FilterName)
+	if ipf.allow == nil && ipf.deny == nil {
+		if err := ipf.Validate(); err != nil {
+			return false, err
+		}
+	}
+
+	message := ipf.Message
+	if message == "" {
+		message = "client address is not allowed"
+	}
+	rejected := &FilterError{Status: http.StatusForbidden, Message: message}
+
+	clientIP := c.ClientIP()
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		log.Warningf("IP filter %s: unparsable client IP %q", ipf.FilterName, clientIP)
+		return false, rejected
+	}
+	addr = addr.Unmap()
+
+	if prefix, ok := matchPrefix(ipf.deny, addr); ok {
+		log.Warningf("IP filter %s: %s is denied by %s", ipf.FilterName, addr, prefix)
+		return false, rejected
+	}
+	if len(ipf.allow) > 0 {
+		if _, ok := matchPrefix(ipf.allow, addr); !ok {
+			log.Warningf("IP filter %s: %s is not in the allowed ranges", ipf.FilterName, addr)
+			return false, rejected
+		}
+	}
+	return true, nil
+}
+
+func matchPrefix(prefixes []netip.Prefix, addr netip.Addr) (netip.Prefix, bool) {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return prefix, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// ParseCIDRs parses CIDRs and single addresses, which become /32 or /128.
+// IPv4-mapped IPv6 addresses are treated as IPv4.
+func ParseCIDRs(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", value)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", value)
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,86 @@
+package filters
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIPFilter(t *testing.T) {
+	ipf := &IPFilter{
+		FilterName: "corporate",
+		Allow:      []string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.7"},
+		Deny:       []string{"10.66.0.0/16"},
+	}
+	if err := ipf.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{"10.1.2.3:4000", true},
+		{"[::ffff:10.1.2.3]:4000", true},
+		{"[2001:db8::1]:4000", true},
+		{"192.0.2.7:4000", true},
+		{"192.0.2.8:4000", false},
+		{"10.66.1.1:4000", false},
+		{"203.0.113.5:4000", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/signup", nil)
+			req.RemoteAddr = tt.remoteAddr
+			ok, err := ipf.Process(newTestContext(req))
+			if ok != tt.want {
+				t.Fatalf("Process() = %v, %v, want %v", ok, err, tt.want)
+			}
+			var fe *FilterError
+			if !ok && (!errors.As(err, &fe) || fe.Status != http.StatusForbidden) {
+				t.Errorf("Process() error = %v, want 403", err)
+			}
+		})
+	}
+}
+
+func TestIPFilterTrustedProxies(t *testing.T) {
+	ipf := &IPFilter{Allow: []string{"10.0.0.0/8"}}
+	if err := ipf.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		trusted []string
+		want    bool
+	}{
+		{nil, false},
+		{[]string{"203.0.113.0/24"}, true},
+	} {
+		c, engine := gin.CreateTestContext(httptest.NewRecorder())
+		if err := engine.SetTrustedProxies(tt.trusted); err != nil {
+			t.Fatalf("SetTrustedProxies() error = %v", err)
+		}
+		c.Request = httptest.NewRequest(http.MethodPost, "/signup", nil)
+		c.Request.RemoteAddr = "203.0.113.5:4000"
+		c.Request.Header.Set("X-Forwarded-For", "10.1.2.3")
+		if ok, _ := ipf.Process(c); ok != tt.want {
+			t.Errorf("Process() with trusted proxies %v = %v, want %v", tt.trusted, ok, tt.want)
+		}
+	}
+}
+
+func TestIPFilterValidate(t *testing.T) {
+	for _, ipf := range []IPFilter{
+		{},
+		{Allow: []string{"10.0.0.0/33"}},
+		{Deny: []string{"not-an-ip"}},
+	} {
+		if err := ipf.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded, want error", ipf)
+		}
+	}
+}
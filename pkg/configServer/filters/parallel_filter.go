@@ -0,0 +1,137 @@
+package filters
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	Register("parallel", func(spec *Spec) (Filter, error) {
+		f := &ParallelFilter{}
+		if err := spec.Decode(f); err != nil {
+			return nil, err
+		}
+
+		f.built = make([]Filter, 0, len(f.Filters))
+		for i := range f.Filters {
+			f.Filters[i].Namespace, f.Filters[i].Route = spec.Namespace, spec.Route
+			filter, err := Build(&f.Filters[i])
+			if err != nil {
+				return nil, fmt.Errorf("filters[%d]: %v", i, err)
+			}
+			if replacesBody(filter) {
+				return nil, fmt.Errorf("filters[%d]: %s filters replace the request body and cannot run in parallel", i, f.Filters[i].Type)
+			}
+			f.built = append(f.built, filter)
+		}
+		return f, nil
+	})
+}
+
+// ParallelFilter runs independent filters at the same time and passes when
+// all of them pass. On failure the error of the first failing filter, in
+// list order, is returned. The filters share the request: headers and claims
+// set by policy obligations apply after the group passed, filters replacing
+// the body, such as schema, are refused.
+type ParallelFilter struct {
+	FilterName string `yaml:"filter_name"`
+	Filters    []Spec `yaml:"filters"`
+
+	built []Filter
+}
+
+func (f *ParallelFilter) Validate() error {
+	if len(f.Filters) == 0 {
+		return fmt.Errorf("filters is empty")
+	}
+	return nil
+}
+
+func (f *ParallelFilter) Process(c *gin.Context) (bool, error) {
+	log.Infof("start parallel filter: %s", f.FilterName)
+	outermost := prepareConcurrent(c)
+
+	type result struct {
+		ok  bool
+		err error
+	}
+	results := make([]result, len(f.built))
+
+	var wg sync.WaitGroup
+	for i, filter := range f.built {
+		wg.Add(1)
+		go func(i int, filter Filter) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					results[i] = result{err: fmt.Errorf("filter panicked: %v", r)}
+				}
+			}()
+			ok, err := filter.Process(c)
+			results[i] = result{ok, err}
+		}(i, filter)
+	}
+	wg.Wait()
+
+	for i, r := range results {
+		if r.err == nil && r.ok {
+			continue
+		}
+		// the chain stops here, After will not settle what the others reserved
+		f.release(c)
+		if outermost {
+			finishConcurrent(c, false)
+		}
+		if r.err != nil {
+			return false, r.err
+		}
+		return false, fmt.Errorf("filter %q not passed", f.Filters[i].Name)
+	}
+	// header and claim obligations of the group, e.g. of policy filters
+	if outermost {
+		finishConcurrent(c, true)
+	}
+	return true, nil
+}
+
+// After forwards to the observing filters of the group, e.g. brute_force
+func (f *ParallelFilter) After(c *gin.Context) {
+	for _, filter := range f.built {
+		if observer, ok := filter.(ChainObserver); ok {
+			observer.After(c)
+		}
+	}
+}
+
+// releaser is implemented by filters holding state until After, e.g. the
+// attempt reserved by brute_force. release drops it when the request is
+// rejected by another filter of the group.
+type releaser interface {
+	release(c *gin.Context)
+}
+
+func (f *ParallelFilter) release(c *gin.Context) {
+	for _, filter := range f.built {
+		if cf, ok := filter.(*conditionalFilter); ok {
+			filter = cf.filter
+		}
+		if r, ok := filter.(releaser); ok {
+			r.release(c)
+		}
+	}
+}
+
+// bodyReplacer is implemented by filters that replace c.Request.Body
+type bodyReplacer interface {
+	replacesBody()
+}
+
+func replacesBody(filter Filter) bool {
+	if cf, ok := filter.(*conditionalFilter); ok {
+		filter = cf.filter
+	}
+	_, ok := filter.(bodyReplacer)
+	return ok
+}
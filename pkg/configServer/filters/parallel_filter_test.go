@@ -0,0 +1,219 @@
+package filters
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func buildSpecs(t *testing.T, data string) []Filter {
+	var specs []Spec
+	if err := yaml.Unmarshal([]byte(data), &specs); err != nil {
+		t.Fatalf("Failed to unmarshal specs: %v", err)
+	}
+	built := make([]Filter, 0, len(specs))
+	for i := range specs {
+		filter, err := Build(&specs[i])
+		if err != nil {
+			t.Fatalf("Build() error = %v", err)
+		}
+		built = append(built, filter)
+	}
+	return built
+}
+
+func TestParallelFilterAndWhen(t *testing.T) {
+	var otpCalls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/alice":
+			time.Sleep(100 * time.Millisecond)
+			w.Write([]byte(`{"mfa_enabled": true}`))
+		case "/users/bob":
+			time.Sleep(100 * time.Millisecond)
+			w.Write([]byte(`{"mfa_enabled": false}`))
+		case "/devices":
+			time.Sleep(100 * time.Millisecond)
+			w.Write([]byte(`{"trusted": true}`))
+		case "/otp":
+			otpCalls.Add(1)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	built := buildSpecs(t, `
+- type: parallel
+  filter_name: lookups
+  filters:
+    - type: request
+      filter_name: user-lookup
+      method: GET
+      remote_server: "`+server.URL+`/users/${body.username}"
+    - type: request
+      filter_name: device-lookup
+      method: GET
+      remote_server: "`+server.URL+`/devices"
+- type: request
+  filter_name: otp
+  remote_server: "`+server.URL+`/otp"
+  fields_to_send: [username, otp]
+  when:
+    - field: filter
+      key: user-lookup.mfa_enabled
+      operator: equals
+      value: "true"
+`)
+
+	for _, tt := range []struct {
+		username string
+		wantOTP  int32
+	}{{"alice", 1}, {"bob", 0}} {
+		otpCalls.Store(0)
+		c := newFilterContext(`{"username": "` + tt.username + `", "otp": "123456"}`)
+
+		start := time.Now()
+		for _, filter := range built {
+			if ok, err := filter.Process(c); !ok || err != nil {
+				t.Fatalf("%s: Process() = %v, %v", tt.username, ok, err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed > 180*time.Millisecond {
+			t.Errorf("%s: lookups did not run in parallel, took %v", tt.username, elapsed)
+		}
+		if otpCalls.Load() != tt.wantOTP {
+			t.Errorf("%s: expected %d otp calls, got %d", tt.username, tt.wantOTP, otpCalls.Load())
+		}
+		if Collected(c)["device-lookup"] == nil {
+			t.Errorf("%s: parallel filter output was not collected", tt.username)
+		}
+	}
+}
+
+func TestParallelFilterFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/deny" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	built := buildSpecs(t, `
+- type: parallel
+  filters:
+    - {type: request, filter_name: a, method: GET, remote_server: "`+server.URL+`/ok"}
+    - {type: request, filter_name: b, method: GET, remote_server: "`+server.URL+`/deny"}
+`)
+	if ok, err := built[0].Process(newFilterContext(`{}`)); ok || err == nil || !strings.Contains(err.Error(), "non-200") {
+		t.Errorf("Process() = %v, %v, want failure of b", ok, err)
+	}
+
+	var spec Spec
+	yaml.Unmarshal([]byte(`{type: parallel, filters: [{type: cel, expression: "1"}]}`), &spec)
+	if _, err := Build(&spec); err == nil || !strings.Contains(err.Error(), "filters[0]") {
+		t.Errorf("Build() error = %v, want nested filter error", err)
+	}
+
+	yaml.Unmarshal([]byte(`{type: cel, expression: "true", when: [{field: filter, operator: exists}]}`), &spec)
+	if _, err := Build(&spec); err == nil || !strings.Contains(err.Error(), "when") {
+		t.Errorf("Build() error = %v, want when error", err)
+	}
+}
+
+func TestParallelFilterObserversAndBody(t *testing.T) {
+	built := buildSpecs(t, `
+- type: parallel
+  filters:
+    - type: brute_force
+      filter_name: parallel-guard
+      username: {field: body, key: username}
+      per_username: {lockout_after: 1, lockout: 1m}
+    - {type: cel, expression: "true"}
+`)
+	attempt := func() error {
+		c := newFilterContext(`{"username": "parallel-test"}`)
+		if ok, err := built[0].Process(c); !ok {
+			return err
+		}
+		c.Status(http.StatusUnauthorized)
+		c.Writer.WriteHeaderNow()
+		built[0].(ChainObserver).After(c)
+		return nil
+	}
+	defer ClearLockout(LockoutUsername, "parallel-test")
+
+	attempt()
+	if err := attempt(); err == nil {
+		t.Errorf("the failure seen by After of the group was not counted")
+	}
+
+	var spec Spec
+	yaml.Unmarshal([]byte(`{type: parallel, filters: [{type: schema, schema: {type: object}}]}`), &spec)
+	if _, err := Build(&spec); err == nil || !strings.Contains(err.Error(), "request body") {
+		t.Errorf("Build() error = %v, want schema refused in parallel", err)
+	}
+}
+
+// staticPolicyEngine returns the same decision for every request
+type staticPolicyEngine struct{ decision interface{} }
+
+func (e staticPolicyEngine) Eval(context.Context, interface{}) (interface{}, error) {
+	return e.decision, nil
+}
+
+// run with -race: the policy obligations must not change the request while
+// the request filter of the group renders it
+func TestParallelFilterObligations(t *testing.T) {
+	defer func(old func(string, map[string]string, map[string]interface{}) (PolicyEngine, error)) {
+		newPolicyEngine = old
+	}(newPolicyEngine)
+	newPolicyEngine = func(string, map[string]string, map[string]interface{}) (PolicyEngine, error) {
+		return staticPolicyEngine{map[string]interface{}{
+			"allow":   true,
+			"headers": map[string]interface{}{"X-Tier": "gold"},
+			"claims":  map[string]interface{}{"tier": "gold"},
+		}}, nil
+	}
+
+	var seen atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen.Store(r.Header.Get("X-Seen-Tier"))
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	built := buildSpecs(t, `
+- type: parallel
+  filters:
+    - {type: policy, filter_name: authz, query: data.x, modules: {x.rego: "package x"}}
+    - type: request
+      filter_name: lookup
+      method: GET
+      remote_server: "`+server.URL+`/lookup"
+      headers: {X-Seen-Tier: "${headers.x-tier}"}
+`)
+	for i := 0; i < 20; i++ {
+		c := newFilterContext(`{}`)
+		c.Request.Header.Set("X-Tier", "none")
+		if ok, err := built[0].Process(c); !ok || err != nil {
+			t.Fatalf("Process() = %v, %v", ok, err)
+		}
+		if got := c.Request.Header.Get("X-Tier"); got != "gold" {
+			t.Fatalf("header obligation = %q after the group, want gold", got)
+		}
+		if claims, _ := Claims(c); claims["tier"] != "gold" {
+			t.Fatalf("claim obligation was not applied: %v", claims)
+		}
+		if got := seen.Load(); got != "none" {
+			t.Fatalf("request filter saw the obligation %q inside the group", got)
+		}
+	}
+}
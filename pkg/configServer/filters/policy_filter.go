@@ -0,0 +1,348 @@
+package filters
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	Register("policy", func(spec *Spec) (Filter, error) {
+		f := &PolicyFilter{}
+		if err := spec.Decode(f); err != nil {
+			return nil, err
+		}
+		f.namespace = spec.Namespace
+		return f, nil
+	})
+}
+
+// PolicyFilter evaluates Rego policies in-process against RequestInput.
+// The value of query is the decision, either a bool or an object:
+//
+//	allow    bool                 required
+//	reason   string               returned to the client on deny
+//	headers  map(string, string)  set on the request for the next filters and the handler
+//	claims   map(string, any)     added to the claims seen by the next filters
+//
+// Inside a parallel group the headers and claims are applied once the whole
+// group passed, the other filters of the group do not see them.
+//
+// The whole decision is also stored as the filter's collected data.
+//
+// The ConfigMap is read again every refresh_interval (1m by default) and the
+// policies are recompiled when it changed. AuthRoutes may only load
+// ConfigMaps of their own namespace and of -secret-namespaces.
+type PolicyFilter struct {
+	FilterName string `yaml:"filter_name"`
+	// Query selects the decision, e.g. data.openauth.authz.decision
+	Query string `yaml:"query"`
+	// Modules maps module file names to Rego source
+	Modules map[string]string `yaml:"modules,omitempty"`
+	// Data is the base data document of the policies
+	Data map[string]interface{} `yaml:"data,omitempty"`
+	// ConfigMap adds the *.rego keys as modules and merges the *.json keys into data
+	ConfigMap       *ConfigMapRef `yaml:"configmap,omitempty"`
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+
+	// namespace of the AuthRoute of the filter, see Spec.Namespace
+	namespace string
+	now       func() time.Time
+
+	mu         sync.Mutex
+	engine     PolicyEngine
+	cmData     map[string]string
+	fetched    time.Time
+	refreshing bool
+}
+
+// ConfigMapRef names a ConfigMap. The namespace may be left out in
+// AuthRoutes, it defaults to the namespace of the AuthRoute.
+type ConfigMapRef struct {
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+}
+
+// PolicyEngine evaluates the compiled policies of a policy filter and returns
+// the value of the query, nil when it is undefined
+type PolicyEngine interface {
+	Eval(ctx context.Context, input interface{}) (interface{}, error)
+}
+
+// newPolicyEngine compiles the policies of a filter, with the embedded OPA
+// of policy_rego.go unless a test replaces it
+var newPolicyEngine = newRegoEngine
+
+// ConfigMapReader reads the data of a ConfigMap
+type ConfigMapReader interface {
+	ConfigMapData(namespace, name string) (map[string]string, error)
+}
+
+var (
+	configMapMu     sync.RWMutex
+	configMapReader ConfigMapReader
+)
+
+// SetConfigMapReader sets the reader used by policy filters that load a ConfigMap
+func SetConfigMapReader(r ConfigMapReader) {
+	configMapMu.Lock()
+	defer configMapMu.Unlock()
+	configMapReader = r
+}
+
+func getConfigMapReader() ConfigMapReader {
+	configMapMu.RLock()
+	defer configMapMu.RUnlock()
+	return configMapReader
+}
+
+// Validate loads and compiles the policies, so errors reject the configuration
+func (f *PolicyFilter) Validate() error {
+	if f.Query == "" {
+		return fmt.Errorf("query is required")
+	}
+	if f.RefreshInterval < 0 {
+		return fmt.Errorf("refresh_interval must not be negative")
+	}
+	if f.now == nil {
+		f.now = time.Now
+	}
+
+	var cmData map[string]string
+	if f.ConfigMap != nil {
+		if f.ConfigMap.Namespace == "" {
+			f.ConfigMap.Namespace = f.namespace
+		}
+		if f.ConfigMap.Namespace == "" || f.ConfigMap.Name == "" {
+			return fmt.Errorf("configmap namespace and name are required")
+		}
+		if !secretAllowed(f.namespace, f.ConfigMap.Namespace) {
+			return fmt.Errorf("configmap %s/%s is outside namespace %s and -secret-namespaces", f.ConfigMap.Namespace, f.ConfigMap.Name, f.namespace)
+		}
+		var err error
+		if cmData, err = f.readConfigMap(); err != nil {
+			return err
+		}
+	}
+
+	engine, err := f.compile(cmData)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.engine, f.cmData, f.fetched = engine, cmData, f.now()
+	return nil
+}
+
+// compile builds the engine from the inline modules and data and the ConfigMap
+func (f *PolicyFilter) compile(cmData map[string]string) (PolicyEngine, error) {
+	modules := make(map[string]string, len(f.Modules))
+	for name, src := range f.Modules {
+		modules[name] = src
+	}
+	data, err := toJSONObject(f.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data: %v", err)
+	}
+
+	keys := make([]string, 0, len(cmData))
+	for key := range cmData {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch {
+		case strings.HasSuffix(key, ".rego"):
+			modules[key] = cmData[key]
+		case strings.HasSuffix(key, ".json"):
+			var doc map[string]interface{}
+			if err := json.Unmarshal([]byte(cmData[key]), &doc); err != nil {
+				return nil, fmt.Errorf("configmap %s/%s key %s: %v", f.ConfigMap.Namespace, f.ConfigMap.Name, key, err)
+			}
+			for k, v := range doc {
+				data[k] = v
+			}
+		}
+	}
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("at least one module is required")
+	}
+
+	engine, err := newPolicyEngine(f.Query, modules, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy: %v", err)
+	}
+	return engine, nil
+}
+
+func (f *PolicyFilter) readConfigMap() (map[string]string, error) {
+	reader := getConfigMapReader()
+	if reader == nil {
+		return nil, fmt.Errorf("configmap %s/%s: no kubernetes client configured", f.ConfigMap.Namespace, f.ConfigMap.Name)
+	}
+	return reader.ConfigMapData(f.ConfigMap.Namespace, f.ConfigMap.Name)
+}
+
+// currentEngine returns the compiled policies. Once refresh_interval passed
+// the request reads the ConfigMap again outside the lock, the others keep
+// using the current policies meanwhile. If the ConfigMap cannot be read or
+// compiled, the current policies stay.
+func (f *PolicyFilter) currentEngine() PolicyEngine {
+	interval := f.RefreshInterval
+	if interval == 0 {
+		interval = time.Minute
+	}
+
+	f.mu.Lock()
+	engine := f.engine
+	if f.ConfigMap == nil || f.refreshing || f.now().Sub(f.fetched) < interval {
+		f.mu.Unlock()
+		return engine
+	}
+	f.refreshing = true
+	cmData := f.cmData
+	f.mu.Unlock()
+
+	data, err := f.readConfigMap()
+	if err == nil && !reflect.DeepEqual(data, cmData) {
+		var updated PolicyEngine
+		if updated, err = f.compile(data); err == nil {
+			log.Infof("Reloaded policies of %s from configmap %s/%s", f.FilterName, f.ConfigMap.Namespace, f.ConfigMap.Name)
+			engine, cmData = updated, data
+		}
+	}
+	if err != nil {
+		log.Warningf("Keeping the policies of %s: %v", f.FilterName, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.engine, f.cmData, f.fetched, f.refreshing = engine, cmData, f.now(), false
+	return engine
+}
+
+func (f *PolicyFilter) Process(c *gin.Context) (bool, error) {
+	log.Infof("start policy filter: %s", f.FilterName)
+	if f.now == nil {
+		if err := f.Validate(); err != nil {
+			return false, err
+		}
+	}
+
+	result, err := f.currentEngine().Eval(c.Request.Context(), RequestInput(c))
+	if err != nil {
+		return false, fmt.Errorf("policy evaluation failed: %v", err)
+	}
+
+	decision, err := parseDecision(result)
+	if err != nil {
+		return false, err
+	}
+	if !decision.Allow {
+		if decision.Reason != "" {
+			return false, fmt.Errorf("%s", decision.Reason)
+		}
+		return false, fmt.Errorf("denied by policy")
+	}
+
+	addObligations(c, decision.Headers, decision.Claims)
+	if f.FilterName != "" {
+		SetCollected(c, f.FilterName, result)
+	}
+	return true, nil
+}
+
+type policyDecision struct {
+	Allow   bool
+	Reason  string
+	Headers map[string]string
+	Claims  map[string]interface{}
+}
+
+func parseDecision(result interface{}) (policyDecision, error) {
+	switch v := result.(type) {
+	case nil:
+		return policyDecision{Reason: "policy decision is undefined"}, nil
+	case bool:
+		return policyDecision{Allow: v}, nil
+	case map[string]interface{}:
+		var d policyDecision
+		allow, ok := v["allow"].(bool)
+		if !ok {
+			return d, fmt.Errorf("policy decision has no boolean allow")
+		}
+		d.Allow = allow
+		d.Reason, _ = v["reason"].(string)
+
+		if headers, ok := v["headers"].(map[string]interface{}); ok {
+			d.Headers = make(map[string]string, len(headers))
+			for name, value := range headers {
+				s, ok := value.(string)
+				if !ok {
+					return d, fmt.Errorf("policy header %s must be a string", name)
+				}
+				d.Headers[name] = s
+			}
+		}
+		d.Claims, _ = v["claims"].(map[string]interface{})
+		return d, nil
+	default:
+		return policyDecision{}, fmt.Errorf("policy decision must be a bool or an object, got %T", result)
+	}
+}
+
+// toJSONObject converts a YAML document into JSON types, nested YAML maps
+// are decoded with interface{} keys
+func toJSONObject(in map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(in))
+	for k, v := range in {
+		converted, err := toJSONValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", k, err)
+		}
+		out[k] = converted
+	}
+	return out, nil
+}
+
+func toJSONValue(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string", k)
+			}
+			converted, err := toJSONValue(item)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = converted
+		}
+		return out, nil
+	case map[string]interface{}:
+		return toJSONObject(t)
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, item := range t {
+			converted, err := toJSONValue(item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = converted
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
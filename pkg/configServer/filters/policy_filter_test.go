@@ -0,0 +1,187 @@
+package filters
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// fakePolicyEngine allows admins and passes obligations back
+type fakePolicyEngine struct {
+	modules map[string]string
+	data    map[string]interface{}
+}
+
+func (e *fakePolicyEngine) Eval(ctx context.Context, input interface{}) (interface{}, error) {
+	claims := input.(map[string]interface{})["claims"].(map[string]interface{})
+	if claims["role"] != "admin" {
+		return map[string]interface{}{"allow": false, "reason": "admins only"}, nil
+	}
+	return map[string]interface{}{
+		"allow":   true,
+		"headers": map[string]interface{}{"X-User-Tier": e.data["tier"]},
+		"claims":  map[string]interface{}{"scope": "config:write"},
+	}, nil
+}
+
+type fakeConfigMapReader map[string]string
+
+func (r fakeConfigMapReader) ConfigMapData(namespace, name string) (map[string]string, error) {
+	if name != "policies" {
+		return nil, fmt.Errorf("configmap %s/%s not found", namespace, name)
+	}
+	data := make(map[string]string, len(r))
+	for k, v := range r {
+		data[k] = v
+	}
+	return data, nil
+}
+
+func TestPolicyFilter(t *testing.T) {
+	var engine *fakePolicyEngine
+	defer func(old func(string, map[string]string, map[string]interface{}) (PolicyEngine, error)) {
+		newPolicyEngine = old
+	}(newPolicyEngine)
+	newPolicyEngine = func(query string, modules map[string]string, data map[string]interface{}) (PolicyEngine, error) {
+		engine = &fakePolicyEngine{modules: modules, data: data}
+		return engine, nil
+	}
+
+	SetConfigMapReader(fakeConfigMapReader{
+		"authz.rego": "package openauth.authz",
+		"tiers.json": `{"tier": "gold"}`,
+	})
+	defer SetConfigMapReader(nil)
+
+	var spec Spec
+	data := `
+type: policy
+filter_name: authz
+query: data.openauth.authz.decision
+modules:
+  inline.rego: "package openauth.inline"
+data:
+  limits: {daily: 10}
+configmap:
+  namespace: security
+  name: policies
+`
+	if err := yaml.Unmarshal([]byte(data), &spec); err != nil {
+		t.Fatalf("Failed to unmarshal spec: %v", err)
+	}
+	built, err := Build(&spec)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if len(engine.modules) != 2 || engine.data["tier"] != "gold" {
+		t.Errorf("configmap was not loaded: %+v", engine)
+	}
+	if limits, ok := engine.data["limits"].(map[string]interface{}); !ok || limits["daily"] != 10 {
+		t.Errorf("inline data was not converted: %#v", engine.data["limits"])
+	}
+
+	for _, role := range []string{"admin", "user"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer t")
+		c := newTestContext(req)
+		c.Set(tokenValidatorKey, TokenValidator(func(string) (map[string]interface{}, error) {
+			return map[string]interface{}{"role": role}, nil
+		}))
+
+		ok, err := built.Process(c)
+		if role == "user" {
+			if ok || err == nil || err.Error() != "admins only" {
+				t.Errorf("Process() = %v, %v, want deny with reason", ok, err)
+			}
+			continue
+		}
+
+		if !ok || err != nil {
+			t.Fatalf("Process() = %v, %v, want allow", ok, err)
+		}
+		if got := c.Request.Header.Get("X-User-Tier"); got != "gold" {
+			t.Errorf("header obligation not applied: %q", got)
+		}
+		if claims, _ := Claims(c); claims["scope"] != "config:write" || claims["role"] != "admin" {
+			t.Errorf("claim obligation not applied: %v", claims)
+		}
+		if _, ok := Collected(c)["authz"]; !ok {
+			t.Error("decision was not collected")
+		}
+	}
+}
+
+func TestPolicyFilterConfigMap(t *testing.T) {
+	var engine *fakePolicyEngine
+	defer func(old func(string, map[string]string, map[string]interface{}) (PolicyEngine, error)) {
+		newPolicyEngine = old
+	}(newPolicyEngine)
+	newPolicyEngine = func(query string, modules map[string]string, data map[string]interface{}) (PolicyEngine, error) {
+		engine = &fakePolicyEngine{modules: modules, data: data}
+		return engine, nil
+	}
+
+	reader := fakeConfigMapReader{"authz.rego": "package openauth.authz", "tiers.json": `{"tier": "gold"}`}
+	SetConfigMapReader(reader)
+	defer SetConfigMapReader(nil)
+
+	// AuthRoutes only load ConfigMaps of their own namespace
+	f := &PolicyFilter{Query: "data.x", ConfigMap: &ConfigMapRef{Namespace: "security", Name: "policies"}, namespace: "team-a"}
+	if err := f.Validate(); err == nil || !strings.Contains(err.Error(), "outside namespace team-a") {
+		t.Errorf("Validate() error = %v, want a namespace error", err)
+	}
+	f = &PolicyFilter{Query: "data.x", ConfigMap: &ConfigMapRef{Name: "policies"}, namespace: "team-a"}
+	if err := f.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if f.ConfigMap.Namespace != "team-a" {
+		t.Errorf("configmap namespace = %q, want the namespace of the AuthRoute", f.ConfigMap.Namespace)
+	}
+
+	now := time.Now()
+	f.now = func() time.Time { return now }
+	loaded := engine
+
+	// unchanged within refresh_interval
+	reader["tiers.json"] = `{"tier": "silver"}`
+	if f.currentEngine() != loaded {
+		t.Errorf("policies were reloaded before refresh_interval")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if got := f.currentEngine(); got == loaded || got.(*fakePolicyEngine).data["tier"] != "silver" {
+		t.Errorf("policies were not reloaded after the configmap changed")
+	}
+
+	// a broken configmap keeps the last policies
+	reloaded := engine
+	reader["tiers.json"] = `{`
+	now = now.Add(2 * time.Minute)
+	if f.currentEngine() != reloaded {
+		t.Errorf("broken configmap replaced the policies")
+	}
+}
+
+func TestPolicyFilterValidate(t *testing.T) {
+	f := &PolicyFilter{Query: "data.x", Modules: map[string]string{"x.rego": "package x"}}
+
+	if err := (&PolicyFilter{Modules: f.Modules}).Validate(); err == nil || !strings.Contains(err.Error(), "query is required") {
+		t.Errorf("Validate() error = %v, want missing query", err)
+	}
+	if err := (&PolicyFilter{Query: "data.x"}).Validate(); err == nil || !strings.Contains(err.Error(), "module") {
+		t.Errorf("Validate() error = %v, want missing module", err)
+	}
+
+	if _, err := parseDecision("yes"); err == nil {
+		t.Error("expected error for a string decision")
+	}
+	if d, err := parseDecision(nil); err != nil || d.Allow {
+		t.Errorf("undefined decision must deny: %+v %v", d, err)
+	}
+}
@@ -0,0 +1,43 @@
+package filters
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/storage/inmem"
+)
+
+type regoEngine struct {
+	query rego.PreparedEvalQuery
+}
+
+func newRegoEngine(query string, modules map[string]string, data map[string]interface{}) (PolicyEngine, error) {
+	options := []func(*rego.Rego){
+		rego.Query(query),
+		rego.Store(inmem.NewFromObject(data)),
+	}
+	for name, src := range modules {
+		options = append(options, rego.Module(name, src))
+	}
+
+	prepared, err := rego.New(options...).PrepareForEval(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &regoEngine{query: prepared}, nil
+}
+
+func (e *regoEngine) Eval(ctx context.Context, input interface{}) (interface{}, error) {
+	results, err := e.query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return nil, nil
+	}
+	if len(results) > 1 {
+		return nil, fmt.Errorf("query returned %d results", len(results))
+	}
+	return results[0].Expressions[0].Value, nil
+}
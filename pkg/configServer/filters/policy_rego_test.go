@@ -0,0 +1,79 @@
+package filters
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestPolicyFilterRego(t *testing.T) {
+	var f PolicyFilter
+	err := yaml.UnmarshalStrict([]byte(`
+filter_name: authz
+query: data.openauth.authz.decision
+modules:
+  authz.rego: |
+    package openauth.authz
+
+    default decision := {"allow": false, "reason": "admins only"}
+
+    decision := {
+      "allow": true,
+      "headers": {"X-User-Tier": data.tiers[input.body.username]},
+    } if {
+      input.body.username in data.admins
+    }
+data:
+  admins: ["alice"]
+  tiers:
+    alice: gold
+`), &f)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal filter: %v", err)
+	}
+	if err := f.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	c := newFilterContext(`{"username": "alice"}`)
+	if ok, err := f.Process(c); !ok || err != nil {
+		t.Fatalf("Process() = %v, %v, want allow", ok, err)
+	}
+	if got := c.Request.Header.Get("X-User-Tier"); got != "gold" {
+		t.Errorf("X-User-Tier = %q, want gold", got)
+	}
+
+	if ok, err := f.Process(newFilterContext(`{"username": "mallory"}`)); ok || err == nil || err.Error() != "admins only" {
+		t.Errorf("Process() = %v, %v, want deny with admins only", ok, err)
+	}
+
+	f.Modules = map[string]string{"authz.rego": "package openauth.authz\n\ndecision := {"}
+	if err := f.Validate(); err == nil || !strings.Contains(err.Error(), "invalid policy") {
+		t.Errorf("Validate() error = %v, want a rego parse error", err)
+	}
+}
+
+// the example configuration compiles with the embedded OPA
+func TestPolicyExampleConfig(t *testing.T) {
+	data, err := os.ReadFile("../../../configs/dyn-config/policy.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read example: %v", err)
+	}
+	var config struct {
+		Routes []struct {
+			Filters []Spec `yaml:"filters"`
+		} `yaml:"routes"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		t.Fatalf("Failed to parse example: %v", err)
+	}
+	for _, route := range config.Routes {
+		for i := range route.Filters {
+			if _, err := Build(&route.Filters[i]); err != nil {
+				t.Errorf("Build(%s) error = %v", route.Filters[i].Name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package filters
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+)
+
+func init() {
+	Register("rate_limit", func(spec *Spec) (Filter, error) {
+		rl := &RateLimitFilter{route: spec.Route}
+		if err := spec.Decode(rl); err != nil {
+			return nil, err
+		}
+		return rl, nil
+	})
+}
+
+// RateLimitFilter rejects requests over limit per period with 429 and
+// Retry-After. Requests are counted per key, built from the key fields.
+type RateLimitFilter struct {
+	FilterName string `yaml:"filter_name"`
+	// Algorithm is token_bucket (default) or sliding_window
+	Algorithm string        `yaml:"algorithm,omitempty"`
+	Limit     int           `yaml:"limit"`
+	Period    time.Duration `yaml:"period"`
+	// Burst is the bucket size of token_bucket, limit by default
+	Burst int `yaml:"burst,omitempty"`
+	// Key selects the request values counted together, the client IP by
+	// default. A missing value counts as empty.
+	Key []KeyField `yaml:"key,omitempty"`
+	// MaxKeys bounds the tracked keys, 100000 by default
+	MaxKeys int    `yaml:"max_keys,omitempty"`
+	Message string `yaml:"message,omitempty"`
+
+	route   string
+	limiter *rateLimiter
+}
+
+// KeyField selects a request value like the field and key of a Condition,
+// e.g. {field: body, key: username} or {field: client_ip}
+type KeyField struct {
+	Field string `yaml:"field"`
+	Key   string `yaml:"key,omitempty"`
+}
+
+func (k KeyField) String() string {
+	if k.Key == "" {
+		return k.Field
+	}
+	return k.Field + ":" + k.Key
+}
+
+// Validate checks the limit and key fields
+func (rl *RateLimitFilter) Validate() error {
+	switch rl.Algorithm {
+	case "", "token_bucket", "sliding_window":
+	default:
+		return fmt.Errorf("algorithm must be token_bucket or sliding_window, got %q", rl.Algorithm)
+	}
+	if rl.Limit <= 0 || rl.Period <= 0 {
+		return fmt.Errorf("limit and period must be positive")
+	}
+	if rl.Burst < 0 || rl.MaxKeys < 0 {
+		return fmt.Errorf("burst and max_keys must not be negative")
+	}
+	if rl.Burst > 0 && rl.Algorithm == "sliding_window" {
+		return fmt.Errorf("burst only applies to token_bucket")
+	}
+	for i, k := range rl.Key {
+		if err := validateField(k.Field, k.Key); err != nil {
+			return fmt.Errorf("key[%d]: %v", i, err)
+		}
+	}
+
+	// counters survive a reload of the same filter, so a new configuration
+	// does not reset the limits. Every route counts on its own.
+	settings, err := yaml.Marshal(rl)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(append([]byte(rl.route+"\x00"), settings...))
+	rl.limiter = sharedRateLimiter(hex.EncodeToString(sum[:]), rl.newLimiter)
+	return nil
+}
+
+func (rl *RateLimitFilter) newLimiter() *rateLimiter {
+	limiter := &rateLimiter{
+		slidingWindow: rl.Algorithm == "sliding_window",
+		limit:         float64(rl.Limit),
+		burst:         float64(rl.Burst),
+		period:        rl.Period,
+		maxKeys:       rl.MaxKeys,
+		states:        make(map[string]*limitState),
+		now:           time.Now,
+	}
+	if limiter.burst == 0 {
+		limiter.burst = limiter.limit
+	}
+	if limiter.maxKeys == 0 {
+		limiter.maxKeys = 100000
+	}
+	return limiter
+}
+
+// Process counts the request under its key
+func (rl *RateLimitFilter) Process(c *gin.Context) (bool, error) {
+	log.Infof("start rate limit filter: %s", rl.FilterName)
+	if rl.limiter == nil {
+		if err := rl.Validate(); err != nil {
+			return false, err
+		}
+	}
+
+	key := rl.key(c)
+	allowed, retryAfter := rl.limiter.allow(key)
+	if allowed {
+		return true, nil
+	}
+
+	log.Warningf("Rate limit %s exceeded for %q", rl.FilterName, key)
+	message := rl.Message
+	if message == "" {
+		message = "too many requests"
+	}
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return false, &FilterError{
+		Status:  http.StatusTooManyRequests,
+		Message: message,
+		Headers: http.Header{"Retry-After": []string{strconv.Itoa(seconds)}},
+	}
+}
+
+func (rl *RateLimitFilter) key(c *gin.Context) string {
+	fields := rl.Key
+	if len(fields) == 0 {
+		fields = []KeyField{{Field: "client_ip"}}
+	}
+	parts := make([]string, len(fields))
+	for i, k := range fields {
+		value, found, err := lookupField(c, Condition{Field: k.Field, Key: k.Key})
+		if err != nil || !found || value == nil {
+			value = ""
+		}
+		parts[i] = k.String() + "=" + fmt.Sprint(value)
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// rateLimiter keeps the in-memory state of every key
+type rateLimiter struct {
+	slidingWindow bool
+	limit         float64
+	burst         float64
+	period        time.Duration
+	maxKeys       int
+	// generation of the last configuration build using the limiter
+	generation uint64
+
+	mu     sync.Mutex
+	states map[string]*limitState
+	now    func() time.Time
+}
+
+// limitState is a token bucket (tokens, last) or the counts of the current
+// and previous window of a sliding window (count, previous, window)
+type limitState struct {
+	tokens float64
+	last   time.Time
+
+	window   time.Time
+	count    float64
+	previous float64
+}
+
+// allow takes one request for key, or returns how long to wait
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	state, ok := l.states[key]
+	if !ok {
+		l.makeRoom(now)
+		state = &limitState{tokens: l.burst, last: now, window: now.Truncate(l.period)}
+		l.states[key] = state
+	}
+	if l.slidingWindow {
+		return l.allowWindow(state, now)
+	}
+	return l.allowBucket(state, now)
+}
+
+func (l *rateLimiter) allowBucket(state *limitState, now time.Time) (bool, time.Duration) {
+	rate := l.limit / l.period.Seconds() // tokens per second
+	state.tokens = math.Min(l.burst, state.tokens+now.Sub(state.last).Seconds()*rate)
+	state.last = now
+	if state.tokens >= 1 {
+		state.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - state.tokens) / rate * float64(time.Second))
+}
+
+// allowWindow weighs the previous window by the part of it still inside
+// the sliding period
+func (l *rateLimiter) allowWindow(state *limitState, now time.Time) (bool, time.Duration) {
+	window := now.Truncate(l.period)
+	switch elapsed := window.Sub(state.window); {
+	case elapsed >= 2*l.period:
+		state.previous, state.count = 0, 0
+	case elapsed >= l.period:
+		state.previous, state.count = state.count, 0
+	}
+	state.window = window
+
+	into := now.Sub(window)
+	weight := 1 - float64(into)/float64(l.period)
+	if state.previous*weight+state.count+1 <= l.limit {
+		state.count++
+		return true, 0
+	}
+
+	// wait until the previous window has faded enough; when the current
+	// window is full, until it has faded enough as the previous one
+	if state.count+1 <= l.limit {
+		needed := 1 - (l.limit-state.count-1)/state.previous
+		return false, time.Duration(needed*float64(l.period)) - into
+	}
+	needed := 1 - (l.limit-1)/state.count
+	return false, l.period - into + time.Duration(needed*float64(l.period))
+}
+
+// makeRoom drops idle keys when the limiter is full, and any key if that is not enough
+func (l *rateLimiter) makeRoom(now time.Time) {
+	if len(l.states) < l.maxKeys {
+		return
+	}
+	for key, state := range l.states {
+		if now.Sub(state.last) > l.period && now.Sub(state.window) > 2*l.period {
+			delete(l.states, key)
+		}
+	}
+	for key := range l.states {
+		if len(l.states) < l.maxKeys {
+			break
+		}
+		delete(l.states, key)
+	}
+}
+
+var rateLimiters = struct {
+	sync.Mutex
+	limiters map[string]*rateLimiter
+}{limiters: make(map[string]*rateLimiter)}
+
+// sharedRateLimiter returns the limiter of a filter with the given route and
+// settings. Limiters no filter of the applied configuration uses are
+// dropped by PruneShared.
+func sharedRateLimiter(version string, build func() *rateLimiter) *rateLimiter {
+	rateLimiters.Lock()
+	defer rateLimiters.Unlock()
+
+	l, ok := rateLimiters.limiters[version]
+	if !ok {
+		l = build()
+		rateLimiters.limiters[version] = l
+	}
+	l.generation = buildGeneration.Load()
+	return l
+}
+
+func pruneRateLimiters(generation uint64) {
+	rateLimiters.Lock()
+	defer rateLimiters.Unlock()
+
+	for version, l := range rateLimiters.limiters {
+		if l.generation < generation {
+			delete(rateLimiters.limiters, version)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package filters
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	rl := &RateLimitFilter{Limit: 2, Period: time.Minute, Burst: 3}
+	l := rl.newLimiter()
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("request %d within the burst was rejected", i)
+		}
+	}
+	ok, retry := l.allow("a")
+	if ok || retry != 30*time.Second {
+		t.Errorf("allow() = %v, %v, want rejection with 30s until the next token", ok, retry)
+	}
+	if ok, _ := l.allow("b"); !ok {
+		t.Errorf("another key was rejected")
+	}
+
+	now = now.Add(30 * time.Second)
+	if ok, _ := l.allow("a"); !ok {
+		t.Errorf("refilled token was rejected")
+	}
+}
+
+func TestRateLimiterSlidingWindow(t *testing.T) {
+	rl := &RateLimitFilter{Algorithm: "sliding_window", Limit: 4, Period: time.Minute}
+	l := rl.newLimiter()
+	now := time.Now().Truncate(time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("request %d within the limit was rejected", i)
+		}
+	}
+	if ok, retry := l.allow("a"); ok || retry != 75*time.Second {
+		t.Errorf("allow() = %v, %v, want rejection until the window has faded (75s)", ok, retry)
+	}
+
+	// a quarter into the next window, the previous one still counts 3 requests
+	now = now.Add(75 * time.Second)
+	if ok, _ := l.allow("a"); !ok {
+		t.Errorf("request after the window faded was rejected")
+	}
+	if ok, _ := l.allow("a"); ok {
+		t.Errorf("request over the sliding limit was allowed")
+	}
+}
+
+func TestRateLimitFilterProcess(t *testing.T) {
+	rl := &RateLimitFilter{
+		FilterName: "signin-test",
+		Limit:      1,
+		Period:     time.Hour,
+		Key:        []KeyField{{Field: "body", Key: "username"}},
+		Message:    "slow down",
+	}
+	if err := rl.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	if ok, err := rl.Process(newFilterContext(`{"username": "alice"}`)); !ok || err != nil {
+		t.Fatalf("Process() = %v, %v, want pass", ok, err)
+	}
+	if ok, err := rl.Process(newFilterContext(`{"username": "bob"}`)); !ok || err != nil {
+		t.Fatalf("Process() = %v, %v, want pass for another username", ok, err)
+	}
+
+	ok, err := rl.Process(newFilterContext(`{"username": "alice"}`))
+	var fe *FilterError
+	if ok || !errors.As(err, &fe) {
+		t.Fatalf("Process() = %v, %v, want FilterError", ok, err)
+	}
+	if fe.Status != http.StatusTooManyRequests || fe.Message != "slow down" || fe.Headers.Get("Retry-After") != "3600" {
+		t.Errorf("FilterError = %+v, want 429 slow down with Retry-After 3600", fe)
+	}
+}
+
+func TestRateLimitFilterValidate(t *testing.T) {
+	tests := []RateLimitFilter{
+		{Limit: 0, Period: time.Second},
+		{Limit: 1, Period: time.Second, Algorithm: "leaky"},
+		{Limit: 1, Period: time.Second, Algorithm: "sliding_window", Burst: 2},
+		{Limit: 1, Period: time.Second, Key: []KeyField{{Field: "body"}}},
+		{Limit: 1, Period: time.Second, Key: []KeyField{{Field: "client_ip", Key: "x"}}},
+	}
+	for _, rl := range tests {
+		if err := rl.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded, want error", rl)
+		}
+	}
+}
+
+func TestRateLimitersPerRoute(t *testing.T) {
+	build := func(route string) *RateLimitFilter {
+		spec, err := NewSpec("rate_limit", map[string]interface{}{"limit": 1, "period": "1m"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec.Route = route
+		filter, err := Build(&spec)
+		if err != nil {
+			t.Fatalf("Build() error = %v", err)
+		}
+		return filter.(*RateLimitFilter)
+	}
+
+	StartConfigBuild()
+	signin, signup := build("POST /signin"), build("POST /signup")
+	if signin.limiter == signup.limiter {
+		t.Fatalf("routes with the same settings share their counters")
+	}
+	if build("POST /signin").limiter != signin.limiter {
+		t.Errorf("a reloaded filter lost its counters")
+	}
+
+	generation := StartConfigBuild()
+	build("POST /signin")
+	PruneShared(generation)
+	kept, dropped := false, true
+	rateLimiters.Lock()
+	for _, l := range rateLimiters.limiters {
+		kept = kept || l == signin.limiter
+		dropped = dropped && l != signup.limiter
+	}
+	rateLimiters.Unlock()
+	if !kept || !dropped {
+		t.Errorf("after pruning signin kept = %v, signup dropped = %v, want only signin kept", kept, dropped)
+	}
+}
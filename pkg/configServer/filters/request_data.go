@@ -0,0 +1,279 @@
+package filters
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// context keys shared by the filters of a request
+const (
+	jsonBodyKey       = "openauth.jsonBody"
+	claimsKey         = "openauth.claims"
+	tokenValidatorKey = "openauth.tokenValidator"
+	obligationsKey    = "openauth.obligations"
+)
+
+// jsonBody parses the request body once, even when filters run in parallel
+type jsonBody struct {
+	once sync.Once
+	body interface{}
+	err  error
+	// read and size are set once the body was read, filters reading them
+	// must not run in parallel with others
+	read bool
+	size int64
+}
+
+func getJSONBody(c *gin.Context) *jsonBody {
+	if cached, ok := c.Get(jsonBodyKey); ok {
+		return cached.(*jsonBody)
+	}
+	cached := &jsonBody{}
+	c.Set(jsonBodyKey, cached)
+	return cached
+}
+
+// JSONBody returns the request body parsed as JSON. The body is parsed once
+// per request and restored, so later filters and the handler can read it.
+func JSONBody(c *gin.Context) (interface{}, error) {
+	cached := getJSONBody(c)
+	cached.once.Do(func() {
+		cached.read = true
+		rawData, err := c.GetRawData()
+		cached.size = int64(len(rawData))
+		if err != nil {
+			cached.err = fmt.Errorf("error reading raw request body: %w", err)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(rawData))
+
+		if len(bytes.TrimSpace(rawData)) > 0 {
+			if err := json.Unmarshal(rawData, &cached.body); err != nil {
+				cached.err = fmt.Errorf("error parsing request body: %v", err)
+			}
+		}
+	})
+	return cached.body, cached.err
+}
+
+// prepareConcurrent creates the per-request caches before filters of the
+// request run in parallel, so they all share the same ones. It reports
+// whether the caller is the outermost parallel group, which must call
+// finishConcurrent once the group ran.
+func prepareConcurrent(c *gin.Context) bool {
+	getJSONBody(c)
+	getCollectedData(c)
+	if pendingObligations(c) != nil {
+		return false
+	}
+	c.Set(obligationsKey, &obligations{headers: http.Header{}})
+	return true
+}
+
+// finishConcurrent applies the obligations of a parallel group that passed,
+// the ones of a failed group are dropped
+func finishConcurrent(c *gin.Context, passed bool) {
+	pending := pendingObligations(c)
+	c.Set(obligationsKey, nil)
+	if pending == nil || !passed {
+		return
+	}
+	for name, values := range pending.headers {
+		c.Request.Header[name] = values
+	}
+	if len(pending.claims) > 0 {
+		AddClaims(c, pending.claims)
+	}
+}
+
+// obligations collects the request headers and claims added by filters of a
+// parallel group. The siblings read the request while they run, so it only
+// changes after the whole group.
+type obligations struct {
+	mu      sync.Mutex
+	headers http.Header
+	claims  map[string]interface{}
+}
+
+func pendingObligations(c *gin.Context) *obligations {
+	value, _ := c.Get(obligationsKey)
+	pending, _ := value.(*obligations)
+	return pending
+}
+
+// addObligations sets request headers and claims for the next filters and
+// the handler, at the end of the parallel group when inside one
+func addObligations(c *gin.Context, headers map[string]string, claims map[string]interface{}) {
+	pending := pendingObligations(c)
+	if pending == nil {
+		for name, value := range headers {
+			c.Request.Header.Set(name, value)
+		}
+		if len(claims) > 0 {
+			AddClaims(c, claims)
+		}
+		return
+	}
+
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+	for name, value := range headers {
+		pending.headers.Set(name, value)
+	}
+	if len(claims) > 0 && pending.claims == nil {
+		pending.claims = make(map[string]interface{}, len(claims))
+	}
+	for k, v := range claims {
+		pending.claims[k] = v
+	}
+}
+
+// LookupPath finds a value in decoded JSON by a dotted path such as
+// "user.roles.0", numeric segments index arrays
+func LookupPath(data interface{}, path string) (interface{}, bool) {
+	current := data
+	for _, segment := range strings.Split(path, ".") {
+		switch v := current.(type) {
+		case map[string]interface{}:
+			next, ok := v[segment]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case map[string]string:
+			next, ok := v[segment]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			current = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// TokenValidator validates a bearer token and returns its claims
+type TokenValidator func(token string) (map[string]interface{}, error)
+
+// TokenValidatorMiddleware makes the token validator of the current
+// configuration available to the filters of a request
+func TokenValidatorMiddleware(validator TokenValidator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(tokenValidatorKey, validator)
+		c.Next()
+	}
+}
+
+// AddClaims merges extra claims, e.g. policy obligations, into the claims
+// seen by the filters that run after it
+func AddClaims(c *gin.Context, extra map[string]interface{}) {
+	claims, err := Claims(c)
+	if err != nil {
+		claims = map[string]interface{}{}
+	}
+
+	merged := make(map[string]interface{}, len(claims)+len(extra))
+	for k, v := range claims {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	c.Set(claimsKey, merged)
+}
+
+// Claims returns the claims of the request's bearer token, validated once per request
+func Claims(c *gin.Context) (map[string]interface{}, error) {
+	if claims, ok := c.Get(claimsKey); ok {
+		return claims.(map[string]interface{}), nil
+	}
+
+	value, _ := c.Get(tokenValidatorKey)
+	validator, ok := value.(TokenValidator)
+	if !ok {
+		return nil, fmt.Errorf("token validation is not configured")
+	}
+
+	scheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, fmt.Errorf("no bearer token")
+	}
+
+	claims, err := validator(strings.TrimSpace(token))
+	if err != nil {
+		return nil, err
+	}
+	c.Set(claimsKey, claims)
+	return claims, nil
+}
+
+// RequestInput describes the request with the same fields as the variables
+// of the cel filter, for filters that take the whole request as one document
+func RequestInput(c *gin.Context) map[string]interface{} {
+	body, err := JSONBody(c)
+	if err != nil {
+		log.Debugf("request body is not JSON: %v", err)
+		body = nil
+	}
+
+	claims, err := Claims(c)
+	if err != nil {
+		claims = map[string]interface{}{}
+	}
+
+	return map[string]interface{}{
+		"headers":   requestHeaders(c),
+		"query":     requestQuery(c),
+		"params":    requestParams(c),
+		"body":      body,
+		"client_ip": c.ClientIP(),
+		"method":    c.Request.Method,
+		"host":      c.Request.Host,
+		"path":      c.Request.URL.Path,
+		"filters":   Collected(c),
+		"claims":    claims,
+	}
+}
+
+// requestHeaders returns lower-case header names with values joined by ","
+func requestHeaders(c *gin.Context) map[string]string {
+	headers := make(map[string]string, len(c.Request.Header))
+	for name, values := range c.Request.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	return headers
+}
+
+// requestQuery returns the first value of each query parameter
+func requestQuery(c *gin.Context) map[string]string {
+	query := make(map[string]string)
+	for name, values := range c.Request.URL.Query() {
+		if len(values) > 0 {
+			query[name] = values[0]
+		}
+	}
+	return query
+}
+
+func requestParams(c *gin.Context) map[string]string {
+	params := make(map[string]string, len(c.Params))
+	for _, p := range c.Params {
+		params[p.Key] = p.Value
+	}
+	return params
+}
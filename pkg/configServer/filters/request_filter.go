@@ -3,63 +3,538 @@ package filters
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/op/go-logging"
+	"gopkg.in/yaml.v2"
 )
 
 var log = logging.MustGetLogger("filter")
 
+func init() {
+	Register("request", func(spec *Spec) (Filter, error) {
+		rf := &RequestFilter{}
+		if err := spec.Decode(rf); err != nil {
+			return nil, err
+		}
+		if rf.Auth != nil {
+			rf.Auth.namespace = spec.Namespace
+		}
+		return rf, nil
+	})
+}
+
+// RequestFilter calls a remote server and passes when it answers 200 or 201.
+// remote_server, headers and string values of body may contain ${path}
+// placeholders, see Template. Without body, the fields_to_send of the JSON
+// request body are sent.
 type RequestFilter struct {
-	FilterName    string            `yaml:"filter_name"`
-	RemoteServer  string            `yaml:"remote_server"`
-	RequestFormat map[string]string `yaml:"request_format"`
-	FieldsToSend  []string          `yaml:"fields_to_send"`
-	client        *http.Client
+	FilterName   string `yaml:"filter_name"`
+	RemoteServer string `yaml:"remote_server"`
+	// Method of the remote call, POST by default
+	Method        string                 `yaml:"method,omitempty"`
+	RequestFormat map[string]string      `yaml:"request_format"`
+	Headers       map[string]string      `yaml:"headers,omitempty"`
+	FieldsToSend  []string               `yaml:"fields_to_send"`
+	Body          map[string]interface{} `yaml:"body,omitempty"`
+	// BodyFormat is json (default) or form
+	BodyFormat string `yaml:"body_format,omitempty"`
+	// Response decides which responses pass, 200 and 201 by default
+	Response *ResponseRules `yaml:"response,omitempty"`
+
+	// Timeout of one call, 10s by default
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Retries of idempotent calls after connection errors and 502/503/504
+	Retries      int           `yaml:"retries,omitempty"`
+	RetryBackoff time.Duration `yaml:"retry_backoff,omitempty"`
+	// CircuitBreaker stops calling a failing remote server for a while
+	CircuitBreaker *CircuitBreakerConfig `yaml:"circuit_breaker,omitempty"`
+	// FailureMode is fail_closed (default), rejecting the request when the
+	// remote server is unavailable, or fail_open, letting it pass
+	FailureMode string `yaml:"failure_mode,omitempty"`
+	// Auth authenticates the calls to the remote server
+	Auth *UpstreamAuth `yaml:"auth,omitempty"`
+	// TLS of https remote servers, the global tls: block by default
+	TLS *TLSConfig `yaml:"tls,omitempty"`
+	// Cache reuses the outcome of calls with the same key
+	Cache *CacheConfig `yaml:"cache,omitempty"`
+
+	client  *http.Client
+	timeout time.Duration
+	breaker *circuitBreaker
+	cache   *responseCache
+
+	url     *Template
+	headers map[string]*Template
+	body    interface{} // body with *Template leaves
 }
 
-func (rf *RequestFilter) Process(c *gin.Context) (bool, error) {
-	log.Infof("start request filter: %s", rf.FilterName)
-	if rf.client == nil {
-		log.Debug("Initializing HTTP client")
-		rf.client = &http.Client{
-			Timeout: time.Second * 10,
-			Transport: &http.Transport{
-				MaxIdleConns:       10,
-				IdleConnTimeout:    30 * time.Second,
-				DisableCompression: true,
-			},
+// Validate checks the remote server and templates when the configuration is loaded
+func (rf *RequestFilter) Validate() error {
+	switch rf.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		return fmt.Errorf("unknown method %q", rf.Method)
+	}
+	switch rf.BodyFormat {
+	case "", "json", "form":
+	default:
+		return fmt.Errorf("body_format must be json or form, got %q", rf.BodyFormat)
+	}
+	if rf.Body != nil && len(rf.FieldsToSend) > 0 {
+		return fmt.Errorf("body and fields_to_send are exclusive")
+	}
+
+	urlTemplate, err := ParseTemplate(rf.RemoteServer)
+	if err != nil {
+		return fmt.Errorf("remote server: %v", err)
+	}
+	if err := validateRemoteServer(placeholderSample(urlTemplate)); err != nil {
+		return err
+	}
+
+	headers := make(map[string]*Template, len(rf.RequestFormat)+len(rf.Headers))
+	for _, source := range []map[string]string{rf.RequestFormat, rf.Headers} {
+		for name, value := range source {
+			t, err := ParseTemplate(value)
+			if err != nil {
+				return fmt.Errorf("header %s: %v", name, err)
+			}
+			headers[name] = t
 		}
 	}
 
-	log.Info("=== RequestFilter Process Start ===")
-	log.Debugf("RemoteServer: %s", rf.RemoteServer)
+	var body interface{}
+	if rf.Body != nil {
+		doc, err := toJSONObject(rf.Body)
+		if err != nil {
+			return fmt.Errorf("body: %v", err)
+		}
+		if body, err = compileBody(doc); err != nil {
+			return fmt.Errorf("body: %v", err)
+		}
+		if rf.BodyFormat == "form" {
+			for key, value := range doc {
+				switch value.(type) {
+				case map[string]interface{}, []interface{}:
+					return fmt.Errorf("body: form field %s must be a scalar", key)
+				}
+			}
+		}
+	}
 
-	// 1. 요청 본문을 읽음
-	var requestBody map[string]interface{}
-	rawData, err := c.GetRawData()
+	if rf.Response != nil {
+		if err := rf.Response.Validate(); err != nil {
+			return fmt.Errorf("response: %v", err)
+		}
+	}
+
+	if rf.Timeout < 0 || rf.Retries < 0 || rf.RetryBackoff < 0 {
+		return fmt.Errorf("timeout, retries and retry_backoff must not be negative")
+	}
+	if rf.Retries > 0 && !isIdempotent(rf.method()) {
+		return fmt.Errorf("retries are only allowed for idempotent methods, not %s", rf.method())
+	}
+	switch rf.FailureMode {
+	case "", "fail_closed", "fail_open":
+	default:
+		return fmt.Errorf("failure_mode must be fail_closed or fail_open, got %q", rf.FailureMode)
+	}
+
+	timeout := rf.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	if rf.Auth != nil {
+		if err := rf.Auth.Validate(); err != nil {
+			return fmt.Errorf("auth: %v", err)
+		}
+	}
+	transport, err := rf.transport(urlTemplate)
 	if err != nil {
-		log.Errorf("Error reading raw request body: %v", err)
-		return false, fmt.Errorf("error reading raw request body: %v", err)
+		return err
+	}
+	if rf.Auth != nil && rf.Auth.Type == "oauth2" {
+		if rf.Auth.tokenTransport, err = tokenTransport(rf.tlsSettings()); err != nil {
+			return fmt.Errorf("auth: %v", err)
+		}
+	}
+	rf.client = &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
 	}
+	rf.timeout = timeout
 
-	// 2. 본문을 다시 Context에 설정
-	c.Request.Body = io.NopCloser(bytes.NewBuffer(rawData))
+	if rf.CircuitBreaker != nil {
+		if rf.CircuitBreaker.Failures <= 0 || rf.CircuitBreaker.OpenFor <= 0 {
+			return fmt.Errorf("circuit_breaker requires positive failures and open_for")
+		}
+		rf.breaker = newCircuitBreaker(*rf.CircuitBreaker)
+	}
+
+	if rf.Cache != nil {
+		if err := rf.Cache.Validate(); err != nil {
+			return fmt.Errorf("cache: %v", err)
+		}
+		if rf.FilterName == "" {
+			return fmt.Errorf("cache requires a filter_name")
+		}
+		// cached results are only reused by a filter with the same settings
+		settings, err := yaml.Marshal(rf)
+		if err != nil {
+			return fmt.Errorf("cache: %v", err)
+		}
+		sum := sha256.Sum256(settings)
+		rf.cache = sharedResponseCache(rf.FilterName, hex.EncodeToString(sum[:]), *rf.Cache)
 
-	// 3. JSON 파싱
-	if err := json.Unmarshal(rawData, &requestBody); err != nil {
-		log.Errorf("Error parsing request body: %v", err)
-		return false, fmt.Errorf("error parsing request body: %v", err)
+		// one call serves every caller with the same key, a custom key must
+		// tell apart the callers the headers are rendered from
+		if len(rf.Cache.Key) > 0 {
+			for name, t := range headers {
+				if t.uses("headers", "claims") && !rf.Cache.hasHeader(name) {
+					return fmt.Errorf("cache: key must include header:%s, it is rendered from the caller", name)
+				}
+			}
+		}
 	}
-	log.Debugf("Received request body: %+v", requestBody)
 
-	// 4. 다음 미들웨어를 위해 본문 다시 설정
-	c.Request.Body = io.NopCloser(bytes.NewBuffer(rawData))
+	rf.url, rf.headers, rf.body = urlTemplate, headers, body
+	return nil
+}
+
+// transport returns the pooled transport for the TLS settings and the
+// client certificate of the filter
+func (rf *RequestFilter) transport(urlTemplate *Template) (*http.Transport, error) {
+	// endpoints of k8s+https:// references are dialed by address,
+	// their certificate is checked against the service DNS name
+	serverName := ""
+	if len(urlTemplate.parts) == 1 && urlTemplate.parts[0].path == "" {
+		if ref, err := ParseServiceRef(rf.RemoteServer); err == nil && ref.TLS {
+			serverName = ref.ServerName()
+		}
+	}
+
+	settings := rf.tlsSettings()
+	mtls := rf.Auth != nil && rf.Auth.Type == "mtls"
+	if settings == nil && serverName == "" && !mtls {
+		return transports.get("", newTransport), nil
+	}
+
+	tlsConfig := TLSConfig{}
+	if settings != nil {
+		tlsConfig = *settings
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = serverName
+	}
+	config, key, err := tlsConfig.build()
+	if err != nil {
+		return nil, fmt.Errorf("tls: %v", err)
+	}
+
+	if mtls {
+		auth := rf.Auth
+		// the certificate of the secret replaces cert_file, the pooled
+		// transport reads it through the auth of the filter that created it
+		config.Certificates = nil
+		config.GetClientCertificate = auth.clientCertificate
+		key += "|" + auth.transportKey()
+	}
+	return transports.get(key, func() *http.Transport {
+		t := newTransport()
+		t.TLSClientConfig = config
+		return t
+	}), nil
+}
+
+// tlsSettings returns the tls: block of the filter or the global one
+func (rf *RequestFilter) tlsSettings() *TLSConfig {
+	if rf.TLS != nil {
+		return rf.TLS
+	}
+	return getDefaultTLS()
+}
+
+func validateRemoteServer(remoteServer string) error {
+	if isServiceRef(remoteServer) {
+		_, err := ParseServiceRef(remoteServer)
+		return err
+	}
+
+	u, err := url.Parse(remoteServer)
+	if err != nil {
+		return fmt.Errorf("invalid remote server %q: %v", remoteServer, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("remote server %q must be http, https, %s:// or %s://", remoteServer, ServiceScheme, ServiceSchemeTLS)
+	}
+	return nil
+}
+
+// placeholderSample fills the placeholders so the URL can be checked at load time
+func placeholderSample(t *Template) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.path != "" {
+			b.WriteString("x")
+			continue
+		}
+		b.WriteString(part.text)
+	}
+	return b.String()
+}
+
+// compileBody replaces the strings of a body document with templates
+func compileBody(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return ParseTemplate(v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			compiled, err := compileBody(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			out[key] = compiled
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			compiled, err := compileBody(item)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %v", i, err)
+			}
+			out[i] = compiled
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+func renderBody(value interface{}, input map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case *Template:
+		return v.Value(input)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			rendered, err := renderBody(item, input)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = rendered
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			rendered, err := renderBody(item, input)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+// renderURL escapes path values before the query string and query values after it
+func renderURL(t *Template, input map[string]interface{}) (string, error) {
+	var b strings.Builder
+	inQuery := false
+	for _, part := range t.parts {
+		if part.path == "" {
+			b.WriteString(part.text)
+			inQuery = inQuery || strings.Contains(part.text, "?")
+			continue
+		}
+		value, err := (&Template{parts: []templatePart{part}}).Render(input, nil)
+		if err != nil {
+			return "", err
+		}
+		if inQuery {
+			b.WriteString(url.QueryEscape(value))
+		} else {
+			b.WriteString(url.PathEscape(value))
+		}
+	}
+	return b.String(), nil
+}
+
+// targetURL resolves k8s:// remote servers to a ready endpoint on every call
+func targetURL(remoteServer string) (string, error) {
+	if !isServiceRef(remoteServer) {
+		return remoteServer, nil
+	}
+
+	ref, err := ParseServiceRef(remoteServer)
+	if err != nil {
+		return "", err
+	}
+	return ref.URL()
+}
+
+// send calls the remote server, idempotent calls are retried with backoff
+// after connection errors and 502/503/504. ctx is the context of req.
+func (rf *RequestFilter) send(ctx context.Context, c *gin.Context, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			var err error
+			if req, err = rf.buildRequest(c); err != nil {
+				return nil, err
+			}
+			req = req.WithContext(ctx)
+		}
+		log.Debugf("Request: %s %s, headers: %+v", req.Method, req.URL, req.Header)
+
+		resp, err := rf.client.Do(req)
+		if attempt >= rf.Retries || (err == nil && !isUnavailable(resp.StatusCode)) {
+			return resp, err
+		}
+
+		if err == nil {
+			log.Warningf("Retrying %s: status %d", rf.RemoteServer, resp.StatusCode)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			log.Warningf("Retrying %s: %v", rf.RemoteServer, err)
+		}
+		if err := backoff(ctx, rf.RetryBackoff, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// unavailable rejects the request, or lets it pass with fail_open
+func (rf *RequestFilter) unavailable(ue *unavailableError) (bool, error) {
+	if rf.FailureMode == "fail_open" {
+		log.Warningf("Remote server unavailable, passing request (fail_open): %v", ue.err)
+		return true, nil
+	}
+	log.Errorf("Remote server %s unavailable (%s): %v", rf.RemoteServer, ue.kind, ue.err)
+	return false, rf.rules().unavailable(ue.kind, ue.err)
+}
+
+func (rf *RequestFilter) rules() *ResponseRules {
+	if rf.Response == nil {
+		return &ResponseRules{}
+	}
+	return rf.Response
+}
+
+// buildRequest renders the remote call from the request and earlier filters' outputs
+func (rf *RequestFilter) buildRequest(c *gin.Context) (*http.Request, error) {
+	input := RequestInput(c)
+
+	method := rf.method()
+
+	remoteServer, err := renderURL(rf.url, input)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering remote server: %v", err)
+	}
+	target, err := targetURL(remoteServer)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving remote server: %v", err)
+	}
+	log.Debugf("Target URL: %s", target)
+
+	var body []byte
+	contentType := ""
+	switch {
+	case rf.body != nil && rf.BodyFormat == "form":
+		rendered, err := renderBody(rf.body, input)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering body: %v", err)
+		}
+		form := url.Values{}
+		for key, value := range rendered.(map[string]interface{}) {
+			form.Set(key, fmt.Sprint(value))
+		}
+		body = []byte(form.Encode())
+		contentType = "application/x-www-form-urlencoded"
+
+	case rf.body != nil:
+		rendered, err := renderBody(rf.body, input)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering body: %v", err)
+		}
+		if body, err = json.Marshal(rendered); err != nil {
+			return nil, fmt.Errorf("error marshaling body: %v", err)
+		}
+		contentType = "application/json"
+
+	case method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch:
+		jsonBody, err := rf.filteredBody(c)
+		if err != nil {
+			return nil, err
+		}
+		body = jsonBody
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(c.Request.Context(), method, target, bodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	for name, t := range rf.headers {
+		value, err := t.Render(input, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering header %s: %v", name, err)
+		}
+		req.Header.Set(name, value)
+	}
+
+	if rf.Auth != nil {
+		if err := rf.Auth.apply(req, body); err != nil {
+			return nil, fmt.Errorf("error authenticating request: %v", err)
+		}
+	}
+	return req, nil
+}
+
+func (rf *RequestFilter) method() string {
+	if rf.Method == "" {
+		return http.MethodPost
+	}
+	return rf.Method
+}
+
+// filteredBody sends the fields_to_send of the JSON request body
+func (rf *RequestFilter) filteredBody(c *gin.Context) ([]byte, error) {
+	body, err := JSONBody(c)
+	if err != nil {
+		return nil, err
+	}
+	requestBody, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("error parsing request body: expected a JSON object")
+	}
+	log.Debugf("Received request body: %+v", requestBody)
 
 	// 지정된 필드만 선택하여 새로운 맵 생성 -> 새로운 Request Body
 	filteredBody := make(map[string]interface{})
@@ -73,57 +548,145 @@ func (rf *RequestFilter) Process(c *gin.Context) (bool, error) {
 
 	jsonBody, err := json.Marshal(filteredBody)
 	if err != nil {
-		fmt.Printf("Error marshaling filtered body: %v\n", err)
-		return false, fmt.Errorf("error marshaling filtered body: %v", err)
+		return nil, fmt.Errorf("error marshaling filtered body: %v", err)
 	}
+	return jsonBody, nil
+}
 
-	req, err := http.NewRequest("POST", rf.RemoteServer, bytes.NewBuffer(jsonBody))
+func (rf *RequestFilter) Process(c *gin.Context) (bool, error) {
+	log.Infof("start request filter: %s", rf.FilterName)
+	log.Info("=== RequestFilter Process Start ===")
+	log.Debugf("RemoteServer: %s", rf.RemoteServer)
+
+	if rf.url == nil {
+		if err := rf.Validate(); err != nil {
+			return false, err
+		}
+	}
+
+	// errors of the request itself are not an unavailable remote server
+	req, err := rf.buildRequest(c)
+	if err != nil {
+		log.Errorf("Error creating request: %v", err)
+		return false, err
+	}
+
+	var res *callResult
+	if rf.cache != nil {
+		var key string
+		if key, err = rf.cacheKey(c, req); err != nil {
+			return false, fmt.Errorf("error building cache key: %v", err)
+		}
+		res, err = rf.cache.get(c.Request.Context(), key, func() (*callResult, error) { return rf.sharedCall(c, req) })
+	} else {
+		res, err = rf.call(c.Request.Context(), c, req)
+	}
 	if err != nil {
-		fmt.Printf("Error creating request: %v\n", err)
-		return false, fmt.Errorf("error creating request: %v", err)
+		var ue *unavailableError
+		if errors.As(err, &ue) {
+			return rf.unavailable(ue)
+		}
+		return false, err
+	}
+	if res.rejected != nil {
+		log.Info("=== RequestFilter Process End ===")
+		return false, res.rejected
 	}
+	result := res.result
 
-	for key, value := range rf.RequestFormat {
-		req.Header.Set(key, value)
+	// 이후 필터들이 응답을 참조할 수 있도록 저장
+	if rf.FilterName != "" {
+		SetCollected(c, rf.FilterName, result)
 	}
-	log.Debugf("Request headers: %+v", req.Header)
 
-	resp, err := rf.client.Do(req)
+	log.Info("=== RequestFilter Process End ===")
+	return true, nil
+}
+
+// cacheKey keys the call by the remote server before k8s:// resolution and
+// by the headers of the filter
+func (rf *RequestFilter) cacheKey(c *gin.Context, req *http.Request) (string, error) {
+	target, err := renderURL(rf.url, RequestInput(c))
 	if err != nil {
-		fmt.Printf("Error sending request: %v\n", err)
-		return false, fmt.Errorf("error sending request: %v", err)
+		return "", err
 	}
-	defer resp.Body.Close()
+	headers := make([]string, 0, len(rf.headers))
+	for name := range rf.headers {
+		headers = append(headers, name)
+	}
+	sort.Strings(headers)
+	return rf.cache.key(req, target, headers)
+}
 
-	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Errorf("Failed to read response body: %v", err)
-			return false, fmt.Errorf("failed to read response body: %v", err)
-		}
-		log.Debugf("Raw response body: %s", string(body))
+// unavailableError marks a remote server that could not answer, the
+// request passes with fail_open. kind selects the error mapping.
+type unavailableError struct {
+	kind string
+	err  error
+}
 
-		// 실제 응답 구조에 맞게 수정
-		var result struct {
-			Message string `json:"message"`
-			OTP     int    `json:"otp"`
-		}
+func (e *unavailableError) Error() string {
+	return e.err.Error()
+}
+
+// sharedCall makes the cached call every waiting request gets the result of.
+// It does not stop when the request that started it goes away, its own
+// deadline covers the timeout of every attempt and the retry backoff.
+func (rf *RequestFilter) sharedCall(c *gin.Context, req *http.Request) (*callResult, error) {
+	budget := rf.timeout * time.Duration(rf.Retries+1)
+	for attempt := 0; attempt < rf.Retries; attempt++ {
+		budget += rf.RetryBackoff<<attempt + rf.RetryBackoff
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), budget)
+	defer cancel()
+	return rf.call(ctx, c, req.WithContext(ctx))
+}
 
-		// body를 다시 읽을 수 있도록 새로운 Reader 생성
-		resp.Body = io.NopCloser(bytes.NewBuffer(body))
+// call sends the request and applies the response rules. Errors mean the
+// remote server did not answer usably, a refused response is returned as
+// rejected so it can be cached.
+func (rf *RequestFilter) call(ctx context.Context, c *gin.Context, req *http.Request) (*callResult, error) {
+	if rf.breaker != nil && !rf.breaker.allow() {
+		return nil, &unavailableError{callErrorCircuitOpen, fmt.Errorf("circuit breaker is open for %s", rf.RemoteServer)}
+	}
 
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			log.Errorf("Failed to decode response: %v", err)
-			return false, fmt.Errorf("failed to decode response: %v", err)
-		}
+	resp, err := rf.send(ctx, c, req)
+	if rf.breaker != nil {
+		rf.breaker.record(err == nil && !isUnavailable(resp.StatusCode))
+	}
+	if err != nil {
+		log.Errorf("Error sending request: %v", err)
+		return nil, &unavailableError{callErrorKind(err), fmt.Errorf("error sending request: %v", err)}
+	}
+	defer resp.Body.Close()
+
+	if isUnavailable(resp.StatusCode) && rf.FailureMode == "fail_open" {
+		return nil, &unavailableError{callErrorConnection, fmt.Errorf("remote server answered %d", resp.StatusCode)}
+	}
 
-		return true, nil // StatusOK이면 성공으로 처리
+	rules := rf.rules()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &unavailableError{callErrorKind(err), fmt.Errorf("failed to read response body: %v", err)}
 	}
+	log.Debugf("Raw response body: %s", string(body))
 
-	log.Debugf("Response from FilterServer: %+v\n", resp)
+	if !rules.isSuccess(resp.StatusCode) {
+		log.Warningf("Request not allowed (status %d)", resp.StatusCode)
+		return &callResult{rejected: rules.failure(resp, body)}, nil
+	}
 
-	log.Warning("Request not allowed (non-200 status code)")
-	log.Info("=== RequestFilter Process End ===")
+	var result interface{}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &result); err != nil {
+			log.Errorf("Failed to decode response of %s: %v", rf.RemoteServer, err)
+			return nil, &FilterError{Status: http.StatusForbidden, Message: "request not allowed (invalid remote response)", cause: err}
+		}
+	}
 
-	return false, fmt.Errorf("request not allowed (non-200 status code)")
+	if err := rules.check(result); err != nil {
+		log.Warningf("Response rejected: %v", err)
+		return &callResult{rejected: err}, nil
+	}
+	return &callResult{result: result}, nil
 }
@@ -0,0 +1,239 @@
+package filters
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+)
+
+type capturedRequest struct {
+	method      string
+	uri         string
+	contentType string
+	headers     http.Header
+	body        string
+}
+
+func newCaptureServer(t *testing.T, response string) (*httptest.Server, *capturedRequest) {
+	captured := &capturedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*captured = capturedRequest{r.Method, r.RequestURI, r.Header.Get("Content-Type"), r.Header, string(body)}
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return server, captured
+}
+
+func newRequestFilter(t *testing.T, data string) *RequestFilter {
+	var rf RequestFilter
+	if err := yaml.UnmarshalStrict([]byte(data), &rf); err != nil {
+		t.Fatalf("Failed to unmarshal filter: %v", err)
+	}
+	if err := rf.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	return &rf
+}
+
+func newFilterContext(body string) *gin.Context {
+	req := httptest.NewRequest(http.MethodPost, "/users/u1?tenant=a", strings.NewReader(body))
+	req.Header.Set("X-Tenant", "team-a")
+	c := newTestContext(req)
+	c.Params = gin.Params{{Key: "id", Value: "u 1"}}
+	SetCollected(c, "user-lookup", map[string]interface{}{"id": float64(7), "email": "a@example.com"})
+	return c
+}
+
+func TestRequestFilterTemplates(t *testing.T) {
+	server, captured := newCaptureServer(t, `{"sent": true}`)
+
+	rf := newRequestFilter(t, `
+filter_name: otp
+remote_server: "`+server.URL+`/users/${params.id}/otp?tenant=${headers.x-tenant}&region=${body.region}"
+method: PUT
+headers:
+  X-Request-Tenant: "tenant-${query.tenant}"
+body:
+  user_id: "${filters.user-lookup.id}"
+  email: "${filters.user-lookup.email}"
+  login: "${body.username}"
+  channel: "sms"
+  attempts: 3
+`)
+
+	c := newFilterContext(`{"username": "alice", "region": "eu west"}`)
+	if ok, err := rf.Process(c); !ok || err != nil {
+		t.Fatalf("Process() = %v, %v", ok, err)
+	}
+
+	if captured.method != http.MethodPut {
+		t.Errorf("Expected PUT, got %s", captured.method)
+	}
+	if captured.uri != "/users/u%201/otp?tenant=team-a&region=eu+west" {
+		t.Errorf("unexpected URI %s", captured.uri)
+	}
+	if captured.headers.Get("X-Request-Tenant") != "tenant-a" || captured.contentType != "application/json" {
+		t.Errorf("unexpected headers %v", captured.headers)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(captured.body), &body); err != nil {
+		t.Fatalf("invalid JSON body %q: %v", captured.body, err)
+	}
+	if body["user_id"] != float64(7) || body["email"] != "a@example.com" || body["login"] != "alice" || body["attempts"] != float64(3) {
+		t.Errorf("unexpected body %v", body)
+	}
+	if Collected(c)["otp"] == nil {
+		t.Error("response was not collected")
+	}
+}
+
+func TestRequestFilterFormAndLegacy(t *testing.T) {
+	server, captured := newCaptureServer(t, `{}`)
+
+	rf := newRequestFilter(t, `
+remote_server: "`+server.URL+`/token"
+body_format: form
+body:
+  grant_type: password
+  username: "${body.username}"
+`)
+	if ok, err := rf.Process(newFilterContext(`{"username": "alice", "password": "x"}`)); !ok || err != nil {
+		t.Fatalf("Process() = %v, %v", ok, err)
+	}
+	if captured.contentType != "application/x-www-form-urlencoded" || captured.body != "grant_type=password&username=alice" {
+		t.Errorf("unexpected form request %q %q", captured.contentType, captured.body)
+	}
+
+	legacy := newRequestFilter(t, `
+remote_server: "`+server.URL+`/auth/login"
+request_format:
+  Content-Type: application/json
+fields_to_send: [username]
+`)
+	if ok, err := legacy.Process(newFilterContext(`{"username": "alice", "password": "x"}`)); !ok || err != nil {
+		t.Fatalf("Process() = %v, %v", ok, err)
+	}
+	if captured.method != http.MethodPost || captured.body != `{"username":"alice"}` {
+		t.Errorf("unexpected legacy request %s %q", captured.method, captured.body)
+	}
+
+	get := newRequestFilter(t, `
+remote_server: "`+server.URL+`/users/${body.missing}"
+method: GET
+`)
+	if ok, err := get.Process(newFilterContext(`{}`)); ok || err == nil || !strings.Contains(err.Error(), "no value for ${body.missing}") {
+		t.Errorf("Process() = %v, %v, want missing value error", ok, err)
+	}
+}
+
+func TestRequestFilterValidate(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{`{remote_server: "http://a/${cookies.x}"}`, "unknown placeholder"},
+		{`{remote_server: "http://a/${params.id"}`, "unclosed placeholder"},
+		{`{remote_server: "http://a/", method: FETCH}`, "unknown method"},
+		{`{remote_server: "http://a/", body_format: xml}`, "body_format"},
+		{`{remote_server: "http://a/", body_format: form, body: {a: {b: c}}}`, "must be a scalar"},
+		{`{remote_server: "http://a/", fields_to_send: [a], body: {a: b}}`, "exclusive"},
+		{`{remote_server: "ftp://a/${params.id}"}`, "must be http"},
+	}
+
+	for _, tt := range tests {
+		var rf RequestFilter
+		if err := yaml.UnmarshalStrict([]byte(tt.data), &rf); err != nil {
+			t.Fatalf("Failed to unmarshal %s: %v", tt.data, err)
+		}
+		if err := rf.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Validate() error = %v, want %q", tt.data, err, tt.want)
+		}
+	}
+}
+
+func TestRequestFilterResponseRules(t *testing.T) {
+	var status int
+	var response string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	rf := newRequestFilter(t, `
+filter_name: user-lookup
+remote_server: "`+server.URL+`/users"
+method: GET
+response:
+  success_status: [200, 204]
+  assert:
+    - path: status
+      operator: equals
+      value: active
+      message: "account is not active"
+    - path: roles
+      operator: in
+      value: [user, admin]
+  errors:
+    - status: [429]
+      forward_headers: [Retry-After]
+    - status: [401, 404]
+      respond_with: 401
+      message: "unknown user"
+`)
+
+	tests := []struct {
+		name       string
+		status     int
+		response   string
+		wantPass   bool
+		wantStatus int
+		wantMsg    string
+	}{
+		{"pass", 200, `{"status": "active", "roles": ["user"]}`, true, 0, ""},
+		{"assertion", 200, `{"status": "locked", "roles": ["user"]}`, false, 0, "account is not active"},
+		{"missing path", 200, `{"status": "active"}`, false, 0, "response assertion failed: roles in"},
+		{"created is not listed", 201, `{"status": "active", "roles": ["user"]}`, false, 0, "non-200"},
+		{"rate limited", 429, `{"error": "slow down"}`, false, 429, "slow down"},
+		{"mapped", 404, `{}`, false, 401, "unknown user"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response = tt.status, tt.response
+			ok, err := rf.Process(newFilterContext(`{}`))
+			if ok != tt.wantPass {
+				t.Fatalf("Process() = %v, %v, want %v", ok, err, tt.wantPass)
+			}
+			if tt.wantPass {
+				return
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("error = %v, want %q", err, tt.wantMsg)
+			}
+
+			fe, isFilterErr := err.(*FilterError)
+			if tt.wantStatus == 0 {
+				if isFilterErr {
+					t.Errorf("unexpected FilterError %+v", fe)
+				}
+				return
+			}
+			if !isFilterErr || fe.Status != tt.wantStatus {
+				t.Fatalf("error = %#v, want status %d", err, tt.wantStatus)
+			}
+			if tt.wantStatus == 429 && fe.Headers.Get("Retry-After") != "30" {
+				t.Errorf("Retry-After was not forwarded: %v", fe.Headers)
+			}
+		})
+	}
+}
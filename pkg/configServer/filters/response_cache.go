@@ -0,0 +1,318 @@
+package filters
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheConfig caches the outcome of remote calls. The method and URL of the
+// call, before k8s:// references are resolved, are always part of the cache key.
+type CacheConfig struct {
+	// Key lists the sent body fields (dotted paths) and headers
+	// ("header:X-Tenant") identifying a call. By default the whole body and
+	// the headers of the filter, e.g. Authorization: ${headers.authorization},
+	// are used. Concurrent misses share one call rendered from the first
+	// caller, so a key must list the headers rendered from the caller's
+	// headers or claims, and the body fields telling callers apart.
+	Key []string `yaml:"key,omitempty"`
+	// TTL of passing responses
+	TTL time.Duration `yaml:"ttl"`
+	// NegativeTTL of rejected responses, they are not cached when zero.
+	// Unavailable remote servers are never cached.
+	NegativeTTL time.Duration `yaml:"negative_ttl,omitempty"`
+	// MaxEntries bounds the cache, 1000 by default, the least recently
+	// used entry is evicted first
+	MaxEntries int `yaml:"max_entries,omitempty"`
+}
+
+// Validate checks the durations and the size bound
+func (cc *CacheConfig) Validate() error {
+	if cc.TTL <= 0 {
+		return fmt.Errorf("ttl must be positive")
+	}
+	if cc.NegativeTTL < 0 || cc.MaxEntries < 0 {
+		return fmt.Errorf("negative_ttl and max_entries must not be negative")
+	}
+	for _, key := range cc.Key {
+		if key == "" || key == "header:" {
+			return fmt.Errorf("empty key field")
+		}
+	}
+	return nil
+}
+
+// hasHeader reports whether the key lists the header
+func (cc *CacheConfig) hasHeader(name string) bool {
+	for _, key := range cc.Key {
+		if header, ok := strings.CutPrefix(key, "header:"); ok && strings.EqualFold(header, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// callResult is the outcome of a remote call that reached the remote server
+type callResult struct {
+	result   interface{}
+	rejected error // the response did not pass the response rules
+}
+
+// responseCache is an LRU cache of call results. Concurrent misses of the
+// same key wait for a single call.
+type responseCache struct {
+	config CacheConfig
+	// generation of the last configuration build using the cache
+	generation uint64
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List // front is the most recently used
+	inflight map[string]*pendingCall
+	now      func() time.Time
+}
+
+type cacheEntry struct {
+	key     string
+	value   *callResult
+	expires time.Time
+}
+
+type pendingCall struct {
+	done  chan struct{}
+	value *callResult
+	err   error
+}
+
+func newResponseCache(config CacheConfig) *responseCache {
+	if config.MaxEntries == 0 {
+		config.MaxEntries = 1000
+	}
+	return &responseCache{
+		config:   config,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		inflight: make(map[string]*pendingCall),
+		now:      time.Now,
+	}
+}
+
+// get returns the cached result of key or calls fetch once for all
+// concurrent callers. Errors of fetch are returned but not cached. Callers
+// waiting for the call of another one stop when ctx is done.
+func (rc *responseCache) get(ctx context.Context, key string, fetch func() (*callResult, error)) (*callResult, error) {
+	rc.mu.Lock()
+	if elem, ok := rc.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if rc.now().Before(entry.expires) {
+			rc.lru.MoveToFront(elem)
+			rc.mu.Unlock()
+			return entry.value, nil
+		}
+		rc.remove(elem)
+	}
+	if call, ok := rc.inflight[key]; ok {
+		rc.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.value, call.err
+		case <-ctx.Done():
+			return nil, &unavailableError{callErrorKind(ctx.Err()), fmt.Errorf("waiting for the cached call: %v", ctx.Err())}
+		}
+	}
+	call := &pendingCall{done: make(chan struct{})}
+	rc.inflight[key] = call
+	rc.mu.Unlock()
+
+	call.value, call.err = fetch()
+
+	rc.mu.Lock()
+	delete(rc.inflight, key)
+	if call.err == nil {
+		ttl := rc.config.TTL
+		if call.value.rejected != nil {
+			ttl = rc.config.NegativeTTL
+		}
+		if ttl > 0 {
+			rc.add(key, call.value, ttl)
+		}
+	}
+	rc.mu.Unlock()
+	close(call.done)
+	return call.value, call.err
+}
+
+func (rc *responseCache) add(key string, value *callResult, ttl time.Duration) {
+	entry := &cacheEntry{key: key, value: value, expires: rc.now().Add(ttl)}
+	if elem, ok := rc.entries[key]; ok {
+		elem.Value = entry
+		rc.lru.MoveToFront(elem)
+		return
+	}
+	rc.entries[key] = rc.lru.PushFront(entry)
+	for rc.lru.Len() > rc.config.MaxEntries {
+		rc.remove(rc.lru.Back())
+	}
+}
+
+func (rc *responseCache) remove(elem *list.Element) {
+	rc.lru.Remove(elem)
+	delete(rc.entries, elem.Value.(*cacheEntry).key)
+}
+
+// flush drops every entry and returns how many there were
+func (rc *responseCache) flush() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	n := rc.lru.Len()
+	rc.entries = make(map[string]*list.Element)
+	rc.lru.Init()
+	return n
+}
+
+// key identifies a call by its method, target and the key fields of its body
+// and headers. target is the remote server before k8s:// resolution, so all
+// endpoints of a service share the entries. headers are the names of the
+// headers set by the filter, they are part of the default key.
+func (rc *responseCache) key(req *http.Request, target string, headers []string) (string, error) {
+	var body []byte
+	if req.GetBody != nil {
+		reader, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		if body, err = io.ReadAll(reader); err != nil {
+			return "", err
+		}
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", req.Method, target)
+	if len(rc.config.Key) == 0 {
+		for _, name := range headers {
+			fmt.Fprintf(hash, "%s: %s\n", name, req.Header.Get(name))
+		}
+		hash.Write(body)
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	var doc interface{}
+	if len(body) > 0 {
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			form, err := url.ParseQuery(string(body))
+			if err != nil {
+				return "", err
+			}
+			fields := make(map[string]interface{}, len(form))
+			for name := range form {
+				fields[name] = form.Get(name)
+			}
+			doc = fields
+		} else if err := json.Unmarshal(body, &doc); err != nil {
+			return "", err
+		}
+	}
+	for _, field := range rc.config.Key {
+		var value interface{}
+		if name, ok := strings.CutPrefix(field, "header:"); ok {
+			value = req.Header.Get(name)
+		} else {
+			value, _ = LookupPath(doc, field)
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s=%s\n", field, encoded)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// responseCaches keeps the caches of filters by filter name and settings
+// for the admin endpoint. A reloaded filter with the same settings keeps its
+// cache, the caches of settings gone from the configuration are dropped by
+// PruneShared.
+var responseCaches = struct {
+	sync.Mutex
+	caches map[string]map[string]*responseCache // name, version
+}{caches: make(map[string]map[string]*responseCache)}
+
+// sharedResponseCache returns the cache of a filter, version identifies the
+// filter settings the cached results were produced with
+func sharedResponseCache(name, version string, config CacheConfig) *responseCache {
+	responseCaches.Lock()
+	defer responseCaches.Unlock()
+
+	versions, ok := responseCaches.caches[name]
+	if !ok {
+		versions = make(map[string]*responseCache)
+		responseCaches.caches[name] = versions
+	}
+	rc, ok := versions[version]
+	if !ok {
+		rc = newResponseCache(config)
+		versions[version] = rc
+	}
+	rc.generation = buildGeneration.Load()
+	return rc
+}
+
+func pruneResponseCaches(generation uint64) {
+	responseCaches.Lock()
+	defer responseCaches.Unlock()
+
+	for name, versions := range responseCaches.caches {
+		for version, rc := range versions {
+			if rc.generation < generation {
+				delete(versions, version)
+			}
+		}
+		if len(versions) == 0 {
+			delete(responseCaches.caches, name)
+		}
+	}
+}
+
+// FlushCache drops the cached responses of a filter. It reports the number
+// of dropped entries and whether the filter has a cache.
+func FlushCache(filterName string) (int, bool) {
+	responseCaches.Lock()
+	versions, ok := responseCaches.caches[filterName]
+	caches := make([]*responseCache, 0, len(versions))
+	for _, rc := range versions {
+		caches = append(caches, rc)
+	}
+	responseCaches.Unlock()
+
+	n := 0
+	for _, rc := range caches {
+		n += rc.flush()
+	}
+	return n, ok
+}
+
+// FlushCaches drops the cached responses of every filter
+func FlushCaches() int {
+	responseCaches.Lock()
+	names := make([]string, 0, len(responseCaches.caches))
+	for name := range responseCaches.caches {
+		names = append(names, name)
+	}
+	responseCaches.Unlock()
+
+	n := 0
+	for _, name := range names {
+		flushed, _ := FlushCache(name)
+		n += flushed
+	}
+	return n
+}
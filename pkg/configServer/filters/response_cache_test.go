@@ -0,0 +1,258 @@
+package filters
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestResponseCacheTTLAndEviction(t *testing.T) {
+	rc := newResponseCache(CacheConfig{TTL: time.Minute, NegativeTTL: time.Second, MaxEntries: 2})
+	now := time.Now()
+	rc.now = func() time.Time { return now }
+
+	calls := 0
+	fetch := func(rejected bool) func() (*callResult, error) {
+		return func() (*callResult, error) {
+			calls++
+			if rejected {
+				return &callResult{rejected: fmt.Errorf("denied")}, nil
+			}
+			return &callResult{result: calls}, nil
+		}
+	}
+
+	rc.get(context.Background(), "a", fetch(false))
+	rc.get(context.Background(), "a", fetch(false))
+	if calls != 1 {
+		t.Errorf("calls = %d, want a cached result", calls)
+	}
+
+	rc.get(context.Background(), "denied", fetch(true))
+	now = now.Add(2 * time.Second)
+	rc.get(context.Background(), "denied", fetch(true))
+	if calls != 3 {
+		t.Errorf("calls = %d, want the negative result to expire after negative_ttl", calls)
+	}
+
+	// "a" was used before "denied", adding "b" evicts it
+	rc.get(context.Background(), "b", fetch(false))
+	rc.get(context.Background(), "a", fetch(false))
+	if calls != 5 {
+		t.Errorf("calls = %d, want the least recently used entry evicted", calls)
+	}
+
+	// errors are not cached
+	rc.get(context.Background(), "down", func() (*callResult, error) { calls++; return nil, fmt.Errorf("unavailable") })
+	rc.get(context.Background(), "down", func() (*callResult, error) { calls++; return nil, fmt.Errorf("unavailable") })
+	if calls != 7 {
+		t.Errorf("calls = %d, want errors not cached", calls)
+	}
+}
+
+func TestResponseCacheSingleflight(t *testing.T) {
+	rc := newResponseCache(CacheConfig{TTL: time.Minute})
+	release := make(chan struct{})
+	var calls int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rc.get(context.Background(), "key", func() (*callResult, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return &callResult{result: "ok"}, nil
+			})
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("calls = %d, want concurrent misses deduplicated", calls)
+	}
+}
+
+func TestRequestFilterCache(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(`{"plan": "pro"}`))
+	}))
+	defer server.Close()
+
+	rf := newRequestFilter(t, `
+filter_name: entitlements-cache-test
+remote_server: "`+server.URL+`/entitlements"
+body:
+  user: "${body.user}"
+  request_id: "${body.request_id}"
+cache:
+  key: [user, "header:X-Tenant"]
+  ttl: 1m
+`)
+
+	for i, body := range []string{
+		`{"user": "alice", "request_id": "1"}`,
+		`{"user": "alice", "request_id": "2"}`,
+		`{"user": "bob", "request_id": "3"}`,
+	} {
+		c := newFilterContext(body)
+		if ok, err := rf.Process(c); !ok || err != nil {
+			t.Fatalf("Process(%d) = %v, %v", i, ok, err)
+		}
+		if Collected(c)["entitlements-cache-test"] == nil {
+			t.Errorf("Process(%d) did not store the cached result", i)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want 2 (request_id is not part of the key)", calls)
+	}
+
+	if flushed, ok := FlushCache("entitlements-cache-test"); !ok || flushed != 2 {
+		t.Errorf("FlushCache() = %d, %v, want 2 entries", flushed, ok)
+	}
+	rf.Process(newFilterContext(`{"user": "alice", "request_id": "4"}`))
+	if calls != 3 {
+		t.Errorf("calls = %d, want a call after the flush", calls)
+	}
+	if _, ok := FlushCache("unknown"); ok {
+		t.Errorf("FlushCache(unknown) reported a cache")
+	}
+}
+
+// alternateResolver resolves every service to its addresses in turn
+type alternateResolver struct {
+	addresses []string
+	n         *int32
+}
+
+func (r alternateResolver) Resolve(namespace, service, port string) (string, error) {
+	return r.addresses[int(atomic.AddInt32(r.n, 1))%len(r.addresses)], nil
+}
+
+func TestRequestFilterCacheDefaultKey(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(`{"user": "` + r.Header.Get("Authorization") + `"}`))
+	}))
+	defer server.Close()
+
+	// the endpoints of a service share the entries
+	_, port, _ := strings.Cut(strings.TrimPrefix(server.URL, "http://"), ":")
+	SetServiceResolver(alternateResolver{[]string{"127.0.0.1:" + port, "localhost:" + port}, new(int32)})
+	defer SetServiceResolver(nil)
+
+	rf := newRequestFilter(t, `
+filter_name: whoami-cache-test
+remote_server: "k8s://whoami.auth:80/whoami"
+method: GET
+headers:
+  Authorization: "${headers.authorization}"
+cache:
+  ttl: 1m
+`)
+	for i, token := range []string{"Bearer alice", "Bearer alice", "Bearer bob"} {
+		c := newFilterContext(`{}`)
+		c.Request.Header.Set("Authorization", token)
+		if ok, err := rf.Process(c); !ok || err != nil {
+			t.Fatalf("Process(%d) = %v, %v", i, ok, err)
+		}
+		if got := Collected(c)["whoami-cache-test"].(map[string]interface{})["user"]; got != token {
+			t.Errorf("Process(%d) got the result of %v, want %s", i, got, token)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want 2 (one per Authorization)", calls)
+	}
+}
+
+func TestRequestFilterCacheSharedCall(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer server.Close()
+
+	rf := newRequestFilter(t, `
+filter_name: shared-call-test
+remote_server: "`+server.URL+`/lookup"
+method: GET
+cache:
+  ttl: 1m
+`)
+
+	// the request starting the call goes away, the waiting one still gets the result
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	first := newFilterContext(`{}`)
+	first.Request = first.Request.WithContext(firstCtx)
+	go rf.Process(first)
+	for started := false; !started; time.Sleep(time.Millisecond) {
+		rf.cache.mu.Lock()
+		started = len(rf.cache.inflight) > 0
+		rf.cache.mu.Unlock()
+	}
+
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := rf.Process(newFilterContext(`{}`))
+		waiting <- err
+	}()
+	cancelFirst()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	if err := <-waiting; err != nil {
+		t.Errorf("waiting request failed after the first one was cancelled: %v", err)
+	}
+
+	// a custom key must tell apart the callers headers are rendered from
+	var spec Spec
+	yaml.Unmarshal([]byte(`
+type: request
+filter_name: shared-key-test
+remote_server: "`+server.URL+`/lookup"
+headers: {Authorization: "${headers.authorization}"}
+cache: {ttl: 1m, key: [username]}
+`), &spec)
+	if _, err := Build(&spec); err == nil || !strings.Contains(err.Error(), "header:Authorization") {
+		t.Errorf("Build() error = %v, want the header required in the key", err)
+	}
+}
+
+func TestPruneSharedResponseCaches(t *testing.T) {
+	build := func(ttl string) {
+		t.Helper()
+		newRequestFilter(t, "filter_name: prune-cache-test\nremote_server: http://auth.example\ncache:\n  ttl: "+ttl)
+	}
+
+	StartConfigBuild()
+	build("1m")
+	generation := StartConfigBuild()
+	build("2m")
+	PruneShared(generation)
+
+	responseCaches.Lock()
+	versions := len(responseCaches.caches["prune-cache-test"])
+	responseCaches.Unlock()
+	if versions != 1 {
+		t.Errorf("caches of prune-cache-test = %d, want only the cache of the applied settings", versions)
+	}
+
+	PruneShared(StartConfigBuild())
+	if _, ok := FlushCache("prune-cache-test"); ok {
+		t.Errorf("cache of a filter gone from the configuration was kept")
+	}
+}
@@ -0,0 +1,241 @@
+package filters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// FilterError rejects a request with a specific response. Other errors
+// returned by filters are answered with 403.
+type FilterError struct {
+	Status  int
+	Message string
+	Headers http.Header
+	// Details are sent next to the error message, e.g. failing fields
+	Details interface{}
+
+	// cause is the internal error behind the response, it is not sent
+	cause error
+}
+
+func (e *FilterError) Error() string {
+	return e.Message
+}
+
+func (e *FilterError) Unwrap() error {
+	return e.cause
+}
+
+// ResponseRules decides whether the response of a remote call lets the request pass
+type ResponseRules struct {
+	// SuccessStatus lists the passing status codes, 200 and 201 by default
+	SuccessStatus []int `yaml:"success_status,omitempty"`
+	// Assert checks the JSON body of a passing response
+	Assert []Assertion `yaml:"assert,omitempty"`
+	// Errors maps failing status codes and failed calls to the response
+	// sent to the client
+	Errors []ErrorMapping `yaml:"errors,omitempty"`
+}
+
+// Assertion checks a dotted JSON path of the response body with a condition operator
+type Assertion struct {
+	Path     string      `yaml:"path"`
+	Operator string      `yaml:"operator"`
+	Value    interface{} `yaml:"value,omitempty"`
+	// Message is returned to the client when the assertion fails
+	Message string `yaml:"message,omitempty"`
+
+	match matcher
+}
+
+// ErrorMapping answers the listed remote status codes with respond_with,
+// e.g. 429 with its Retry-After header. Error lists the failed calls it
+// answers instead: timeout, connection or circuit_open, 503 by default.
+type ErrorMapping struct {
+	Status         []int    `yaml:"status,omitempty"`
+	Error          []string `yaml:"error,omitempty"`
+	RespondWith    int      `yaml:"respond_with,omitempty"`
+	Message        string   `yaml:"message,omitempty"`
+	ForwardHeaders []string `yaml:"forward_headers,omitempty"`
+}
+
+// Validate compiles the assertions
+func (r *ResponseRules) Validate() error {
+	for _, status := range r.SuccessStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("success_status: invalid status %d", status)
+		}
+	}
+
+	for i := range r.Assert {
+		a := &r.Assert[i]
+		if a.Path == "" {
+			return fmt.Errorf("assert[%d]: path is required", i)
+		}
+		match, err := compileMatcher(a.Operator, a.Value)
+		if err != nil {
+			return fmt.Errorf("assert[%d]: %v", i, err)
+		}
+		a.match = match
+	}
+
+	for i, m := range r.Errors {
+		if len(m.Status) == 0 && len(m.Error) == 0 {
+			return fmt.Errorf("errors[%d]: status or error is required", i)
+		}
+		for _, kind := range m.Error {
+			if !containsString(callErrorKinds, kind) {
+				return fmt.Errorf("errors[%d]: error must be one of %v, got %q", i, callErrorKinds, kind)
+			}
+		}
+		for _, status := range append(m.Status, m.RespondWith) {
+			if status != 0 && (status < 100 || status > 599) {
+				return fmt.Errorf("errors[%d]: invalid status %d", i, status)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *ResponseRules) isSuccess(status int) bool {
+	if len(r.SuccessStatus) == 0 {
+		return status == http.StatusOK || status == http.StatusCreated
+	}
+	for _, s := range r.SuccessStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// check evaluates the assertions against the decoded response body
+func (r *ResponseRules) check(result interface{}) error {
+	for i := range r.Assert {
+		a := &r.Assert[i]
+		if a.match == nil && a.Operator != "exists" && a.Operator != "absent" {
+			match, err := compileMatcher(a.Operator, a.Value)
+			if err != nil {
+				return err
+			}
+			a.match = match
+		}
+
+		value, found := LookupPath(result, a.Path)
+		var ok bool
+		switch a.Operator {
+		case "exists":
+			ok = found
+		case "absent":
+			ok = !found
+		default:
+			ok = found && a.match(value)
+		}
+		if ok {
+			continue
+		}
+
+		if a.Message != "" {
+			return fmt.Errorf("%s", a.Message)
+		}
+		return fmt.Errorf("response assertion failed: %s %s %v", a.Path, a.Operator, a.Value)
+	}
+	return nil
+}
+
+// failure converts a failing response into the error sent to the client
+func (r *ResponseRules) failure(resp *http.Response, body []byte) error {
+	for _, m := range r.Errors {
+		if !containsStatus(m.Status, resp.StatusCode) {
+			continue
+		}
+
+		fe := &FilterError{Status: m.RespondWith, Message: m.Message, Headers: http.Header{}}
+		if fe.Status == 0 {
+			fe.Status = resp.StatusCode
+		}
+		if fe.Message == "" {
+			fe.Message = remoteMessage(body, resp.StatusCode)
+		}
+		for _, name := range m.ForwardHeaders {
+			if value := resp.Header.Get(name); value != "" {
+				fe.Headers.Set(name, value)
+			}
+		}
+		return fe
+	}
+	return fmt.Errorf("request not allowed (non-200 status code)")
+}
+
+// unavailable converts a failed call into the error sent to the client. The
+// cause is not sent, it may name internal addresses.
+func (r *ResponseRules) unavailable(kind string, cause error) error {
+	for _, m := range r.Errors {
+		if !containsString(m.Error, kind) {
+			continue
+		}
+		fe := &FilterError{Status: m.RespondWith, Message: m.Message, cause: cause}
+		if fe.Status == 0 {
+			fe.Status = http.StatusServiceUnavailable
+		}
+		if fe.Message == "" {
+			fe.Message = "remote server unavailable"
+		}
+		return fe
+	}
+	return &FilterError{Status: http.StatusForbidden, Message: "request not allowed (remote server unavailable)", cause: cause}
+}
+
+// mapsError reports whether an error mapping answers failed calls of kind
+func (r *ResponseRules) mapsError(kind string) bool {
+	for _, m := range r.Errors {
+		if containsString(m.Error, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// kinds of failed calls for ErrorMapping.Error
+const (
+	callErrorTimeout     = "timeout"
+	callErrorConnection  = "connection"
+	callErrorCircuitOpen = "circuit_open"
+)
+
+var callErrorKinds = []string{callErrorTimeout, callErrorConnection, callErrorCircuitOpen}
+
+// callErrorKind tells timeouts from other failures of sending a call
+func callErrorKind(err error) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return callErrorTimeout
+	}
+	return callErrorConnection
+}
+
+func containsStatus(list []int, status int) bool {
+	for _, s := range list {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteMessage uses the error or message field of a JSON error response
+func remoteMessage(body []byte, status int) string {
+	var doc map[string]interface{}
+	if json.Unmarshal(body, &doc) == nil {
+		for _, key := range []string{"error", "message"} {
+			if s, ok := doc[key].(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+	return fmt.Sprintf("request not allowed (status %d)", status)
+}
@@ -0,0 +1,242 @@
+package filters
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+func init() {
+	Register("schema", func(spec *Spec) (Filter, error) {
+		sf := &SchemaFilter{}
+		if err := spec.Decode(sf); err != nil {
+			return nil, err
+		}
+		return sf, nil
+	})
+}
+
+var (
+	schemaDirMu sync.RWMutex
+	schemaDir   = "api"
+)
+
+// SetSchemaDir sets the directory schema_file is read from
+func SetSchemaDir(dir string) {
+	schemaDirMu.Lock()
+	defer schemaDirMu.Unlock()
+	schemaDir = dir
+}
+
+func getSchemaDir() string {
+	schemaDirMu.RLock()
+	defer schemaDirMu.RUnlock()
+	return schemaDir
+}
+
+// SchemaFilter validates the JSON request body against a JSON Schema
+// (draft 2020-12 unless $schema says otherwise) and answers 400 with the
+// failing fields. Put it first: a body read by an earlier filter is only
+// checked by its size after it was read in full. Routes whose request_filters
+// or AuthPolicies call remote servers are refused, those run before the
+// filters list.
+type SchemaFilter struct {
+	FilterName string `yaml:"filter_name"`
+	// Schema is an inline schema, SchemaFile a file under the schema
+	// directory (-schema-dir, "api" by default)
+	Schema     map[string]interface{} `yaml:"schema,omitempty"`
+	SchemaFile string                 `yaml:"schema_file,omitempty"`
+	// MaxBodyBytes rejects larger bodies with 413, 1 MiB by default
+	MaxBodyBytes int64 `yaml:"max_body_bytes,omitempty"`
+
+	schema *jsonschema.Schema
+}
+
+// FieldError is one failing field of a request body
+type FieldError struct {
+	// Field is the JSON pointer of the field, e.g. /user/email
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+var schemaPrinter = message.NewPrinter(language.English)
+
+// Validate compiles the schema
+func (sf *SchemaFilter) Validate() error {
+	if (sf.Schema == nil) == (sf.SchemaFile == "") {
+		return fmt.Errorf("exactly one of schema and schema_file is required")
+	}
+	if sf.MaxBodyBytes < 0 {
+		return fmt.Errorf("max_body_bytes must not be negative")
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.DefaultDraft(jsonschema.Draft2020)
+	compiler.AssertFormat()
+
+	location := "inline.json"
+	if sf.Schema != nil {
+		doc, err := toJSONObject(sf.Schema)
+		if err != nil {
+			return fmt.Errorf("schema: %v", err)
+		}
+		if err := compiler.AddResource(location, doc); err != nil {
+			return fmt.Errorf("schema: %v", err)
+		}
+	} else {
+		path := filepath.Clean(sf.SchemaFile)
+		if filepath.IsAbs(path) || strings.HasPrefix(path, "..") {
+			return fmt.Errorf("schema_file %q must be relative to the schema directory", sf.SchemaFile)
+		}
+		file, err := os.Open(filepath.Join(getSchemaDir(), path))
+		if err != nil {
+			return fmt.Errorf("schema_file: %v", err)
+		}
+		defer file.Close()
+		doc, err := jsonschema.UnmarshalJSON(file)
+		if err != nil {
+			return fmt.Errorf("schema_file %s: %v", sf.SchemaFile, err)
+		}
+		location = filepath.ToSlash(path)
+		if err := compiler.AddResource(location, doc); err != nil {
+			return fmt.Errorf("schema_file %s: %v", sf.SchemaFile, err)
+		}
+	}
+
+	schema, err := compiler.Compile(location)
+	if err != nil {
+		return fmt.Errorf("invalid schema: %v", err)
+	}
+	sf.schema = schema
+	return nil
+}
+
+// replacesBody keeps schema out of parallel groups, Process limits the body
+// with a MaxBytesReader
+func (sf *SchemaFilter) replacesBody() {}
+
+// Process checks the size and the schema of the body
+func (sf *SchemaFilter) Process(c *gin.Context) (bool, error) {
+	log.Infof("start schema filter: %s", sf.FilterName)
+	if sf.schema == nil {
+		if err := sf.Validate(); err != nil {
+			return false, err
+		}
+	}
+
+	limit := sf.MaxBodyBytes
+	if limit == 0 {
+		limit = 1 << 20
+	}
+	tooLarge := &FilterError{
+		Status:  http.StatusRequestEntityTooLarge,
+		Message: fmt.Sprintf("request body is larger than %d bytes", limit),
+	}
+	if c.Request.ContentLength > limit {
+		return false, tooLarge
+	}
+	// a body read by an earlier filter is checked by its size
+	if cached := getJSONBody(c); cached.read {
+		if cached.size > limit {
+			return false, tooLarge
+		}
+	} else if c.Request.Body != nil {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	}
+
+	body, err := JSONBody(c)
+	if err != nil {
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			return false, tooLarge
+		}
+		return false, &FilterError{Status: http.StatusBadRequest, Message: "request body is not valid JSON"}
+	}
+
+	// the validator expects numbers as decoded by UnmarshalJSON
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return false, err
+	}
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return false, err
+	}
+
+	err = sf.schema.Validate(instance)
+	if err == nil {
+		return true, nil
+	}
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return false, err
+	}
+	fields := fieldErrors(verr)
+	log.Warningf("Request body does not match the schema: %v", fields)
+	return false, &FilterError{
+		Status:  http.StatusBadRequest,
+		Message: "request body does not match the schema",
+		Details: fields,
+	}
+}
+
+// fieldErrors flattens the validation error into one error per failing
+// field, missing and unexpected properties are reported at the property itself
+func fieldErrors(verr *jsonschema.ValidationError) []FieldError {
+	var fields []FieldError
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) > 0 {
+			for _, cause := range e.Causes {
+				walk(cause)
+			}
+			return
+		}
+		switch k := e.ErrorKind.(type) {
+		case *kind.Required:
+			for _, name := range k.Missing {
+				fields = append(fields, FieldError{Field: propertyPointer(e, name), Message: "is required"})
+			}
+			return
+		case *kind.AdditionalProperties:
+			for _, name := range k.Properties {
+				fields = append(fields, FieldError{Field: propertyPointer(e, name), Message: "is not allowed"})
+			}
+			return
+		}
+		fields = append(fields, FieldError{
+			Field:   jsonPointer(e.InstanceLocation),
+			Message: e.ErrorKind.LocalizedString(schemaPrinter),
+		})
+	}
+	walk(verr)
+
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	return fields
+}
+
+func propertyPointer(e *jsonschema.ValidationError, name string) string {
+	return jsonPointer(append(append([]string{}, e.InstanceLocation...), name))
+}
+
+func jsonPointer(tokens []string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteByte('/')
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package filters
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func newSchemaFilter(t *testing.T, data string) *SchemaFilter {
+	var sf SchemaFilter
+	if err := yaml.UnmarshalStrict([]byte(data), &sf); err != nil {
+		t.Fatalf("Failed to unmarshal filter: %v", err)
+	}
+	if err := sf.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	return &sf
+}
+
+func TestSchemaFilterFieldErrors(t *testing.T) {
+	sf := newSchemaFilter(t, `
+filter_name: signin-body
+schema:
+  type: object
+  required: [username, password]
+  additionalProperties: false
+  properties:
+    username: {type: string, minLength: 3}
+    password: {type: string}
+    profile:
+      type: object
+      properties:
+        email: {type: string, format: email}
+`)
+
+	tests := []struct {
+		body       string
+		wantStatus int
+		wantFields []string
+	}{
+		{body: `{"username": "alice", "password": "secret"}`},
+		{body: `{"username": "al", "admin": true, "profile": {"email": "nope"}}`, wantStatus: 400,
+			wantFields: []string{"/admin", "/password", "/profile/email", "/username"}},
+		{body: `{"username": `, wantStatus: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			ok, err := sf.Process(newFilterContext(tt.body))
+			if tt.wantStatus == 0 {
+				if !ok || err != nil {
+					t.Errorf("Process() = %v, %v, want pass", ok, err)
+				}
+				return
+			}
+			var fe *FilterError
+			if ok || !errors.As(err, &fe) || fe.Status != tt.wantStatus {
+				t.Fatalf("Process() = %v, %v, want status %d", ok, err, tt.wantStatus)
+			}
+			if tt.wantFields == nil {
+				return
+			}
+			var fields []string
+			for _, f := range fe.Details.([]FieldError) {
+				fields = append(fields, f.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("fields = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestSchemaFilterSizeLimit(t *testing.T) {
+	sf := newSchemaFilter(t, "schema: {type: object}\nmax_body_bytes: 16\n")
+
+	body := `{"username": "a-rather-long-name"}`
+	c := newTestContext(httptest.NewRequest(http.MethodPost, "/signin", strings.NewReader(body)))
+	var fe *FilterError
+	if ok, err := sf.Process(c); ok || !errors.As(err, &fe) || fe.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Process() = %v, %v, want 413", ok, err)
+	}
+
+	// chunked bodies have no Content-Length
+	req := httptest.NewRequest(http.MethodPost, "/signin", strings.NewReader(body))
+	req.ContentLength = -1
+	c = newTestContext(req)
+	if ok, err := sf.Process(c); ok || !errors.As(err, &fe) || fe.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Process() = %v, %v, want 413 without Content-Length", ok, err)
+	}
+
+	// the body was already read by an earlier filter
+	req = httptest.NewRequest(http.MethodPost, "/signin", strings.NewReader(body))
+	req.ContentLength = -1
+	c = newTestContext(req)
+	JSONBody(c)
+	if ok, err := sf.Process(c); ok || !errors.As(err, &fe) || fe.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Process() = %v, %v, want 413 after an earlier read", ok, err)
+	}
+
+	// prepared for a parallel group but not read yet
+	req = httptest.NewRequest(http.MethodPost, "/signin", strings.NewReader(body))
+	req.ContentLength = -1
+	c = newTestContext(req)
+	prepareConcurrent(c)
+	if ok, err := sf.Process(c); ok || !errors.As(err, &fe) || fe.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Process() = %v, %v, want 413 after prepareConcurrent", ok, err)
+	}
+}
+
+func TestSchemaFilterFile(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "schemas"), 0755)
+	os.WriteFile(filepath.Join(dir, "schemas", "otp.json"), []byte(`{"type": "object", "required": ["otp"]}`), 0644)
+	SetSchemaDir(dir)
+	defer SetSchemaDir("api")
+
+	sf := newSchemaFilter(t, "schema_file: schemas/otp.json\n")
+	if ok, err := sf.Process(newFilterContext(`{"otp": "123456"}`)); !ok || err != nil {
+		t.Errorf("Process() = %v, %v, want pass", ok, err)
+	}
+
+	for _, bad := range []SchemaFilter{
+		{SchemaFile: "../secrets.json"},
+		{SchemaFile: "schemas/missing.json"},
+		{},
+		{Schema: map[string]interface{}{"type": "nonsense"}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package filters
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ServiceScheme marks a remote_server resolved through Kubernetes:
+// k8s://<service>.<namespace>[:<port>]/path. Services answering over TLS
+// are referenced with ServiceSchemeTLS, k8s+https://.
+const (
+	ServiceScheme    = "k8s"
+	ServiceSchemeTLS = "k8s+https"
+)
+
+// isServiceRef reports whether a remote server is a k8s:// or k8s+https:// reference
+func isServiceRef(remoteServer string) bool {
+	return strings.HasPrefix(remoteServer, ServiceScheme+"://") ||
+		strings.HasPrefix(remoteServer, ServiceSchemeTLS+"://")
+}
+
+// ServiceResolver picks a ready endpoint ("ip:port") of a service port
+type ServiceResolver interface {
+	Resolve(namespace, service, port string) (string, error)
+}
+
+// EndpointLister is implemented by service resolvers that also list every
+// ready endpoint ("ip:port"), gRPC connections balance their calls over them
+type EndpointLister interface {
+	Addresses(namespace, service, port string) ([]string, error)
+}
+
+var (
+	resolverMu      sync.RWMutex
+	serviceResolver ServiceResolver
+)
+
+// SetServiceResolver sets the resolver used for k8s:// remote servers
+func SetServiceResolver(r ServiceResolver) {
+	resolverMu.Lock()
+	defer resolverMu.Unlock()
+	serviceResolver = r
+}
+
+func getServiceResolver() ServiceResolver {
+	resolverMu.RLock()
+	defer resolverMu.RUnlock()
+	return serviceResolver
+}
+
+// ServiceRef is a parsed k8s:// remote server
+type ServiceRef struct {
+	Service   string
+	Namespace string
+	Port      string // service port name or number, empty for the only port
+	Path      string // path including the query string
+	TLS       bool   // k8s+https://, the endpoint is called over https
+}
+
+// ParseServiceRef parses k8s://<service>.<namespace>[:<port>]/path
+func ParseServiceRef(raw string) (*ServiceRef, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote server %q: %v", raw, err)
+	}
+	if u.Scheme != ServiceScheme && u.Scheme != ServiceSchemeTLS {
+		return nil, fmt.Errorf("remote server %q is not a %s:// reference", raw, ServiceScheme)
+	}
+
+	service, namespace, ok := strings.Cut(u.Hostname(), ".")
+	if !ok || service == "" || namespace == "" || strings.Contains(namespace, ".") {
+		return nil, fmt.Errorf("remote server %q must look like %s://<service>.<namespace>:<port>/path", raw, ServiceScheme)
+	}
+
+	return &ServiceRef{
+		Service:   service,
+		Namespace: namespace,
+		Port:      u.Port(),
+		Path:      u.RequestURI(),
+		TLS:       u.Scheme == ServiceSchemeTLS,
+	}, nil
+}
+
+// ServerName is the in-cluster DNS name of the service, checked against its certificate
+func (ref *ServiceRef) ServerName() string {
+	return ref.Service + "." + ref.Namespace + ".svc"
+}
+
+// Address resolves the reference to the "ip:port" of a ready endpoint
+func (ref *ServiceRef) Address() (string, error) {
+	resolver := getServiceResolver()
+	if resolver == nil {
+		return "", fmt.Errorf("no service resolver configured for %s://%s.%s", ServiceScheme, ref.Service, ref.Namespace)
+	}
+	return resolver.Resolve(ref.Namespace, ref.Service, ref.Port)
+}
+
+// Addresses returns every ready endpoint, or the one picked by a resolver
+// that cannot list them
+func (ref *ServiceRef) Addresses() ([]string, error) {
+	if lister, ok := getServiceResolver().(EndpointLister); ok {
+		return lister.Addresses(ref.Namespace, ref.Service, ref.Port)
+	}
+	address, err := ref.Address()
+	if err != nil {
+		return nil, err
+	}
+	return []string{address}, nil
+}
+
+// URL resolves the reference to an http or https URL of a ready endpoint
+func (ref *ServiceRef) URL() (string, error) {
+	address, err := ref.Address()
+	if err != nil {
+		return "", err
+	}
+	scheme := "http://"
+	if ref.TLS {
+		scheme = "https://"
+	}
+	return scheme + address + ref.Path, nil
+}
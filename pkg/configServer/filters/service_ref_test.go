@@ -0,0 +1,79 @@
+package filters
+
+import (
+	"fmt"
+	"testing"
+)
+
+type staticResolver map[string]string
+
+func (r staticResolver) Resolve(namespace, service, port string) (string, error) {
+	address, ok := r[service+"."+namespace+":"+port]
+	if !ok {
+		return "", fmt.Errorf("unknown service")
+	}
+	return address, nil
+}
+
+func TestParseServiceRef(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    ServiceRef
+		wantErr bool
+	}{
+		{
+			raw:  "k8s://user-auth.default:80/auth/signup",
+			want: ServiceRef{Service: "user-auth", Namespace: "default", Port: "80", Path: "/auth/signup"},
+		},
+		{
+			raw:  "k8s://otp.security/otp/verify?mode=totp",
+			want: ServiceRef{Service: "otp", Namespace: "security", Path: "/otp/verify?mode=totp"},
+		},
+		{
+			raw:  "k8s+https://user-auth.default:443/auth",
+			want: ServiceRef{Service: "user-auth", Namespace: "default", Port: "443", Path: "/auth", TLS: true},
+		},
+		{raw: "k8s://user-auth:80/auth", wantErr: true},
+		{raw: "k8s://user-auth.default.svc:80/auth", wantErr: true},
+		{raw: "http://10.106.248.129/auth/signup", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			ref, err := ParseServiceRef(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %+v", ref)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseServiceRef() error = %v", err)
+			}
+			if *ref != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, *ref)
+			}
+		})
+	}
+}
+
+func TestRequestFilterTargetURL(t *testing.T) {
+	SetServiceResolver(staticResolver{"user-auth.default:80": "10.244.0.7:8080"})
+	defer SetServiceResolver(nil)
+
+	rf := &RequestFilter{RemoteServer: "k8s://user-auth.default:80/auth/login"}
+	if err := rf.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	target, err := targetURL(rf.RemoteServer)
+	if err != nil {
+		t.Fatalf("targetURL() error = %v", err)
+	}
+	if target != "http://10.244.0.7:8080/auth/login" {
+		t.Errorf("unexpected target URL %s", target)
+	}
+
+	if err := (&RequestFilter{RemoteServer: "ftp://example"}).Validate(); err == nil {
+		t.Error("expected error for unsupported scheme")
+	}
+}
@@ -0,0 +1,20 @@
+package filters
+
+import "sync/atomic"
+
+// buildGeneration counts the configuration builds, see StartConfigBuild
+var buildGeneration atomic.Uint64
+
+// StartConfigBuild starts building the filters of a configuration. State
+// shared across reloads, such as response caches, is marked with the
+// returned generation when a filter of the build uses it, PruneShared drops
+// the rest once the configuration is applied.
+func StartConfigBuild() uint64 {
+	return buildGeneration.Add(1)
+}
+
+// PruneShared drops the shared state no filter used since the build of generation
+func PruneShared(generation uint64) {
+	pruneResponseCaches(generation)
+	pruneRateLimiters(generation)
+}
@@ -0,0 +1,107 @@
+package filters
+
+import (
+	"fmt"
+	"strings"
+)
+
+// template roots, the fields of RequestInput
+var templateRoots = map[string]bool{
+	"headers": true, "query": true, "params": true, "body": true, "claims": true,
+	"filters": true, "client_ip": true, "method": true, "host": true, "path": true,
+}
+
+// Template is a string with ${path} placeholders resolved against
+// RequestInput, e.g. "/users/${params.id}" or "${filters.user-lookup.email}"
+type Template struct {
+	raw   string
+	parts []templatePart
+}
+
+type templatePart struct {
+	text string
+	path string // placeholder path when text is empty
+}
+
+// ParseTemplate checks the placeholders of s
+func ParseTemplate(s string) (*Template, error) {
+	t := &Template{raw: s}
+	rest := s
+	for {
+		start := strings.Index(rest, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in %q", s)
+		}
+
+		path := strings.TrimSpace(rest[start+2 : start+end])
+		root, _, _ := strings.Cut(path, ".")
+		if !templateRoots[root] {
+			return nil, fmt.Errorf("unknown placeholder ${%s} in %q", path, s)
+		}
+
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{text: rest[:start]})
+		}
+		t.parts = append(t.parts, templatePart{path: path})
+		rest = rest[start+end+1:]
+	}
+	if rest != "" {
+		t.parts = append(t.parts, templatePart{text: rest})
+	}
+	return t, nil
+}
+
+// uses reports whether a placeholder reads one of the roots
+func (t *Template) uses(roots ...string) bool {
+	for _, part := range t.parts {
+		root, _, _ := strings.Cut(part.path, ".")
+		if part.path != "" && containsString(roots, root) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Template) String() string {
+	return t.raw
+}
+
+// Render replaces the placeholders, escape is applied to every value when set.
+// A placeholder without a value is an error, so remote calls never see
+// silently empty fields.
+func (t *Template) Render(input map[string]interface{}, escape func(string) string) (string, error) {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.path == "" {
+			b.WriteString(part.text)
+			continue
+		}
+		value, ok := LookupPath(input, part.path)
+		if !ok || value == nil {
+			return "", fmt.Errorf("no value for ${%s}", part.path)
+		}
+		s := fmt.Sprint(value)
+		if escape != nil {
+			s = escape(s)
+		}
+		b.WriteString(s)
+	}
+	return b.String(), nil
+}
+
+// Value renders the template, a template that is a single placeholder keeps
+// the type of its value, e.g. a number or an object of the JSON body
+func (t *Template) Value(input map[string]interface{}) (interface{}, error) {
+	if len(t.parts) == 1 && t.parts[0].path != "" {
+		value, ok := LookupPath(input, t.parts[0].path)
+		if !ok {
+			return nil, fmt.Errorf("no value for ${%s}", t.parts[0].path)
+		}
+		return value, nil
+	}
+	return t.Render(input, nil)
+}
@@ -0,0 +1,140 @@
+package filters
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// TLSConfig sets up the TLS connections of filters to their remote servers.
+// It is given per filter with tls: or for every filter in the tls: block of
+// the configuration.
+type TLSConfig struct {
+	// CA is a PEM bundle, CAFile a file holding one. Without either the
+	// system roots are used.
+	CA     string `yaml:"ca,omitempty"`
+	CAFile string `yaml:"ca_file,omitempty"`
+	// client certificate presented to the remote server
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+	// ServerName checked against the certificate of the remote server,
+	// the host of the remote server by default
+	ServerName string `yaml:"server_name,omitempty"`
+	// MinVersion is 1.2 (default) or 1.3
+	MinVersion string `yaml:"min_version,omitempty"`
+	// InsecureSkipVerify is refused unless OpenAuth runs with -allow-insecure-tls
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify,omitempty"`
+}
+
+var (
+	tlsMu            sync.RWMutex
+	defaultTLS       *TLSConfig
+	allowInsecureTLS bool
+)
+
+// SetAllowInsecureTLS allows insecure_skip_verify in TLS configurations
+func SetAllowInsecureTLS(allow bool) {
+	tlsMu.Lock()
+	defer tlsMu.Unlock()
+	allowInsecureTLS = allow
+}
+
+// SetDefaultTLS sets the TLS configuration of filters without their own
+// tls: block and returns the previous one
+func SetDefaultTLS(config *TLSConfig) *TLSConfig {
+	tlsMu.Lock()
+	defer tlsMu.Unlock()
+	previous := defaultTLS
+	defaultTLS = config
+	return previous
+}
+
+func getDefaultTLS() *TLSConfig {
+	tlsMu.RLock()
+	defer tlsMu.RUnlock()
+	return defaultTLS
+}
+
+func insecureTLSAllowed() bool {
+	tlsMu.RLock()
+	defer tlsMu.RUnlock()
+	return allowInsecureTLS
+}
+
+// Validate checks the settings and that the CA and certificate files can be read
+func (t *TLSConfig) Validate() error {
+	_, _, err := t.build()
+	return err
+}
+
+// build returns the client TLS configuration and a key identifying it in
+// the transport pool. Files are read here, a changed file is picked up
+// when the configuration is loaded again.
+func (t *TLSConfig) build() (*tls.Config, string, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: t.ServerName}
+	switch t.MinVersion {
+	case "", "1.2":
+	case "1.3":
+		config.MinVersion = tls.VersionTLS13
+	default:
+		return nil, "", fmt.Errorf("min_version must be 1.2 or 1.3, got %q", t.MinVersion)
+	}
+
+	if t.InsecureSkipVerify {
+		if !insecureTLSAllowed() {
+			return nil, "", fmt.Errorf("insecure_skip_verify requires OpenAuth to run with -allow-insecure-tls")
+		}
+		log.Warningf("TLS certificate verification is disabled (server name %q)", t.ServerName)
+		config.InsecureSkipVerify = true
+	}
+
+	// the key covers the settings and the content of the files
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%t\x00", t.ServerName, t.MinVersion, t.InsecureSkipVerify)
+
+	if t.CA != "" && t.CAFile != "" {
+		return nil, "", fmt.Errorf("ca and ca_file are exclusive")
+	}
+	ca := []byte(t.CA)
+	if t.CAFile != "" {
+		var err error
+		if ca, err = os.ReadFile(t.CAFile); err != nil {
+			return nil, "", fmt.Errorf("error reading ca_file: %v", err)
+		}
+	}
+	if len(ca) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, "", fmt.Errorf("no PEM certificates in the CA bundle")
+		}
+		config.RootCAs = pool
+	}
+	hash.Write(ca)
+
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return nil, "", fmt.Errorf("cert_file and key_file must be set together")
+	}
+	if t.CertFile != "" {
+		certPEM, err := os.ReadFile(t.CertFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading cert_file: %v", err)
+		}
+		keyPEM, err := os.ReadFile(t.KeyFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading key_file: %v", err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+		hash.Write(certPEM)
+		hash.Write(keyPEM)
+	}
+
+	return config, "tls:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
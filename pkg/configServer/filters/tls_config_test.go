@@ -0,0 +1,120 @@
+package filters
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTLSServer serves https with a self-signed certificate for localhost
+// and requires a client certificate
+func newTLSServer(t *testing.T, maxVersion uint16) (*httptest.Server, []byte) {
+	certPEM, keyPEM := newTestCertificate(t)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAnyClientCert,
+		MaxVersion:   maxVersion,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server, certPEM
+}
+
+func TestRequestFilterTLS(t *testing.T) {
+	server, caPEM := newTLSServer(t, 0)
+	tls12, _ := newTLSServer(t, tls.VersionTLS12)
+
+	dir := t.TempDir()
+	certPEM, keyPEM := newTestCertificate(t)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	os.WriteFile(certFile, certPEM, 0600)
+	os.WriteFile(keyFile, keyPEM, 0600)
+
+	ca := strings.ReplaceAll(string(caPEM), "\n", "\n    ")
+	client := "\n  cert_file: " + certFile + "\n  key_file: " + keyFile
+
+	tests := []struct {
+		name    string
+		url     string
+		tls     string
+		wantErr string
+	}{
+		{"private CA", server.URL, "ca: |\n    " + ca + "\n  server_name: localhost" + client, ""},
+		{"system roots", server.URL, "server_name: localhost" + client, "certificate"},
+		{"wrong server name", server.URL, "ca: |\n    " + ca + "\n  server_name: auth.example" + client, "certificate"},
+		{"no client certificate", server.URL, "ca: |\n    " + ca + "\n  server_name: localhost", "certificate required"},
+		{"min version", tls12.URL, "min_version: \"1.3\"\n  insecure_skip_verify: true" + client, "protocol version"},
+	}
+
+	SetAllowInsecureTLS(true)
+	defer SetAllowInsecureTLS(false)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf := newRequestFilter(t, "filter_name: tls\nremote_server: "+tt.url+"/check\ntls:\n  "+tt.tls)
+			ok, err := rf.Process(newFilterContext(`{}`))
+			if tt.wantErr == "" {
+				if !ok || err != nil {
+					t.Errorf("Process() = %v, %v, want pass", ok, err)
+				}
+				return
+			}
+			// the cause is kept for logs, clients only see the message
+			if ok || err == nil || !strings.Contains(fmt.Sprint(errors.Unwrap(err)), tt.wantErr) {
+				t.Errorf("Process() = %v, %v, want error containing %q", ok, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  TLSConfig
+		wantErr string
+	}{
+		{"insecure without flag", TLSConfig{InsecureSkipVerify: true}, "-allow-insecure-tls"},
+		{"bad min version", TLSConfig{MinVersion: "1.1"}, "min_version"},
+		{"bad CA", TLSConfig{CA: "not a certificate"}, "no PEM certificates"},
+		{"missing CA file", TLSConfig{CAFile: "/nonexistent/ca.crt"}, "ca_file"},
+		{"cert without key", TLSConfig{CertFile: "/tmp/tls.crt"}, "set together"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequestFilterDefaultTLS(t *testing.T) {
+	server, caPEM := newTLSServer(t, 0)
+	dir := t.TempDir()
+	certPEM, keyPEM := newTestCertificate(t)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	os.WriteFile(certFile, certPEM, 0600)
+	os.WriteFile(keyFile, keyPEM, 0600)
+
+	previous := SetDefaultTLS(&TLSConfig{CA: string(caPEM), ServerName: "localhost", CertFile: certFile, KeyFile: keyFile})
+	defer SetDefaultTLS(previous)
+
+	rf := newRequestFilter(t, "filter_name: tls\nremote_server: "+server.URL+"/check")
+	if ok, err := rf.Process(newFilterContext(`{}`)); !ok || err != nil {
+		t.Errorf("Process() = %v, %v, want pass with the default TLS settings", ok, err)
+	}
+}
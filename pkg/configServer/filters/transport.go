@@ -0,0 +1,134 @@
+package filters
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// transportPool shares HTTP transports, and their idle connections, between
+// the filters of every configuration. Transports are keyed by their
+// connection settings, filters with the same settings use the same transport.
+type transportPool struct {
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+var transports = &transportPool{transports: make(map[string]*http.Transport)}
+
+// get returns the transport for key, creating it with build on first use
+func (p *transportPool) get(key string, build func() *http.Transport) *http.Transport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t, ok := p.transports[key]; ok {
+		return t
+	}
+	t := build()
+	p.transports[key] = t
+	return t
+}
+
+func newTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   20,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		DisableCompression:    true,
+	}
+}
+
+// isIdempotent reports whether a call may be sent again after a failure
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isUnavailable reports statuses that mean the remote server could not answer
+func isUnavailable(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// backoff waits base * 2^attempt plus up to base of jitter, or until ctx is done
+func backoff(ctx context.Context, base time.Duration, attempt int) error {
+	delay := base << attempt
+	if base > 0 {
+		delay += time.Duration(rand.Int63n(int64(base)))
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CircuitBreakerConfig opens the breaker after Failures consecutive failed
+// calls. While open, calls fail immediately; after OpenFor one trial call
+// is let through and its result closes or re-opens the breaker.
+type CircuitBreakerConfig struct {
+	Failures int           `yaml:"failures"`
+	OpenFor  time.Duration `yaml:"open_for"`
+}
+
+type circuitBreaker struct {
+	config CircuitBreakerConfig
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+	now      func() time.Time
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{config: config, now: time.Now}
+}
+
+// allow reports whether a call may be sent
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.config.Failures {
+		return true
+	}
+	// open: let a single trial call through once open_for has passed
+	if b.trial || b.now().Sub(b.openedAt) < b.config.OpenFor {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// record reports the result of an allowed call
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.config.Failures {
+		b.openedAt = b.now()
+	}
+}
@@ -0,0 +1,187 @@
+package filters
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestFilterRetries(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	rf := newRequestFilter(t, `
+remote_server: "`+server.URL+`"
+method: GET
+retries: 2
+retry_backoff: 1ms
+`)
+	if ok, err := rf.Process(newFilterContext(`{}`)); !ok || err != nil {
+		t.Fatalf("Process() = %v, %v", ok, err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 calls, got %d", calls.Load())
+	}
+
+	// POST is not idempotent
+	var post RequestFilter
+	post.RemoteServer, post.Retries = server.URL, 1
+	if err := post.Validate(); err == nil || !strings.Contains(err.Error(), "idempotent") {
+		t.Errorf("Validate() error = %v, want idempotent error", err)
+	}
+}
+
+func TestRequestFilterTimeoutAndFailureMode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	closed := newRequestFilter(t, `
+remote_server: "`+server.URL+`"
+timeout: 20ms
+`)
+	if ok, err := closed.Process(newFilterContext(`{}`)); ok || err == nil {
+		t.Errorf("Process() = %v, %v, want timeout error", ok, err)
+	}
+
+	open := newRequestFilter(t, `
+remote_server: "`+server.URL+`"
+timeout: 20ms
+failure_mode: fail_open
+`)
+	if ok, err := open.Process(newFilterContext(`{}`)); !ok || err != nil {
+		t.Errorf("Process() = %v, %v, want pass with fail_open", ok, err)
+	}
+
+	// a request that cannot be built is rejected even with fail_open
+	broken := newRequestFilter(t, `
+remote_server: "`+server.URL+`/${body.missing}"
+failure_mode: fail_open
+`)
+	if ok, _ := broken.Process(newFilterContext(`{}`)); ok {
+		t.Error("expected a request without template values to be rejected")
+	}
+}
+
+func TestRequestFilterErrorMapping(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantMsg    string
+	}{
+		{"timeout", server.URL, http.StatusGatewayTimeout, "auth service timed out"},
+		{"connection", closed.URL, http.StatusServiceUnavailable, "remote server unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf := newRequestFilter(t, `
+remote_server: "`+tt.url+`"
+timeout: 20ms
+response:
+  errors:
+    - error: [timeout]
+      respond_with: 504
+      message: "auth service timed out"
+    - error: [connection, circuit_open]
+`)
+			_, err := rf.Process(newFilterContext(`{}`))
+			var fe *FilterError
+			if !errors.As(err, &fe) || fe.Status != tt.wantStatus || fe.Message != tt.wantMsg {
+				t.Fatalf("Process() error = %#v, want %d %q", err, tt.wantStatus, tt.wantMsg)
+			}
+		})
+	}
+
+	// without a mapping the client gets neither the cause nor the address
+	rf := newRequestFilter(t, `remote_server: "`+closed.URL+`"`)
+	_, err := rf.Process(newFilterContext(`{}`))
+	var fe *FilterError
+	if !errors.As(err, &fe) || fe.Status != http.StatusForbidden || strings.Contains(fe.Message, strings.TrimPrefix(closed.URL, "http://")) {
+		t.Errorf("Process() error = %#v, want 403 without the address", err)
+	}
+
+	invalid := RequestFilter{RemoteServer: server.URL, Response: &ResponseRules{Errors: []ErrorMapping{{Error: []string{"dns"}}}}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Validate() accepted an unknown error kind")
+	}
+}
+
+func TestRequestFilterCircuitBreaker(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	rf := newRequestFilter(t, `
+remote_server: "`+server.URL+`"
+circuit_breaker:
+  failures: 2
+  open_for: 1m
+`)
+	for i := 0; i < 4; i++ {
+		if ok, _ := rf.Process(newFilterContext(`{}`)); ok {
+			t.Fatalf("call %d passed", i)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected the breaker to stop calls after 2 failures, got %d calls", calls.Load())
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(CircuitBreakerConfig{Failures: 1, OpenFor: time.Minute})
+	b.now = func() time.Time { return now }
+
+	b.record(false)
+	if b.allow() {
+		t.Fatal("breaker should be open")
+	}
+
+	now = now.Add(time.Minute)
+	if !b.allow() {
+		t.Fatal("breaker should allow a trial call")
+	}
+	if b.allow() {
+		t.Fatal("breaker should allow only one trial call")
+	}
+	b.record(true)
+	if !b.allow() || !b.allow() {
+		t.Error("breaker should be closed after a successful trial")
+	}
+}
+
+func TestTransportPool(t *testing.T) {
+	pool := &transportPool{transports: make(map[string]*http.Transport)}
+	a := pool.get("a", newTransport)
+	if pool.get("a", newTransport) != a {
+		t.Error("expected the same transport for the same key")
+	}
+	if pool.get("b", newTransport) == a {
+		t.Error("expected a new transport for another key")
+	}
+}
@@ -0,0 +1,336 @@
+package filters
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// SecretReader reads the data of a Kubernetes Secret
+type SecretReader interface {
+	SecretData(namespace, name string) (map[string][]byte, error)
+}
+
+var (
+	secretMu         sync.RWMutex
+	secretReader     SecretReader
+	secretNamespaces []string
+)
+
+// SetSecretReader sets the reader of the Secrets holding upstream credentials
+func SetSecretReader(r SecretReader) {
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	secretReader = r
+}
+
+func getSecretReader() SecretReader {
+	secretMu.RLock()
+	defer secretMu.RUnlock()
+	return secretReader
+}
+
+// SetSecretNamespaces sets the namespaces whose Secrets and policy
+// ConfigMaps every AuthRoute may reference, besides its own namespace
+func SetSecretNamespaces(namespaces []string) {
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	secretNamespaces = namespaces
+}
+
+// secretAllowed reports whether a filter of an AuthRoute in namespace may
+// read Secrets of secretNamespace. Routes of the configuration may read any.
+func secretAllowed(namespace, secretNamespace string) bool {
+	if namespace == "" || namespace == secretNamespace {
+		return true
+	}
+	secretMu.RLock()
+	defer secretMu.RUnlock()
+	return containsString(secretNamespaces, secretNamespace)
+}
+
+// SecretKeyRef selects a key of a Secret. The namespace may be left out in
+// AuthRoutes, it defaults to the namespace of the AuthRoute.
+type SecretKeyRef struct {
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+	Key       string `yaml:"key,omitempty"`
+}
+
+func (r SecretKeyRef) String() string {
+	return r.Namespace + "/" + r.Name
+}
+
+// UpstreamAuth authenticates the calls of a RequestFilter to its remote server.
+//
+//	bearer  Authorization: Bearer <secret key>
+//	oauth2  client_credentials token from token_url, the secret key is the client secret
+//	hmac    signs the request with the secret key, see sign
+//	mtls    client certificate from the tls.crt and tls.key keys of the secret
+//
+// Secrets are read again every refresh_interval (1m by default), so rotated
+// credentials are picked up without a configuration push. AuthRoutes may only
+// reference Secrets of their own namespace and of -secret-namespaces.
+type UpstreamAuth struct {
+	Type   string       `yaml:"type"`
+	Secret SecretKeyRef `yaml:"secret"`
+
+	// oauth2
+	TokenURL string   `yaml:"token_url,omitempty"`
+	ClientID string   `yaml:"client_id,omitempty"`
+	Scopes   []string `yaml:"scopes,omitempty"`
+
+	// hmac, X-Signature and X-Timestamp by default
+	SignatureHeader string `yaml:"signature_header,omitempty"`
+	TimestampHeader string `yaml:"timestamp_header,omitempty"`
+
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+
+	secret *secretCache
+	// namespace of the AuthRoute of the filter, see Spec.Namespace
+	namespace string
+	// tokenTransport calls token_url with the TLS settings of the filter
+	tokenTransport http.RoundTripper
+
+	mu           sync.Mutex
+	clientSecret string
+	tokenSource  oauth2.TokenSource
+}
+
+// Validate checks the mode and reads the secret once, so a missing secret rejects the configuration
+func (a *UpstreamAuth) Validate() error {
+	if a.Secret.Namespace == "" {
+		a.Secret.Namespace = a.namespace
+	}
+	if a.Secret.Namespace == "" || a.Secret.Name == "" {
+		return fmt.Errorf("secret namespace and name are required")
+	}
+	if !secretAllowed(a.namespace, a.Secret.Namespace) {
+		return fmt.Errorf("secret %s is outside namespace %s and -secret-namespaces", a.Secret, a.namespace)
+	}
+
+	switch a.Type {
+	case "bearer", "hmac":
+		if a.Secret.Key == "" {
+			return fmt.Errorf("%s requires a secret key", a.Type)
+		}
+	case "oauth2":
+		if a.Secret.Key == "" || a.TokenURL == "" || a.ClientID == "" {
+			return fmt.Errorf("oauth2 requires token_url, client_id and a secret key")
+		}
+	case "mtls":
+	default:
+		return fmt.Errorf("unknown auth type %q", a.Type)
+	}
+
+	if a.RefreshInterval < 0 {
+		return fmt.Errorf("refresh_interval must not be negative")
+	}
+	interval := a.RefreshInterval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	a.secret = &secretCache{ref: a.Secret, interval: interval, now: time.Now}
+
+	if a.Type == "mtls" {
+		_, err := a.clientCertificate(nil)
+		return err
+	}
+	_, err := a.secretValue()
+	return err
+}
+
+func (a *UpstreamAuth) secretValue() ([]byte, error) {
+	data, err := a.secret.get()
+	if err != nil {
+		return nil, err
+	}
+	value, ok := data[a.Secret.Key]
+	if !ok || len(value) == 0 {
+		return nil, fmt.Errorf("secret %s has no %q key", a.Secret, a.Secret.Key)
+	}
+	return bytes.TrimSpace(value), nil
+}
+
+// apply authenticates the request, body is the request body already set on req
+func (a *UpstreamAuth) apply(req *http.Request, body []byte) error {
+	switch a.Type {
+	case "bearer":
+		token, err := a.secretValue()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+string(token))
+
+	case "oauth2":
+		ts, err := a.oauth2TokenSource()
+		if err != nil {
+			return err
+		}
+		token, err := ts.Token()
+		if err != nil {
+			return fmt.Errorf("failed to get oauth2 token: %v", err)
+		}
+		token.SetAuthHeader(req)
+
+	case "hmac":
+		key, err := a.secretValue()
+		if err != nil {
+			return err
+		}
+		a.sign(req, body, key, time.Now())
+	}
+	return nil
+}
+
+// oauth2TokenSource caches tokens until they expire, a rotated client secret
+// starts a new token source
+func (a *UpstreamAuth) oauth2TokenSource() (oauth2.TokenSource, error) {
+	secret, err := a.secretValue()
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.tokenSource == nil || a.clientSecret != string(secret) {
+		config := clientcredentials.Config{
+			ClientID:     a.ClientID,
+			ClientSecret: string(secret),
+			TokenURL:     a.TokenURL,
+			Scopes:       a.Scopes,
+		}
+		transport := a.tokenTransport
+		if transport == nil {
+			transport = transports.get("", newTransport)
+		}
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: transport,
+		})
+		a.tokenSource = config.TokenSource(ctx)
+		a.clientSecret = string(secret)
+	}
+	return a.tokenSource, nil
+}
+
+// sign sets the timestamp header and the signature header to
+// "sha256=" + hex(HMAC-SHA256(key, method \n request URI \n timestamp \n hex(SHA256(body))))
+func (a *UpstreamAuth) sign(req *http.Request, body []byte, key []byte, now time.Time) {
+	signatureHeader, timestampHeader := a.SignatureHeader, a.TimestampHeader
+	if signatureHeader == "" {
+		signatureHeader = "X-Signature"
+	}
+	if timestampHeader == "" {
+		timestampHeader = "X-Timestamp"
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", req.Method, req.URL.RequestURI(), timestamp, hex.EncodeToString(bodyHash[:]))
+
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+// clientCertificate loads the mTLS certificate from the secret on every handshake
+func (a *UpstreamAuth) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	data, err := a.secret.get()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(data["tls.crt"], data["tls.key"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate in secret %s: %v", a.Secret, err)
+	}
+	return &cert, nil
+}
+
+// tokenTransport returns the pooled transport for token_url with the TLS
+// settings of the filter. server_name names the remote server, the token
+// endpoint is checked against its own host.
+func tokenTransport(settings *TLSConfig) (*http.Transport, error) {
+	if settings == nil {
+		return transports.get("", newTransport), nil
+	}
+	tlsConfig := *settings
+	tlsConfig.ServerName = ""
+	config, key, err := tlsConfig.build()
+	if err != nil {
+		return nil, fmt.Errorf("tls: %v", err)
+	}
+	return transports.get(key, func() *http.Transport {
+		t := newTransport()
+		t.TLSClientConfig = config
+		return t
+	}), nil
+}
+
+// transportKey separates the pooled transports of filters with client certificates
+func (a *UpstreamAuth) transportKey() string {
+	if a == nil || a.Type != "mtls" {
+		return ""
+	}
+	return "mtls:" + a.Secret.String()
+}
+
+// secretCache reads a Secret again once interval has passed. If a refresh
+// fails, the last data is used until the Secret can be read again.
+type secretCache struct {
+	ref      SecretKeyRef
+	interval time.Duration
+	now      func() time.Time
+
+	mu         sync.Mutex
+	data       map[string][]byte
+	fetched    time.Time
+	refreshing bool
+}
+
+// get reads the Secret outside the lock. While one request refreshes it,
+// the others use the last data.
+func (s *secretCache) get() (map[string][]byte, error) {
+	s.mu.Lock()
+	if s.data != nil && (s.refreshing || s.now().Sub(s.fetched) < s.interval) {
+		data := s.data
+		s.mu.Unlock()
+		return data, nil
+	}
+	s.refreshing = true
+	s.mu.Unlock()
+
+	reader := getSecretReader()
+	var data map[string][]byte
+	var err error
+	if reader == nil {
+		err = fmt.Errorf("secret %s: no kubernetes client configured", s.ref)
+	} else {
+		data, err = reader.SecretData(s.ref.Namespace, s.ref.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshing = false
+	if err != nil {
+		if s.data != nil {
+			log.Warningf("Using cached secret %s: %v", s.ref, err)
+			return s.data, nil
+		}
+		return nil, err
+	}
+	s.data, s.fetched = data, s.now()
+	return data, nil
+}
@@ -0,0 +1,256 @@
+package filters
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type fakeSecretReader struct {
+	mu      sync.Mutex
+	secrets map[string]map[string][]byte
+}
+
+func (r *fakeSecretReader) SecretData(namespace, name string) (map[string][]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.secrets[namespace+"/"+name]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s not found", namespace, name)
+	}
+	return data, nil
+}
+
+func (r *fakeSecretReader) set(key string, data map[string][]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.secrets[key] = data
+}
+
+func TestUpstreamAuthBearerRefresh(t *testing.T) {
+	reader := &fakeSecretReader{secrets: map[string]map[string][]byte{
+		"auth/filter-token": {"token": []byte("first\n")},
+	}}
+	SetSecretReader(reader)
+	defer SetSecretReader(nil)
+
+	server, captured := newCaptureServer(t, `{}`)
+	rf := newRequestFilter(t, `
+remote_server: "`+server.URL+`"
+method: GET
+auth:
+  type: bearer
+  secret: {namespace: auth, name: filter-token, key: token}
+  refresh_interval: 1m
+`)
+	now := time.Now()
+	rf.Auth.secret.now = func() time.Time { return now }
+
+	if ok, err := rf.Process(newFilterContext(`{}`)); !ok || err != nil {
+		t.Fatalf("Process() = %v, %v", ok, err)
+	}
+	if got := captured.headers.Get("Authorization"); got != "Bearer first" {
+		t.Errorf("unexpected Authorization %q", got)
+	}
+
+	// rotated secrets are read after refresh_interval
+	reader.set("auth/filter-token", map[string][]byte{"token": []byte("second")})
+	now = now.Add(2 * time.Minute)
+	rf.Process(newFilterContext(`{}`))
+	if got := captured.headers.Get("Authorization"); got != "Bearer second" {
+		t.Errorf("rotated token was not used: %q", got)
+	}
+
+	var missing RequestFilter
+	missing.RemoteServer = server.URL
+	missing.Auth = &UpstreamAuth{Type: "bearer", Secret: SecretKeyRef{Namespace: "auth", Name: "missing", Key: "token"}}
+	if err := missing.Validate(); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Validate() error = %v, want missing secret", err)
+	}
+}
+
+func TestUpstreamAuthOAuth2(t *testing.T) {
+	SetSecretReader(&fakeSecretReader{secrets: map[string]map[string][]byte{
+		"auth/idp": {"client_secret": []byte("s3cret")},
+	}})
+	defer SetSecretReader(nil)
+
+	var tokenCalls atomic.Int32
+	idp := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenCalls.Add(1)
+		r.ParseForm()
+		user, pass, _ := r.BasicAuth()
+		if r.Form.Get("grant_type") != "client_credentials" || user != "openauth" || pass != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "idp-token", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	defer idp.Close()
+	idpCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: idp.Certificate().Raw})
+
+	// the token endpoint is trusted through the tls: block of the filter
+	server, captured := newCaptureServer(t, `{}`)
+	rf := newRequestFilter(t, `
+remote_server: "`+server.URL+`"
+method: GET
+tls:
+  ca: |
+    `+strings.ReplaceAll(strings.TrimSpace(string(idpCA)), "\n", "\n    ")+`
+auth:
+  type: oauth2
+  token_url: "`+idp.URL+`"
+  client_id: openauth
+  scopes: [filters]
+  secret: {namespace: auth, name: idp, key: client_secret}
+`)
+	for i := 0; i < 3; i++ {
+		if ok, err := rf.Process(newFilterContext(`{}`)); !ok || err != nil {
+			t.Fatalf("Process() = %v, %v", ok, err)
+		}
+	}
+	if got := captured.headers.Get("Authorization"); got != "Bearer idp-token" {
+		t.Errorf("unexpected Authorization %q", got)
+	}
+	if tokenCalls.Load() != 1 {
+		t.Errorf("Expected the token to be reused, got %d token calls", tokenCalls.Load())
+	}
+}
+
+func TestUpstreamAuthSecretNamespace(t *testing.T) {
+	SetSecretReader(&fakeSecretReader{secrets: map[string]map[string][]byte{
+		"team-a/token":  {"token": []byte("a")},
+		"shared/token":  {"token": []byte("shared")},
+		"default/token": {"token": []byte("cluster")},
+	}})
+	defer SetSecretReader(nil)
+	defer SetSecretNamespaces(nil)
+	SetSecretNamespaces([]string{"shared"})
+
+	build := func(namespace, secret string) error {
+		spec, err := NewSpec("request", map[string]interface{}{
+			"remote_server": "http://auth.example",
+			"auth":          map[string]interface{}{"type": "bearer", "secret": yamlMap(secret)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec.Namespace = namespace
+		_, err = Build(&spec)
+		return err
+	}
+
+	for _, tt := range []struct {
+		namespace, secret string
+		wantErr           bool
+	}{
+		{"team-a", "{namespace: team-a, name: token, key: token}", false},
+		{"team-a", "{name: token, key: token}", false},
+		{"team-a", "{namespace: shared, name: token, key: token}", false},
+		{"team-a", "{namespace: default, name: token, key: token}", true},
+		{"", "{namespace: default, name: token, key: token}", false},
+		{"", "{name: token, key: token}", true},
+	} {
+		if err := build(tt.namespace, tt.secret); (err != nil) != tt.wantErr {
+			t.Errorf("route in %q with secret %s: Build() error = %v, want error %v", tt.namespace, tt.secret, err, tt.wantErr)
+		}
+	}
+}
+
+func yamlMap(data string) map[string]interface{} {
+	var m map[string]interface{}
+	yaml.Unmarshal([]byte(data), &m)
+	return m
+}
+
+func TestUpstreamAuthHMAC(t *testing.T) {
+	SetSecretReader(&fakeSecretReader{secrets: map[string]map[string][]byte{
+		"auth/hmac": {"key": []byte("signing-key")},
+	}})
+	defer SetSecretReader(nil)
+
+	server, captured := newCaptureServer(t, `{}`)
+	rf := newRequestFilter(t, `
+remote_server: "`+server.URL+`/check?x=1"
+fields_to_send: [username]
+auth:
+  type: hmac
+  secret: {namespace: auth, name: hmac, key: key}
+`)
+	if ok, err := rf.Process(newFilterContext(`{"username": "alice"}`)); !ok || err != nil {
+		t.Fatalf("Process() = %v, %v", ok, err)
+	}
+
+	bodyHash := sha256.Sum256([]byte(captured.body))
+	mac := hmac.New(sha256.New, []byte("signing-key"))
+	fmt.Fprintf(mac, "POST\n/check?x=1\n%s\n%s", captured.headers.Get("X-Timestamp"), hex.EncodeToString(bodyHash[:]))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); captured.headers.Get("X-Signature") != want {
+		t.Errorf("Expected signature %s, got %s", want, captured.headers.Get("X-Signature"))
+	}
+}
+
+func TestUpstreamAuthMTLS(t *testing.T) {
+	certPEM, keyPEM := newTestCertificate(t)
+	SetSecretReader(&fakeSecretReader{secrets: map[string]map[string][]byte{
+		"auth/client-cert": {"tls.crt": certPEM, "tls.key": keyPEM},
+		"auth/broken":      {"tls.crt": []byte("x"), "tls.key": []byte("y")},
+	}})
+	defer SetSecretReader(nil)
+
+	auth := &UpstreamAuth{Type: "mtls", Secret: SecretKeyRef{Namespace: "auth", Name: "client-cert"}}
+	if err := auth.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	cert, err := auth.clientCertificate(nil)
+	if err != nil || len(cert.Certificate) != 1 {
+		t.Errorf("clientCertificate() = %v, %v", cert, err)
+	}
+
+	broken := &UpstreamAuth{Type: "mtls", Secret: SecretKeyRef{Namespace: "auth", Name: "broken"}}
+	if err := broken.Validate(); err == nil || !strings.Contains(err.Error(), "invalid client certificate") {
+		t.Errorf("Validate() error = %v, want invalid certificate", err)
+	}
+}
+
+func newTestCertificate(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "openauth"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
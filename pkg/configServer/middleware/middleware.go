@@ -2,6 +2,7 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 
 	"OpenAuth/pkg/configServer/filters"
@@ -12,40 +13,56 @@ import (
 
 var log = logging.MustGetLogger("middleware")
 
-// CreateRequestFilterMiddleware는 RequestFilter를 처리하는 Gin 미들웨어를 생성합니다.
-func CreateRequestFilterMiddleware(filter *filters.RequestFilter) gin.HandlerFunc {
+// CreateFilterMiddleware는 모든 종류의 Filter를 처리하는 Gin 미들웨어를 생성합니다.
+func CreateFilterMiddleware(filter filters.Filter) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		log.Info("Starting RequestFilter middleware")
+		log.Infof("Starting %T middleware", filter)
 
 		ret, err := filter.Process(c)
 		if ret && (err == nil) {
 			log.Info("Filter passed - continuing to next middleware")
 			c.Next()
-		} else {
-			log.Warning("Filter failed - returning 403")
-			// JSON 응답과 함께 403 상태 코드 반환
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
-				"error": err.Error(),
-			})
+			if observer, ok := filter.(filters.ChainObserver); ok {
+				observer.After(c)
+			}
+			return
 		}
+
+		// 필터가 지정한 응답, 예: 429와 Retry-After
+		var filterErr *filters.FilterError
+		if errors.As(err, &filterErr) && filterErr.Status != 0 {
+			log.Warningf("Filter failed - returning %d", filterErr.Status)
+			for name, values := range filterErr.Headers {
+				for _, value := range values {
+					c.Writer.Header().Add(name, value)
+				}
+			}
+			response := gin.H{"error": filterErr.Message}
+			if filterErr.Details != nil {
+				response["details"] = filterErr.Details
+			}
+			c.AbortWithStatusJSON(filterErr.Status, response)
+			return
+		}
+
+		log.Warning("Filter failed - returning 403")
+		message := "request not allowed"
+		if err != nil {
+			message = err.Error()
+		}
+		// JSON 응답과 함께 403 상태 코드 반환
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": message,
+		})
 	}
 }
 
+// CreateRequestFilterMiddleware는 RequestFilter를 처리하는 Gin 미들웨어를 생성합니다.
+func CreateRequestFilterMiddleware(filter *filters.RequestFilter) gin.HandlerFunc {
+	return CreateFilterMiddleware(filter)
+}
+
 // CreateConditionFilterMiddleware는 ConditionFilter를 처리하는 Gin 미들웨어를 생성합니다.
 func CreateConditionFilterMiddleware(filter *filters.ConditionFilter) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		log.Info("Starting RequestFilter middleware")
-
-		ret, err := filter.Process(c)
-		if ret && (err == nil) {
-			log.Info("Filter passed - continuing to next middleware")
-			c.Next()
-		} else {
-			log.Warning("Filter failed - returning 403")
-			// JSON 응답과 함께 403 상태 코드 반환
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
-				"error": err.Error(),
-			})
-		}
-	}
+	return CreateFilterMiddleware(filter)
 }
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"OpenAuth/pkg/configServer/filters"
+
+	"github.com/gin-gonic/gin"
+)
+
+type staticFilter struct {
+	ok  bool
+	err error
+}
+
+func (f staticFilter) Process(c *gin.Context) (bool, error) {
+	return f.ok, f.err
+}
+
+func TestCreateFilterMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		filter     staticFilter
+		wantStatus int
+		wantHeader string
+		wantBody   string
+	}{
+		{"pass", staticFilter{ok: true}, http.StatusOK, "", ""},
+		{"error", staticFilter{err: errors.New("denied")}, http.StatusForbidden, "", `{"error":"denied"}`},
+		{"filter error", staticFilter{err: &filters.FilterError{
+			Status:  http.StatusTooManyRequests,
+			Message: "slow down",
+			Headers: http.Header{"Retry-After": []string{"30"}},
+		}}, http.StatusTooManyRequests, "30", `{"error":"slow down"}`},
+		{"details", staticFilter{err: &filters.FilterError{
+			Status:  http.StatusBadRequest,
+			Message: "request body does not match the schema",
+			Details: []filters.FieldError{{Field: "/username", Message: "is required"}},
+		}}, http.StatusBadRequest, "",
+			`{"details":[{"field":"/username","message":"is required"}],"error":"request body does not match the schema"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := gin.New()
+			engine.GET("/", CreateFilterMiddleware(tt.filter), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantHeader {
+				t.Errorf("Expected Retry-After %q, got %q", tt.wantHeader, got)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("Expected body %s, got %s", tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBruteForceCountsRejections(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	spec, err := filters.NewSpec("brute_force", &filters.BruteForceFilter{
+		FilterName:  "middleware-test",
+		Username:    &filters.KeyField{Field: "body", Key: "username"},
+		PerUsername: &filters.BruteForceLimits{LockoutAfter: 3, Lockout: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard, err := filters.Build(&spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer filters.ClearLockout(filters.LockoutUsername, "mallory")
+
+	engine := gin.New()
+	engine.POST("/signin",
+		CreateFilterMiddleware(guard),
+		CreateFilterMiddleware(staticFilter{err: errors.New("wrong password")}),
+		func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i, want := range []int{403, 403, 403, 429} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/signin", strings.NewReader(`{"username": "mallory"}`)))
+		if w.Code != want {
+			t.Errorf("attempt %d: status = %d, want %d", i, w.Code, want)
+		}
+	}
+}
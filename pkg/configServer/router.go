@@ -4,21 +4,44 @@ package configServer
 import (
 	"OpenAuth/pkg/configServer/filters"
 	"OpenAuth/pkg/configServer/middleware"
+	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
 	Routes    []RouteConfig `yaml:"routes"`
 	JWTConfig JWTConfig     `yaml:"jwt_config"`
+
+	// TLS is used by filters without their own tls: block
+	TLS *filters.TLSConfig `yaml:"tls,omitempty"`
+
+	// ClientIP sets the proxies trusted to report the client address
+	ClientIP *ClientIPConfig `yaml:"client_ip,omitempty"`
 }
 
 type RouteConfig struct {
-	Path            string                   `yaml:"path"`
-	Method          string                   `yaml:"method"`
-	RequestFilters  []filters.RequestFilter  `yaml:"request_filters"`
+	Path   string `yaml:"path"`
+	Method string `yaml:"method"`
+
+	// Filters is the ordered list of filters of any registered type
+	Filters []filters.Spec `yaml:"filters,omitempty"`
+
+	// request_filters and condition_filter are kept for older configurations,
+	// they run before the filters list
+	RequestFilters  []filters.RequestFilter  `yaml:"request_filters,omitempty"`
 	ConditionFilter *filters.ConditionFilter `yaml:"condition_filter,omitempty"`
-	HandlerType     string                   `yaml:"handler_type"`
+
+	HandlerType string `yaml:"handler_type"`
+
+	// PolicyFilters are the filters of the AuthPolicies of an AuthRoute,
+	// they run before every filter of the route
+	PolicyFilters []filters.Spec `yaml:"-"`
+	// Namespace of the AuthRoute, it limits the Secrets its filters may read
+	Namespace string `yaml:"-"`
 }
 
 type JWTConfig struct {
@@ -27,6 +50,102 @@ type JWTConfig struct {
 	RequiredFields []string `yaml:"required_fields"`
 }
 
+// LoadConfig parses a configuration YAML document
+func LoadConfig(data []byte) (*Config, error) {
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %v", err)
+	}
+	return &config, nil
+}
+
+// Validate checks the parts of a route that do not depend on the server
+func (r RouteConfig) Validate() error {
+	if !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("path %q must start with /", r.Path)
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		return fmt.Errorf("unsupported method %q", r.Method)
+	}
+	if r.HandlerType == "" {
+		return fmt.Errorf("handler_type is required")
+	}
+
+	// request_filters and condition_filter run before the filters list
+	if len(r.RequestFilters) > 0 || r.ConditionFilter != nil {
+		for _, spec := range r.Filters {
+			if spec.Type == "brute_force" {
+				return fmt.Errorf("brute_force would not count the failures of request_filters and condition_filter, which run before it; move them into filters after it")
+			}
+		}
+	}
+
+	// the body is validated before any remote call, also of the AuthPolicies
+	upstream := len(r.RequestFilters) > 0
+	for _, spec := range r.PolicyFilters {
+		upstream = upstream || spec.Type == "request" || spec.Type == "grpc"
+	}
+	if upstream {
+		for _, spec := range r.Filters {
+			if spec.Type == "schema" {
+				return fmt.Errorf("schema would validate the body after the remote calls of request_filters or AuthPolicies, which run before the filters list")
+			}
+		}
+	}
+	return nil
+}
+
+// FilterSpecs returns every filter of the route in execution order:
+// policy filters, request_filters, condition_filter, then the filters list
+func (r RouteConfig) FilterSpecs() ([]filters.Spec, error) {
+	specs := make([]filters.Spec, 0, len(r.PolicyFilters)+len(r.RequestFilters)+1+len(r.Filters))
+	specs = append(specs, r.PolicyFilters...)
+
+	for _, rf := range r.RequestFilters {
+		spec, err := filters.NewSpec("request", rf)
+		if err != nil {
+			return nil, fmt.Errorf("request filter %q: %v", rf.FilterName, err)
+		}
+		specs = append(specs, spec)
+	}
+
+	if r.ConditionFilter != nil {
+		spec, err := filters.NewSpec("condition", r.ConditionFilter)
+		if err != nil {
+			return nil, fmt.Errorf("condition filter %q: %v", r.ConditionFilter.FilterName, err)
+		}
+		specs = append(specs, spec)
+	}
+
+	specs = append(specs, r.Filters...)
+	for i := range specs {
+		specs[i].Namespace = r.Namespace
+		specs[i].Route = r.Method + " " + r.Path
+	}
+	return specs, nil
+}
+
+// BuildFilters creates and validates every filter of the route in execution order
+func (r RouteConfig) BuildFilters() ([]filters.Filter, error) {
+	specs, err := r.FilterSpecs()
+	if err != nil {
+		return nil, err
+	}
+
+	built := make([]filters.Filter, 0, len(specs))
+	for i := range specs {
+		filter, err := filters.Build(&specs[i])
+		if err != nil {
+			return nil, err
+		}
+		built = append(built, filter)
+	}
+	return built, nil
+}
+
 // this function is used on mockup server not for production
 func SetupRouter(config Config) *gin.Engine {
 	router := gin.New()
@@ -34,14 +153,13 @@ func SetupRouter(config Config) *gin.Engine {
 	for _, route := range config.Routes {
 		handlers := make([]gin.HandlerFunc, 0)
 
-		// RequestFilter 처리
-		for _, rf := range route.RequestFilters {
-			handlers = append(handlers, middleware.CreateRequestFilterMiddleware(&rf))
+		// 잘못된 필터 설정을 가진 route는 등록하지 않음
+		routeFilters, err := route.BuildFilters()
+		if err != nil {
+			continue
 		}
-
-		// ConditionFilter 처리
-		if route.ConditionFilter != nil {
-			handlers = append(handlers, middleware.CreateConditionFilterMiddleware(route.ConditionFilter))
+		for _, filter := range routeFilters {
+			handlers = append(handlers, middleware.CreateFilterMiddleware(filter))
 		}
 
 		router.Handle(route.Method, route.Path, handlers...)
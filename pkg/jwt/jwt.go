@@ -124,3 +124,21 @@ func (m *JWTManager) ValidateToken(tokenStr string) (*CustomClaims, error) {
 	log.Printf("Token is valid. Time since issued: %v, time until expiration: %v", currentTime.Sub(claims.IssuedAt.Time), claims.ExpiresAt.Time.Sub(currentTime))
 	return claims, nil
 }
+
+// ParseClaims는 토큰의 서명과 유효 기간을 검증하고 모든 클레임을 map으로 반환합니다.
+func (m *JWTManager) ParseClaims(tokenStr string) (map[string]interface{}, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(
+		tokenStr,
+		claims,
+		func(token *jwt.Token) (interface{}, error) {
+			return m.SecretKey, nil
+		},
+		jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	return claims, nil
+}
@@ -0,0 +1,123 @@
+package k8sQuery
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// ClientOptions selects how a Kubernetes client reaches the api-server.
+//
+// Resolution order when building the rest config:
+//  1. InCluster forces the in-cluster ServiceAccount config
+//  2. Kubeconfig, the KUBECONFIG environment variable or Context selects a kubeconfig
+//  3. the in-cluster config when running inside a pod
+//  4. ~/.kube/config
+type ClientOptions struct {
+	Kubeconfig string // explicit kubeconfig path
+	Context    string // kubeconfig context, defaults to current-context
+	InCluster  bool   // always use the in-cluster config
+
+	// Impersonation, equivalent to kubectl --as / --as-group
+	ImpersonateUser   string
+	ImpersonateGroups []string
+}
+
+// AddFlags registers the client selection flags shared by OpenAuth and oauthctl
+func (o *ClientOptions) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Kubeconfig, "kubeconfig", "", "Path to a kubeconfig file (defaults to $KUBECONFIG, in-cluster config, then ~/.kube/config)")
+	fs.StringVar(&o.Context, "context", "", "Kubeconfig context to use")
+	fs.BoolVar(&o.InCluster, "in-cluster", false, "Always use the in-cluster ServiceAccount config")
+	fs.StringVar(&o.ImpersonateUser, "as", "", "Username to impersonate for Kubernetes API calls")
+	fs.Var((*stringSliceFlag)(&o.ImpersonateGroups), "as-group", "Group to impersonate, can be repeated")
+}
+
+// stringSliceFlag collects a repeatable string flag
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// NewRestConfig builds a rest.Config from the given options
+func NewRestConfig(opts ClientOptions) (*rest.Config, error) {
+	config, err := loadRestConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.ImpersonateUser != "" || len(opts.ImpersonateGroups) > 0 {
+		config.Impersonate = rest.ImpersonationConfig{
+			UserName: opts.ImpersonateUser,
+			Groups:   opts.ImpersonateGroups,
+		}
+	}
+	return config, nil
+}
+
+func loadRestConfig(opts ClientOptions) (*rest.Config, error) {
+	if opts.InCluster {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get in-cluster config: %v", err)
+		}
+		return config, nil
+	}
+
+	useKubeconfig := opts.Kubeconfig != "" || opts.Context != "" ||
+		os.Getenv(clientcmd.RecommendedConfigPathEnvVar) != ""
+
+	// inside a pod without any kubeconfig hint, prefer the ServiceAccount
+	if !useKubeconfig {
+		if config, err := rest.InClusterConfig(); err == nil {
+			return config, nil
+		}
+	}
+
+	// honors KUBECONFIG and falls back to ~/.kube/config
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = opts.Kubeconfig
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: opts.Context}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build config: %v", err)
+	}
+	return config, nil
+}
+
+// NewK8sClientWithOptions creates a new Kubernetes client from the given options
+func NewK8sClientWithOptions(opts ClientOptions) (*K8sClient, error) {
+	config, err := NewRestConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clientset: %v", err)
+	}
+
+	return &K8sClient{clientset: clientset, config: config}, nil
+}
+
+// Clientset returns the underlying typed clientset
+func (c *K8sClient) Clientset() *kubernetes.Clientset {
+	return c.clientset
+}
+
+// RestConfig returns the rest config the client was built from
+func (c *K8sClient) RestConfig() *rest.Config {
+	return c.config
+}
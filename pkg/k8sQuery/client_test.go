@@ -0,0 +1,120 @@
+package k8sQuery
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKubeconfig = `
+apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+  - name: dev
+    cluster:
+      server: https://dev.example:6443
+  - name: prod
+    cluster:
+      server: https://prod.example:6443
+users:
+  - name: admin
+    user:
+      token: abc
+contexts:
+  - name: dev
+    context:
+      cluster: dev
+      user: admin
+  - name: prod
+    context:
+      cluster: prod
+      user: admin
+`
+
+func writeKubeconfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatalf("failed to write kubeconfig: %v", err)
+	}
+	return path
+}
+
+func TestNewRestConfig(t *testing.T) {
+	path := writeKubeconfig(t)
+
+	tests := []struct {
+		name     string
+		opts     ClientOptions
+		env      string
+		wantHost string
+	}{
+		{
+			name:     "explicit kubeconfig uses current-context",
+			opts:     ClientOptions{Kubeconfig: path},
+			wantHost: "https://dev.example:6443",
+		},
+		{
+			name:     "explicit context",
+			opts:     ClientOptions{Kubeconfig: path, Context: "prod"},
+			wantHost: "https://prod.example:6443",
+		},
+		{
+			name:     "KUBECONFIG environment variable",
+			opts:     ClientOptions{Context: "prod"},
+			env:      path,
+			wantHost: "https://prod.example:6443",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KUBECONFIG", tt.env)
+
+			config, err := NewRestConfig(tt.opts)
+			if err != nil {
+				t.Fatalf("NewRestConfig() error = %v", err)
+			}
+			if config.Host != tt.wantHost {
+				t.Errorf("Expected host %s, got %s", tt.wantHost, config.Host)
+			}
+		})
+	}
+}
+
+func TestNewRestConfigImpersonation(t *testing.T) {
+	path := writeKubeconfig(t)
+
+	config, err := NewRestConfig(ClientOptions{
+		Kubeconfig:        path,
+		ImpersonateUser:   "system:serviceaccount:default:oauth-configurator",
+		ImpersonateGroups: []string{"system:serviceaccounts"},
+	})
+	if err != nil {
+		t.Fatalf("NewRestConfig() error = %v", err)
+	}
+	if config.Impersonate.UserName != "system:serviceaccount:default:oauth-configurator" {
+		t.Errorf("unexpected impersonated user: %s", config.Impersonate.UserName)
+	}
+	if len(config.Impersonate.Groups) != 1 || config.Impersonate.Groups[0] != "system:serviceaccounts" {
+		t.Errorf("unexpected impersonated groups: %v", config.Impersonate.Groups)
+	}
+}
+
+func TestNewRestConfigUnknownContext(t *testing.T) {
+	path := writeKubeconfig(t)
+
+	if _, err := NewRestConfig(ClientOptions{Kubeconfig: path, Context: "missing"}); err == nil {
+		t.Error("expected error for unknown context")
+	}
+}
+
+func TestNewRestConfigInClusterOutsidePod(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "")
+
+	if _, err := NewRestConfig(ClientOptions{InCluster: true}); err == nil {
+		t.Error("expected error when forcing in-cluster config outside a pod")
+	}
+}
@@ -0,0 +1,207 @@
+package k8sQuery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DefaultConfigMapKey is the ConfigMap key holding the routes YAML
+const DefaultConfigMapKey = "config.yaml"
+
+// ConfigSource names the ConfigMap (routes) and Secret (JWT keys)
+// OpenAuth loads its configuration from.
+type ConfigSource struct {
+	Namespace    string
+	ConfigMap    string
+	ConfigMapKey string // defaults to DefaultConfigMapKey
+	Secret       string // optional
+}
+
+// Enabled reports whether a ConfigMap source was configured
+func (s ConfigSource) Enabled() bool {
+	return s.ConfigMap != ""
+}
+
+// ConfigData is the content of a ConfigSource at one point in time
+type ConfigData struct {
+	Config []byte            // ConfigMap[ConfigMapKey]
+	Secret map[string][]byte // Secret data, nil when no Secret is configured
+}
+
+// ConfigWatcher watches a ConfigSource with client-go informers and
+// reports every change of the ConfigMap or Secret through onChange.
+type ConfigWatcher struct {
+	client   kubernetes.Interface
+	source   ConfigSource
+	onChange func(ConfigData)
+
+	mu          sync.Mutex
+	synced      bool // events before the initial sync only see a partial cache
+	lastVersion string
+
+	configMaps cache.Indexer
+	secrets    cache.Indexer
+}
+
+// NewConfigWatcher creates a watcher for the given source
+func NewConfigWatcher(client kubernetes.Interface, source ConfigSource, onChange func(ConfigData)) (*ConfigWatcher, error) {
+	if !source.Enabled() {
+		return nil, fmt.Errorf("config source requires a ConfigMap name")
+	}
+	if source.Namespace == "" {
+		source.Namespace = metav1.NamespaceDefault
+	}
+	if source.ConfigMapKey == "" {
+		source.ConfigMapKey = DefaultConfigMapKey
+	}
+
+	return &ConfigWatcher{
+		client:   client,
+		source:   source,
+		onChange: onChange,
+	}, nil
+}
+
+// WatchConfig starts a ConfigWatcher on the client. See ConfigWatcher.Run.
+func (c *K8sClient) WatchConfig(ctx context.Context, source ConfigSource, onChange func(ConfigData)) error {
+	watcher, err := NewConfigWatcher(c.clientset, source, onChange)
+	if err != nil {
+		return err
+	}
+	return watcher.Run(ctx)
+}
+
+// Run starts the informers, waits for the initial sync and delivers the
+// current configuration once. Afterwards it returns and the informers keep
+// delivering changes until ctx is cancelled.
+func (w *ConfigWatcher) Run(ctx context.Context) error {
+	watched := []cache.SharedIndexInformer{
+		newNamedInformerFactory(w.client, w.source.Namespace, w.source.ConfigMap).
+			Core().V1().ConfigMaps().Informer(),
+	}
+	w.configMaps = watched[0].GetIndexer()
+
+	if w.source.Secret != "" {
+		secretInformer := newNamedInformerFactory(w.client, w.source.Namespace, w.source.Secret).
+			Core().V1().Secrets().Informer()
+		w.secrets = secretInformer.GetIndexer()
+		watched = append(watched, secretInformer)
+	}
+
+	// indexers are assigned before any informer starts delivering events
+	synced := make([]cache.InformerSynced, 0, len(watched))
+	for _, informer := range watched {
+		if _, err := informer.AddEventHandler(w.eventHandler()); err != nil {
+			return fmt.Errorf("failed to add config event handler: %v", err)
+		}
+		go informer.Run(ctx.Done())
+		synced = append(synced, informer.HasSynced)
+	}
+
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("timed out waiting for config informers to sync")
+	}
+
+	log.Printf("Watching ConfigMap %s/%s (secret: %q)", w.source.Namespace, w.source.ConfigMap, w.source.Secret)
+	w.mu.Lock()
+	w.synced = true
+	w.mu.Unlock()
+	w.sync()
+	return nil
+}
+
+// newNamedInformerFactory restricts the informer to a single object by name
+func newNamedInformerFactory(client kubernetes.Interface, namespace, name string) informers.SharedInformerFactory {
+	return informers.NewSharedInformerFactoryWithOptions(
+		client,
+		10*time.Minute,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}),
+	)
+}
+
+func (w *ConfigWatcher) eventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { w.sync() },
+		UpdateFunc: func(interface{}, interface{}) { w.sync() },
+		DeleteFunc: func(interface{}) { w.sync() },
+	}
+}
+
+// sync reads the cached objects and calls onChange when their version changed
+func (w *ConfigWatcher) sync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.synced {
+		return
+	}
+
+	cm, err := w.getConfigMap()
+	if err != nil {
+		log.Printf("ConfigMap %s/%s not available: %v", w.source.Namespace, w.source.ConfigMap, err)
+		return
+	}
+	configYAML, ok := cm.Data[w.source.ConfigMapKey]
+	if !ok {
+		log.Printf("ConfigMap %s/%s has no key %q", w.source.Namespace, w.source.ConfigMap, w.source.ConfigMapKey)
+		return
+	}
+	version := cm.ResourceVersion
+
+	var secretData map[string][]byte
+	if w.source.Secret != "" {
+		secret, err := w.getSecret()
+		if err != nil {
+			log.Printf("Secret %s/%s not available: %v", w.source.Namespace, w.source.Secret, err)
+			return
+		}
+		secretData = secret.Data
+		version += "/" + secret.ResourceVersion
+	}
+
+	if version == w.lastVersion {
+		return
+	}
+	w.lastVersion = version
+
+	w.onChange(ConfigData{
+		Config: []byte(configYAML),
+		Secret: secretData,
+	})
+}
+
+func (w *ConfigWatcher) getConfigMap() (*corev1.ConfigMap, error) {
+	obj, exists, err := w.configMaps.GetByKey(w.source.Namespace + "/" + w.source.ConfigMap)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, apierrors.NewNotFound(corev1.Resource("configmaps"), w.source.ConfigMap)
+	}
+	return obj.(*corev1.ConfigMap), nil
+}
+
+func (w *ConfigWatcher) getSecret() (*corev1.Secret, error) {
+	obj, exists, err := w.secrets.GetByKey(w.source.Namespace + "/" + w.source.Secret)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, apierrors.NewNotFound(corev1.Resource("secrets"), w.source.Secret)
+	}
+	return obj.(*corev1.Secret), nil
+}
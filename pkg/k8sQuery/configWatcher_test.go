@@ -0,0 +1,78 @@
+package k8sQuery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigWatcher(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "openauth-config", Namespace: "auth", ResourceVersion: "1"},
+		Data:       map[string]string{DefaultConfigMapKey: "routes: []"},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "openauth-jwt", Namespace: "auth", ResourceVersion: "1"},
+		Data:       map[string][]byte{"secret_key": []byte("s3cr3t")},
+	}
+	client := fake.NewSimpleClientset(cm, secret)
+
+	changes := make(chan ConfigData, 10)
+	watcher, err := NewConfigWatcher(client, ConfigSource{
+		Namespace: "auth",
+		ConfigMap: "openauth-config",
+		Secret:    "openauth-jwt",
+	}, func(data ConfigData) {
+		changes <- data
+	})
+	if err != nil {
+		t.Fatalf("NewConfigWatcher() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := watcher.Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	initial := waitConfigData(t, changes)
+	if string(initial.Config) != "routes: []" {
+		t.Errorf("unexpected initial config: %s", initial.Config)
+	}
+	if string(initial.Secret["secret_key"]) != "s3cr3t" {
+		t.Errorf("unexpected initial secret: %v", initial.Secret)
+	}
+
+	updated := cm.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Data[DefaultConfigMapKey] = "routes:\n  - path: /verify"
+	if _, err := client.CoreV1().ConfigMaps("auth").Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update configmap: %v", err)
+	}
+
+	next := waitConfigData(t, changes)
+	if string(next.Config) != "routes:\n  - path: /verify" {
+		t.Errorf("unexpected updated config: %s", next.Config)
+	}
+}
+
+func TestConfigWatcherRequiresConfigMap(t *testing.T) {
+	if _, err := NewConfigWatcher(fake.NewSimpleClientset(), ConfigSource{}, func(ConfigData) {}); err == nil {
+		t.Error("expected error without ConfigMap name")
+	}
+}
+
+func waitConfigData(t *testing.T, changes <-chan ConfigData) ConfigData {
+	t.Helper()
+	select {
+	case data := <-changes:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for config change")
+		return ConfigData{}
+	}
+}
@@ -0,0 +1,128 @@
+package k8sQuery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Endpoint is a ready backend address of a service
+type Endpoint struct {
+	IP       string
+	Port     int32
+	PortName string
+	NodeName string
+	PodName  string
+}
+
+// Address returns "ip:port"
+func (e Endpoint) Address() string {
+	return net.JoinHostPort(e.IP, strconv.Itoa(int(e.Port)))
+}
+
+// GetReadyEndpoints returns the ready endpoints of a service from its EndpointSlices.
+// port selects a service port by name or number ("80", "http"), empty means the
+// only port of the service. Returned ports are the target ports on the pods.
+func (c *K8sClient) GetReadyEndpoints(namespace, service, port string) ([]Endpoint, error) {
+	svc, err := c.GetService(namespace, service)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service %s/%s: %v", namespace, service, err)
+	}
+
+	slices, err := c.clientset.DiscoveryV1().EndpointSlices(namespace).List(
+		context.TODO(),
+		metav1.ListOptions{LabelSelector: discoveryv1.LabelServiceName + "=" + service},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list endpointslices: %v", err)
+	}
+
+	return ReadyEndpoints(svc, slices.Items, port)
+}
+
+// ReadyEndpoints selects the ready endpoints for a service port from EndpointSlices
+func ReadyEndpoints(svc *corev1.Service, slices []discoveryv1.EndpointSlice, port string) ([]Endpoint, error) {
+	servicePort, err := FindServicePort(svc, port)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	endpoints := make([]Endpoint, 0)
+	for _, slice := range slices {
+		if slice.AddressType != discoveryv1.AddressTypeIPv4 && slice.AddressType != discoveryv1.AddressTypeIPv6 {
+			continue
+		}
+
+		// slice ports carry the service port name and the target port number
+		var targetPort *int32
+		for _, p := range slice.Ports {
+			if p.Name != nil && *p.Name == servicePort.Name && p.Port != nil {
+				targetPort = p.Port
+				break
+			}
+		}
+		if targetPort == nil {
+			continue
+		}
+
+		for _, ep := range slice.Endpoints {
+			if !isReady(ep) {
+				continue
+			}
+			for _, ip := range ep.Addresses {
+				e := Endpoint{IP: ip, Port: *targetPort, PortName: servicePort.Name}
+				if ep.NodeName != nil {
+					e.NodeName = *ep.NodeName
+				}
+				if ep.TargetRef != nil && ep.TargetRef.Kind == "Pod" {
+					e.PodName = ep.TargetRef.Name
+				}
+				// an endpoint may be listed by two slices while they are rebalanced
+				if seen[e.Address()] {
+					continue
+				}
+				seen[e.Address()] = true
+				endpoints = append(endpoints, e)
+			}
+		}
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Address() < endpoints[j].Address()
+	})
+	return endpoints, nil
+}
+
+// isReady treats an unknown ready condition as ready, as the API recommends,
+// and skips terminating endpoints
+func isReady(ep discoveryv1.Endpoint) bool {
+	if ep.Conditions.Terminating != nil && *ep.Conditions.Terminating {
+		return false
+	}
+	return ep.Conditions.Ready == nil || *ep.Conditions.Ready
+}
+
+// FindServicePort selects a service port by name or number, empty selects the only port
+func FindServicePort(svc *corev1.Service, port string) (corev1.ServicePort, error) {
+	if port == "" {
+		if len(svc.Spec.Ports) == 1 {
+			return svc.Spec.Ports[0], nil
+		}
+		return corev1.ServicePort{}, fmt.Errorf("service %s/%s has %d ports, a port is required", svc.Namespace, svc.Name, len(svc.Spec.Ports))
+	}
+
+	number, numErr := strconv.Atoi(port)
+	for _, p := range svc.Spec.Ports {
+		if p.Name == port || (numErr == nil && int(p.Port) == number) {
+			return p, nil
+		}
+	}
+	return corev1.ServicePort{}, fmt.Errorf("service %s/%s has no port %q", svc.Namespace, svc.Name, port)
+}
@@ -0,0 +1,88 @@
+package k8sQuery
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReadyEndpoints(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "user-auth", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80},
+				{Name: "grpc", Port: 9090},
+			},
+		},
+	}
+
+	ready, notReady, terminating := true, false, true
+	httpName, grpcName := "http", "grpc"
+	httpPort, grpcPort := int32(8080), int32(9090)
+
+	slices := []discoveryv1.EndpointSlice{
+		{
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Ports: []discoveryv1.EndpointPort{
+				{Name: &httpName, Port: &httpPort},
+				{Name: &grpcName, Port: &grpcPort},
+			},
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"10.0.0.2"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+					TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "user-auth-1"}},
+				{Addresses: []string{"10.0.0.3"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}},
+				{Addresses: []string{"10.0.0.4"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready, Terminating: &terminating}},
+				{Addresses: []string{"10.0.0.1"}}, // unknown readiness counts as ready
+			},
+		},
+		{
+			// duplicate of 10.0.0.2 while slices are rebalanced
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Ports:       []discoveryv1.EndpointPort{{Name: &httpName, Port: &httpPort}},
+			Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.2"}}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		port    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "by service port number", port: "80", want: []string{"10.0.0.1:8080", "10.0.0.2:8080"}},
+		{name: "by port name", port: "grpc", want: []string{"10.0.0.1:9090", "10.0.0.2:9090"}},
+		{name: "unknown port", port: "443", wantErr: true},
+		{name: "ambiguous empty port", port: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoints, err := ReadyEndpoints(svc, slices, tt.port)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadyEndpoints() error = %v", err)
+			}
+
+			got := make([]string, 0, len(endpoints))
+			for _, e := range endpoints {
+				got = append(got, e.Address())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
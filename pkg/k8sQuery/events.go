@@ -0,0 +1,38 @@
+package k8sQuery
+
+import (
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// NewEventRecorder creates a recorder writing Kubernetes Events as component
+func (c *K8sClient) NewEventRecorder(component string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: c.clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component})
+}
+
+// DeploymentReference returns an object reference to the deployment for Events.
+// When the deployment cannot be read the reference has no UID, the Events
+// are still listed by `kubectl get events` but not by `kubectl describe`.
+func (c *K8sClient) DeploymentReference(namespace, name string) *corev1.ObjectReference {
+	ref := &corev1.ObjectReference{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Namespace:  namespace,
+		Name:       name,
+	}
+
+	deployment, err := c.FindDeployment(namespace, name)
+	if err != nil {
+		log.Printf("Events will reference deployment %s/%s without UID: %v", namespace, name, err)
+		return ref
+	}
+	ref.UID = deployment.UID
+	ref.ResourceVersion = deployment.ResourceVersion
+	return ref
+}
@@ -9,10 +9,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
+	"k8s.io/client-go/rest"
 	"log"
-	"path/filepath"
 	"time"
 )
 
@@ -23,6 +21,8 @@ Implemented Functions:
 
 # Client Initialization
 - NewK8sClient(): Creates new Kubernetes client instance
+- NewK8sClientWithOptions(opts): Creates client from explicit kubeconfig, context, in-cluster config or impersonation
+- NewRestConfig(opts): Builds the shared rest.Config used by every client in OpenAuth
 
 # Deployment Operations
 - FindDeployment(namespace, name): Finds specific deployment
@@ -52,35 +52,29 @@ Implemented Functions:
 - GetServiceEndpoint(namespace, name): Gets service endpoint
 - IsServiceExist(namespace, name): Checks if service exists
 
+# Event Operations
+- NewEventRecorder(component): Creates recorder writing Kubernetes Events
+- DeploymentReference(namespace, name): Gets object reference of deployment for Events
+
 # Query Operations
-- GetPodIPs: Gets IPs of all pods in deployment
-- GetServiceIP: Gets service IP associated with deployment
+- GetReadyEndpoints(namespace, service, port): Gets ready endpoints of service from EndpointSlices
+- GetPodIPs: Gets IPs of all pods in deployment (deprecated, use GetReadyEndpoints)
+- GetServiceIP: Gets service IP associated with deployment (deprecated, use GetService)
 
 This package provides a simplified interface for managing Kubernetes resources,
 handling common operations for Deployments, Pods, and Services.
 */
 
-// NewK8sClient creates a new Kubernetes client
+// K8sClient wraps the Kubernetes clientset used by OpenAuth and oauthctl
 type K8sClient struct {
 	clientset *kubernetes.Clientset
+	config    *rest.Config
 }
 
-// NewK8sClient creates a new Kubernetes client
+// NewK8sClient creates a new Kubernetes client with the default resolution order
+// (KUBECONFIG, in-cluster config, ~/.kube/config). See NewK8sClientWithOptions.
 func NewK8sClient() (*K8sClient, error) {
-	home := homedir.HomeDir()
-	kubeconfig := filepath.Join(home, ".kube", "config")
-
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to build config: %v", err)
-	}
-
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create clientset: %v", err)
-	}
-
-	return &K8sClient{clientset: clientset}, nil
+	return NewK8sClientWithOptions(ClientOptions{})
 }
 
 // FindDeployment finds a specific deployment
@@ -97,6 +91,9 @@ func (c *K8sClient) FindDeployment(namespace, name string) (*appsv1.Deployment,
 }
 
 // GetPodIPs gets IPs of all pods in the deployment
+//
+// Deprecated: running pods are returned even when they are not Ready,
+// use GetReadyEndpoints with the deployment's service instead.
 func (c *K8sClient) GetPodIPs(deployment *appsv1.Deployment) ([]string, error) {
 	// Get label selector from deployment
 	labelSelector := metav1.FormatLabelSelector(deployment.Spec.Selector)
@@ -122,6 +119,9 @@ func (c *K8sClient) GetPodIPs(deployment *appsv1.Deployment) ([]string, error) {
 }
 
 // GetServiceIP gets the IP of the service associated with the deployment
+//
+// Deprecated: the first service whose selector matches the pod labels is
+// returned, use GetService or GetReadyEndpoints with the service name instead.
 func (c *K8sClient) GetServiceIP(deployment *appsv1.Deployment) (string, error) {
 
 	log.Printf("Deployment Name: %s", deployment.Name)
@@ -267,6 +267,32 @@ func (c *K8sClient) ListServices(namespace string) (*corev1.ServiceList, error)
 	)
 }
 
+func (c *K8sClient) GetConfigMap(namespace, name string) (*corev1.ConfigMap, error) {
+	return c.clientset.CoreV1().ConfigMaps(namespace).Get(
+		context.TODO(),
+		name,
+		metav1.GetOptions{},
+	)
+}
+
+// SecretData returns the data of a Secret, used for upstream credentials of filters
+func (c *K8sClient) SecretData(namespace, name string) (map[string][]byte, error) {
+	secret, err := c.clientset.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %v", namespace, name, err)
+	}
+	return secret.Data, nil
+}
+
+// ConfigMapData returns the data of a ConfigMap, used by policy filters
+func (c *K8sClient) ConfigMapData(namespace, name string) (map[string]string, error) {
+	cm, err := c.GetConfigMap(namespace, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get configmap %s/%s: %v", namespace, name, err)
+	}
+	return cm.Data, nil
+}
+
 // 유틸리티 함수들
 func (c *K8sClient) WaitForDeploymentReady(namespace, name string, timeout time.Duration) error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
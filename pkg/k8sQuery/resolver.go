@@ -0,0 +1,198 @@
+package k8sQuery
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const serviceNameIndex = "serviceName"
+
+// EndpointResolver resolves services to ready endpoints with client-side
+// round-robin load balancing. Services and EndpointSlices of a namespace are
+// watched with informers once the namespace is first resolved, so every
+// lookup sees the current set of ready endpoints.
+type EndpointResolver struct {
+	client      kubernetes.Interface
+	syncTimeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	namespaces map[string]*namespaceCache
+	counters   map[string]*atomic.Uint64
+}
+
+type namespaceCache struct {
+	ready    chan struct{}
+	err      error
+	stop     context.CancelFunc
+	services cache.Indexer
+	slices   cache.Indexer
+}
+
+// NewEndpointResolver creates a resolver, Stop releases its informers
+func NewEndpointResolver(client kubernetes.Interface) *EndpointResolver {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &EndpointResolver{
+		client:      client,
+		syncTimeout: 10 * time.Second,
+		ctx:         ctx,
+		cancel:      cancel,
+		namespaces:  make(map[string]*namespaceCache),
+		counters:    make(map[string]*atomic.Uint64),
+	}
+}
+
+// NewEndpointResolver creates a resolver on the client. See EndpointResolver.
+func (c *K8sClient) NewEndpointResolver() *EndpointResolver {
+	return NewEndpointResolver(c.clientset)
+}
+
+// Stop stops every informer of the resolver
+func (r *EndpointResolver) Stop() {
+	r.cancel()
+}
+
+// Endpoints returns the ready endpoints of the service port
+func (r *EndpointResolver) Endpoints(namespace, service, port string) ([]Endpoint, error) {
+	nc, err := r.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, exists, err := nc.services.GetByKey(namespace + "/" + service)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("service %s/%s not found", namespace, service)
+	}
+
+	objs, err := nc.slices.ByIndex(serviceNameIndex, namespace+"/"+service)
+	if err != nil {
+		return nil, err
+	}
+	slices := make([]discoveryv1.EndpointSlice, 0, len(objs))
+	for _, o := range objs {
+		slices = append(slices, *o.(*discoveryv1.EndpointSlice))
+	}
+
+	return ReadyEndpoints(obj.(*corev1.Service), slices, port)
+}
+
+// Addresses returns the "ip:port" of every ready endpoint of the service port
+func (r *EndpointResolver) Addresses(namespace, service, port string) ([]string, error) {
+	endpoints, err := r.Endpoints(namespace, service, port)
+	if err != nil {
+		return nil, err
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no ready endpoints for service %s/%s port %q", namespace, service, port)
+	}
+	addresses := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		addresses = append(addresses, endpoint.Address())
+	}
+	return addresses, nil
+}
+
+// Resolve picks the next ready endpoint of the service port, round-robin,
+// and returns its "ip:port" address
+func (r *EndpointResolver) Resolve(namespace, service, port string) (string, error) {
+	endpoints, err := r.Endpoints(namespace, service, port)
+	if err != nil {
+		return "", err
+	}
+	if len(endpoints) == 0 {
+		return "", fmt.Errorf("no ready endpoints for service %s/%s port %q", namespace, service, port)
+	}
+
+	key := namespace + "/" + service + ":" + port
+	r.mu.Lock()
+	counter, ok := r.counters[key]
+	if !ok {
+		counter = &atomic.Uint64{}
+		r.counters[key] = counter
+	}
+	r.mu.Unlock()
+
+	n := counter.Add(1) - 1
+	return endpoints[n%uint64(len(endpoints))].Address(), nil
+}
+
+// namespace starts the informers of a namespace on first use and waits for their sync
+func (r *EndpointResolver) namespace(namespace string) (*namespaceCache, error) {
+	r.mu.Lock()
+	nc, ok := r.namespaces[namespace]
+	if !ok {
+		nc = &namespaceCache{ready: make(chan struct{})}
+		r.namespaces[namespace] = nc
+		go r.startNamespace(namespace, nc)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-nc.ready:
+	case <-r.ctx.Done():
+		return nil, fmt.Errorf("endpoint resolver stopped")
+	}
+	if nc.err != nil {
+		return nil, nc.err
+	}
+	return nc, nil
+}
+
+func (r *EndpointResolver) startNamespace(namespace string, nc *namespaceCache) {
+	defer close(nc.ready)
+
+	nsCtx, nsCancel := context.WithCancel(r.ctx)
+
+	factory := informers.NewSharedInformerFactoryWithOptions(r.client, 10*time.Minute, informers.WithNamespace(namespace))
+	services := factory.Core().V1().Services().Informer()
+	slices := factory.Discovery().V1().EndpointSlices().Informer()
+	if err := slices.AddIndexers(cache.Indexers{serviceNameIndex: indexByServiceName}); err != nil {
+		nsCancel()
+		nc.err = fmt.Errorf("failed to index endpointslices: %v", err)
+		return
+	}
+	factory.Start(nsCtx.Done())
+
+	ctx, cancel := context.WithTimeout(r.ctx, r.syncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), services.HasSynced, slices.HasSynced) {
+		nsCancel()
+		nc.err = fmt.Errorf("timed out watching endpoints in namespace %s", namespace)
+
+		// let the next lookup retry the namespace
+		r.mu.Lock()
+		delete(r.namespaces, namespace)
+		r.mu.Unlock()
+		return
+	}
+
+	nc.stop = nsCancel
+	nc.services = services.GetIndexer()
+	nc.slices = slices.GetIndexer()
+}
+
+func indexByServiceName(obj interface{}) ([]string, error) {
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return nil, nil
+	}
+	name, ok := slice.Labels[discoveryv1.LabelServiceName]
+	if !ok {
+		return nil, nil
+	}
+	return []string{slice.Namespace + "/" + name}, nil
+}
@@ -0,0 +1,77 @@
+package k8sQuery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEndpointResolver(t *testing.T) {
+	portName, port := "http", int32(8080)
+	notReady := false
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "user-auth", Namespace: "auth"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+	}
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "user-auth-abcde",
+			Namespace: "auth",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "user-auth"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       []discoveryv1.EndpointPort{{Name: &portName, Port: &port}},
+		Endpoints: []discoveryv1.Endpoint{
+			{Addresses: []string{"10.0.0.1"}},
+			{Addresses: []string{"10.0.0.2"}},
+			{Addresses: []string{"10.0.0.3"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}},
+		},
+	}
+	client := fake.NewSimpleClientset(svc, slice)
+
+	resolver := NewEndpointResolver(client)
+	defer resolver.Stop()
+
+	seen := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		address, err := resolver.Resolve("auth", "user-auth", "80")
+		if err != nil {
+			t.Fatalf("Resolve() error = %v", err)
+		}
+		seen[address]++
+	}
+	if seen["10.0.0.1:8080"] != 2 || seen["10.0.0.2:8080"] != 2 {
+		t.Errorf("Expected round-robin over ready endpoints, got %v", seen)
+	}
+	if addresses, err := resolver.Addresses("auth", "user-auth", "80"); err != nil || len(addresses) != 2 {
+		t.Errorf("Addresses() = %v, %v, want both ready endpoints", addresses, err)
+	}
+
+	// endpoints changes are picked up by the informer
+	updated := slice.DeepCopy()
+	updated.Endpoints = []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.9"}}}
+	if _, err := client.DiscoveryV1().EndpointSlices("auth").Update(context.Background(), updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update endpointslice: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		address, err := resolver.Resolve("auth", "user-auth", "http")
+		if err == nil && address == "10.0.0.9:8080" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("endpoint change not observed, last address %q, err %v", address, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := resolver.Resolve("auth", "missing", "80"); err == nil {
+		t.Error("expected error for unknown service")
+	}
+}
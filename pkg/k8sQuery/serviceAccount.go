@@ -10,7 +10,6 @@ import (
 	authenticationv1 "k8s.io/api/authentication/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 )
 
 // ServiceAccountConfig는 허용된 ServiceAccount 설정을 관리합니다
@@ -41,7 +40,7 @@ AuthResponse:
 - Contains authorization status, username, and potential error messages
 
 # Main Functions
-- NewTokenValidator(saConfig): Creates new TokenValidator instance with given configuration
+- NewTokenValidator(client, saConfig): Creates new TokenValidator instance on the shared client with given configuration
 - ValidateToken(token): Validates ServiceAccount token and checks if it's allowed
 - validateServiceAccountToken(token): Basic token validation without allowed account checking
 - isAllowedServiceAccount(namespace, name): Checks if ServiceAccount is in allowed list
@@ -62,16 +61,10 @@ The package provides token validation and authentication middleware for Kubernet
 ServiceAccounts, ensuring only allowed service accounts can access protected resources.
 */
 
-// NewTokenValidator는 새로운 TokenValidator를 생성
-func NewTokenValidator(saConfig ServiceAccountConfig) (*TokenValidator, error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get in-cluster config: %v", err)
-	}
-
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create kubernetes client: %v", err)
+// NewTokenValidator는 주어진 K8sClient로 새로운 TokenValidator를 생성
+func NewTokenValidator(client *K8sClient, saConfig ServiceAccountConfig) (*TokenValidator, error) {
+	if client == nil {
+		return nil, fmt.Errorf("kubernetes client is required")
 	}
 
 	// 기본 설정이 없는 경우 기본값 설정
@@ -83,24 +76,13 @@ func NewTokenValidator(saConfig ServiceAccountConfig) (*TokenValidator, error) {
 	}
 
 	return &TokenValidator{
-		k8sClient: clientset,
+		k8sClient: client.Clientset(),
 		config:    saConfig,
 	}, nil
 }
 
-func validateServiceAccountToken(token string) (bool, error) {
-	// 클러스터 내부 설정 가져오기
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return false, fmt.Errorf("failed to get cluster config: %v", err)
-	}
-
-	// 쿠버네티스 클라이언트 생성
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return false, fmt.Errorf("failed to create client: %v", err)
-	}
-
+// validateServiceAccountToken은 서버의 클라이언트 (-kubeconfig, -context 포함)로 토큰만 검증
+func (tv *TokenValidator) validateServiceAccountToken(token string) (bool, error) {
 	// TokenReview 객체 생성
 	tokenReview := &authenticationv1.TokenReview{
 		Spec: authenticationv1.TokenReviewSpec{
@@ -109,7 +91,7 @@ func validateServiceAccountToken(token string) (bool, error) {
 	}
 
 	// API 서버에 검증
-	result, err := clientset.AuthenticationV1().TokenReviews().Create(
+	result, err := tv.k8sClient.AuthenticationV1().TokenReviews().Create(
 		context.TODO(),
 		tokenReview,
 		metav1.CreateOptions{},
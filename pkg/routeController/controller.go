@@ -0,0 +1,332 @@
+package routeController
+
+import (
+	"OpenAuth/pkg/configServer"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+/*
+Package routeController merges AuthRoute and AuthPolicy custom resources into
+the live OpenAuth configuration.
+
+Every replica runs the controller in-process. On any change of the watched
+resources it rebuilds the full set of accepted routes and hands it to
+OnChange. The result of each resource is written back to
+.status.conditions (type Accepted) and reported as a Kubernetes Event.
+
+# Condition reasons
+- Accepted: the route is served
+- InvalidSpec: the spec could not be decoded or failed validation
+- PolicyNotFound: a referenced AuthPolicy does not exist or is invalid
+- Conflict: another AuthRoute created earlier, or the base configuration,
+  owns the same method and path
+
+Routes are only reported as Accepted after OnChange served them.
+*/
+
+const (
+	ConditionAccepted = "Accepted"
+
+	ReasonAccepted       = "Accepted"
+	ReasonInvalidSpec    = "InvalidSpec"
+	ReasonPolicyNotFound = "PolicyNotFound"
+	ReasonConflict       = "Conflict"
+)
+
+// Options configures a Controller
+type Options struct {
+	// Namespace limits the watch to one namespace, empty watches all
+	Namespace string
+
+	// Validate checks a merged route before it is accepted
+	Validate func(configServer.RouteConfig) error
+
+	// OnChange receives every accepted route after each reconcile and
+	// returns the routes it could not serve, keyed by "METHOD path"
+	OnChange func([]configServer.RouteConfig) map[string]Rejection
+}
+
+// Rejection is the reason OnChange did not serve a route
+type Rejection struct {
+	Reason  string
+	Message string
+}
+
+// Controller watches AuthRoute/AuthPolicy resources
+type Controller struct {
+	client   dynamic.Interface
+	recorder record.EventRecorder
+	opts     Options
+
+	routes   cache.GenericLister
+	policies cache.GenericLister
+	synced   []cache.InformerSynced
+	factory  dynamicinformer.DynamicSharedInformerFactory
+
+	trigger chan struct{}
+}
+
+// NewController creates a controller talking to the cluster of restConfig
+func NewController(restConfig *rest.Config, opts Options) (*Controller, error) {
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clientset: %v", err)
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "openauth"})
+
+	return newController(client, recorder, opts), nil
+}
+
+func newController(client dynamic.Interface, recorder record.EventRecorder, opts Options) *Controller {
+	if opts.Validate == nil {
+		opts.Validate = func(route configServer.RouteConfig) error { return route.Validate() }
+	}
+
+	c := &Controller{
+		client:   client,
+		recorder: recorder,
+		opts:     opts,
+		factory:  dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, 10*time.Minute, opts.Namespace, nil),
+		trigger:  make(chan struct{}, 1),
+	}
+
+	for _, gvr := range []schema.GroupVersionResource{AuthRouteResource, AuthPolicyResource} {
+		informer := c.factory.ForResource(gvr)
+		informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(interface{}) { c.enqueue() },
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				// status updates written by the controller do not change the generation
+				if oldObj.(*unstructured.Unstructured).GetGeneration() == newObj.(*unstructured.Unstructured).GetGeneration() {
+					return
+				}
+				c.enqueue()
+			},
+			DeleteFunc: func(interface{}) { c.enqueue() },
+		})
+		c.synced = append(c.synced, informer.Informer().HasSynced)
+	}
+	c.routes = c.factory.ForResource(AuthRouteResource).Lister()
+	c.policies = c.factory.ForResource(AuthPolicyResource).Lister()
+
+	return c
+}
+
+// Run starts the informers, reconciles once and keeps reconciling on
+// changes in the background until ctx is cancelled.
+func (c *Controller) Run(ctx context.Context) error {
+	c.factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		return fmt.Errorf("timed out waiting for AuthRoute informers to sync")
+	}
+
+	c.reconcile(ctx)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.trigger:
+				c.reconcile(ctx)
+			}
+		}
+	}()
+	return nil
+}
+
+// enqueue coalesces bursts of events into a single reconcile
+func (c *Controller) enqueue() {
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// reconcile rebuilds the accepted routes from the cached resources
+func (c *Controller) reconcile(ctx context.Context) {
+	policies := c.loadPolicies(ctx)
+
+	objs, err := c.routes.List(labels.Everything())
+	if err != nil {
+		log.Printf("failed to list AuthRoutes: %v", err)
+		return
+	}
+	routes := make([]*unstructured.Unstructured, 0, len(objs))
+	for _, obj := range objs {
+		routes = append(routes, obj.(*unstructured.Unstructured))
+	}
+	sortByAge(routes)
+
+	accepted := make([]configServer.RouteConfig, 0, len(routes))
+	acceptedObjs := make([]*unstructured.Unstructured, 0, len(routes))
+	owners := make(map[string]string)
+	for _, obj := range routes {
+		route, reason, err := c.buildRoute(obj, policies)
+		if err == nil {
+			key := route.Method + " " + route.Path
+			if owner, exists := owners[key]; exists {
+				reason, err = ReasonConflict, fmt.Errorf("%s is already served by AuthRoute %s", key, owner)
+			} else {
+				owners[key] = obj.GetNamespace() + "/" + obj.GetName()
+			}
+		}
+
+		if err != nil {
+			c.setAccepted(ctx, AuthRouteResource, obj, metav1.ConditionFalse, reason, err.Error())
+			continue
+		}
+		accepted = append(accepted, route)
+		acceptedObjs = append(acceptedObjs, obj)
+	}
+
+	rejected := c.opts.OnChange(accepted)
+	for i, route := range accepted {
+		if rejection, ok := rejected[route.Method+" "+route.Path]; ok {
+			c.setAccepted(ctx, AuthRouteResource, acceptedObjs[i], metav1.ConditionFalse, rejection.Reason, rejection.Message)
+			continue
+		}
+		c.setAccepted(ctx, AuthRouteResource, acceptedObjs[i], metav1.ConditionTrue, ReasonAccepted,
+			fmt.Sprintf("serving %s %s", route.Method, route.Path))
+	}
+}
+
+// Resync reconciles again, e.g. after the base configuration changed which
+// routes are served
+func (c *Controller) Resync() {
+	c.enqueue()
+}
+
+// loadPolicies decodes every AuthPolicy, keyed by namespace/name
+func (c *Controller) loadPolicies(ctx context.Context) map[string]AuthPolicySpec {
+	policies := make(map[string]AuthPolicySpec)
+
+	objs, err := c.policies.List(labels.Everything())
+	if err != nil {
+		log.Printf("failed to list AuthPolicies: %v", err)
+		return policies
+	}
+
+	for _, item := range objs {
+		obj := item.(*unstructured.Unstructured)
+		var spec AuthPolicySpec
+		if err := decodeSpec(obj, &spec); err != nil {
+			c.setAccepted(ctx, AuthPolicyResource, obj, metav1.ConditionFalse, ReasonInvalidSpec, err.Error())
+			continue
+		}
+		policies[obj.GetNamespace()+"/"+obj.GetName()] = spec
+		c.setAccepted(ctx, AuthPolicyResource, obj, metav1.ConditionTrue, ReasonAccepted, "policy is valid")
+	}
+	return policies
+}
+
+// buildRoute merges the referenced policies into the route and validates it
+func (c *Controller) buildRoute(obj *unstructured.Unstructured, policies map[string]AuthPolicySpec) (configServer.RouteConfig, string, error) {
+	var spec AuthRouteSpec
+	if err := decodeSpec(obj, &spec); err != nil {
+		return configServer.RouteConfig{}, ReasonInvalidSpec, err
+	}
+	route := spec.RouteConfig
+	route.Namespace = obj.GetNamespace()
+
+	// apply in reverse so the first listed policy runs first
+	for i := len(spec.Policies) - 1; i >= 0; i-- {
+		policy, ok := policies[obj.GetNamespace()+"/"+spec.Policies[i]]
+		if !ok {
+			return route, ReasonPolicyNotFound, fmt.Errorf("AuthPolicy %s not found or invalid", spec.Policies[i])
+		}
+		if err := applyPolicy(&route, policy); err != nil {
+			return route, ReasonInvalidSpec, fmt.Errorf("AuthPolicy %s: %v", spec.Policies[i], err)
+		}
+	}
+
+	if err := c.opts.Validate(route); err != nil {
+		return route, ReasonInvalidSpec, err
+	}
+	return route, "", nil
+}
+
+// setAccepted writes the Accepted condition and emits an Event when it changed
+func (c *Controller) setAccepted(ctx context.Context, gvr schema.GroupVersionResource, obj *unstructured.Unstructured,
+	status metav1.ConditionStatus, reason, message string) {
+
+	var current struct {
+		Conditions []metav1.Condition `json:"conditions,omitempty"`
+	}
+	if raw, found, _ := unstructured.NestedMap(obj.Object, "status"); found {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &current); err != nil {
+			log.Printf("ignoring invalid status of %s %s/%s: %v", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+		}
+	}
+
+	changed := meta.SetStatusCondition(&current.Conditions, metav1.Condition{
+		Type:               ConditionAccepted,
+		Status:             status,
+		ObservedGeneration: obj.GetGeneration(),
+		Reason:             reason,
+		Message:            message,
+	})
+	if !changed {
+		return
+	}
+
+	eventType := corev1.EventTypeNormal
+	if status != metav1.ConditionTrue {
+		eventType = corev1.EventTypeWarning
+	}
+	c.recorder.Event(obj, eventType, reason, message)
+
+	raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&current)
+	if err != nil {
+		log.Printf("failed to convert status of %s %s/%s: %v", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+		return
+	}
+
+	updated := obj.DeepCopy()
+	if err := unstructured.SetNestedField(updated.Object, raw["conditions"], "status", "conditions"); err != nil {
+		log.Printf("failed to set status of %s %s/%s: %v", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+		return
+	}
+
+	// every replica reconciles the same state, a conflict means another one already wrote it
+	if _, err := c.client.Resource(gvr).Namespace(obj.GetNamespace()).UpdateStatus(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		log.Printf("failed to update status of %s %s/%s: %v", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+	}
+}
+
+// sortByAge orders resources oldest first, so the first owner of a path keeps it
+func sortByAge(objs []*unstructured.Unstructured) {
+	sort.Slice(objs, func(i, j int) bool {
+		ti, tj := objs[i].GetCreationTimestamp(), objs[j].GetCreationTimestamp()
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return objs[i].GetNamespace()+"/"+objs[i].GetName() < objs[j].GetNamespace()+"/"+objs[j].GetName()
+	})
+}
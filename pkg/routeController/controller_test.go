@@ -0,0 +1,161 @@
+package routeController
+
+import (
+	"OpenAuth/pkg/configServer"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func newResource(kind, namespace, name string, created time.Time, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": Group + "/" + Version,
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":              name,
+			"namespace":         namespace,
+			"generation":        int64(1),
+			"creationTimestamp": created.UTC().Format(time.RFC3339),
+		},
+		"spec": spec,
+	}}
+	return obj
+}
+
+func TestControllerReconcile(t *testing.T) {
+	now := time.Now()
+	objects := []runtime.Object{
+		newResource("AuthPolicy", "team-a", "tenant-header", now, map[string]interface{}{
+			"filters": []interface{}{
+				map[string]interface{}{
+					"type":        "condition",
+					"filter_name": "internal",
+					"conditions": []interface{}{
+						map[string]interface{}{"field": "header", "key": "X-Internal", "operator": "equals", "value": "true"},
+					},
+				},
+			},
+			"condition_filter": map[string]interface{}{
+				"filter_name": "tenant",
+				"conditions": []interface{}{
+					map[string]interface{}{"field": "header", "key": "X-Tenant", "operator": "equals", "value": "team-a"},
+				},
+			},
+		}),
+		newResource("AuthRoute", "team-a", "signin", now, map[string]interface{}{
+			"path":         "/team-a/signin",
+			"method":       "POST",
+			"handler_type": "login",
+			"policies":     []interface{}{"tenant-header"},
+			"request_filters": []interface{}{
+				map[string]interface{}{"filter_name": "user-auth", "remote_server": "http://user-auth/login"},
+			},
+		}),
+		newResource("AuthRoute", "team-b", "signin-copy", now.Add(time.Minute), map[string]interface{}{
+			"path":         "/team-a/signin",
+			"method":       "POST",
+			"handler_type": "login",
+		}),
+		newResource("AuthRoute", "team-b", "missing-policy", now, map[string]interface{}{
+			"path":         "/team-b/verify",
+			"method":       "POST",
+			"handler_type": "verify",
+			"policies":     []interface{}{"does-not-exist"},
+		}),
+		newResource("AuthRoute", "team-b", "base-owned", now, map[string]interface{}{
+			"path":         "/team-b/signin",
+			"method":       "POST",
+			"handler_type": "login",
+		}),
+		newResource("AuthRoute", "team-b", "bad-method", now, map[string]interface{}{
+			"path":         "/team-b/signup",
+			"method":       "FETCH",
+			"handler_type": "signup",
+		}),
+	}
+
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		AuthRouteResource:  "AuthRouteList",
+		AuthPolicyResource: "AuthPolicyList",
+	}, objects...)
+	recorder := record.NewFakeRecorder(20)
+
+	changes := make(chan []configServer.RouteConfig, 10)
+	controller := newController(client, recorder, Options{
+		OnChange: func(routes []configServer.RouteConfig) map[string]Rejection {
+			changes <- routes
+			// the base configuration owns the path
+			return map[string]Rejection{"POST /team-b/signin": {Reason: ReasonConflict, Message: "owned by the base configuration"}}
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := controller.Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	var routes []configServer.RouteConfig
+	select {
+	case routes = <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for reconcile")
+	}
+
+	if len(routes) != 2 {
+		t.Fatalf("Expected 2 accepted routes, got %d: %+v", len(routes), routes)
+	}
+	if routes[0].Path != "/team-a/signin" {
+		t.Errorf("unexpected route: %+v", routes[0])
+	}
+	// policy filters run first, in the order of the policy, then the route's own
+	specs, err := routes[0].FilterSpecs()
+	if err != nil {
+		t.Fatalf("FilterSpecs() error = %v", err)
+	}
+	var order []string
+	for _, spec := range specs {
+		order = append(order, spec.Name)
+		if spec.Namespace != "team-a" {
+			t.Errorf("filter %s has namespace %q, want the namespace of the AuthRoute", spec.Name, spec.Namespace)
+		}
+	}
+	if strings.Join(order, ",") != "tenant,internal,user-auth" {
+		t.Errorf("policy was not merged into route, filter order = %v", order)
+	}
+
+	expected := map[string]string{
+		"team-a/signin":         ReasonAccepted,
+		"team-b/signin-copy":    ReasonConflict,
+		"team-b/missing-policy": ReasonPolicyNotFound,
+		"team-b/base-owned":     ReasonConflict,
+		"team-b/bad-method":     ReasonInvalidSpec,
+	}
+	for key, reason := range expected {
+		namespace, name, _ := strings.Cut(key, "/")
+		obj, err := client.Resource(AuthRouteResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get %s: %v", key, err)
+		}
+		conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+		if len(conditions) != 1 {
+			t.Errorf("%s: expected 1 condition, got %v", key, conditions)
+			continue
+		}
+		if got := conditions[0].(map[string]interface{})["reason"]; got != reason {
+			t.Errorf("%s: expected reason %s, got %v", key, reason, got)
+		}
+	}
+
+	if len(recorder.Events) == 0 {
+		t.Error("expected events to be recorded")
+	}
+}
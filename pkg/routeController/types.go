@@ -0,0 +1,74 @@
+package routeController
+
+import (
+	"OpenAuth/pkg/configServer"
+	"OpenAuth/pkg/configServer/filters"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Group and version of the OpenAuth custom resources, see configs/crds
+const (
+	Group   = "openauth.io"
+	Version = "v1alpha1"
+)
+
+var (
+	AuthRouteResource  = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "authroutes"}
+	AuthPolicyResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "authpolicies"}
+)
+
+// AuthRouteSpec is a single route owned by an application team.
+// The fields match a route of configServer.Config, plus references to
+// AuthPolicies in the same namespace whose filters run before the route's own.
+type AuthRouteSpec struct {
+	configServer.RouteConfig `yaml:",inline"`
+	Policies                 []string `yaml:"policies,omitempty"`
+}
+
+// AuthPolicySpec is a reusable set of filters shared by AuthRoutes
+type AuthPolicySpec struct {
+	Filters         []filters.Spec           `yaml:"filters,omitempty"`
+	RequestFilters  []filters.RequestFilter  `yaml:"request_filters"`
+	ConditionFilter *filters.ConditionFilter `yaml:"condition_filter,omitempty"`
+}
+
+// decodeSpec converts the spec of an unstructured object into out.
+// The spec is round-tripped through YAML so the resources accept exactly
+// the same field names as the configuration pushed to /config.
+func decodeSpec(obj *unstructured.Unstructured, out interface{}) error {
+	spec, found, err := unstructured.NestedMap(obj.Object, "spec")
+	if err != nil {
+		return fmt.Errorf("invalid spec: %v", err)
+	}
+	if !found {
+		return fmt.Errorf("spec is required")
+	}
+
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("invalid spec: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, out); err != nil {
+		return fmt.Errorf("invalid spec: %v", err)
+	}
+	return nil
+}
+
+// applyPolicy prepends the policy's filters to the route, in the order
+// request_filters, condition_filter, filters of the policy
+func applyPolicy(route *configServer.RouteConfig, policy AuthPolicySpec) error {
+	specs, err := configServer.RouteConfig{
+		RequestFilters:  policy.RequestFilters,
+		ConditionFilter: policy.ConditionFilter,
+		Filters:         policy.Filters,
+	}.FilterSpecs()
+	if err != nil {
+		return err
+	}
+	route.PolicyFilters = append(specs, route.PolicyFilters...)
+	return nil
+}